@@ -1,13 +1,32 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/jzelinskie/faq/pkg/faq"
 	"github.com/jzelinskie/faq/pkg/pflagutil"
 )
 
+// Exit statuses other than 0 and 1. Those of --exit-status and errors are the
+// same as jq's.
+const (
+	// exitDecode is the exit status when an input cannot be decoded.
+	exitDecode = 2
+	// exitCompile is the exit status when the program fails to compile.
+	exitCompile = 3
+	// exitNoResults is the exit status with --exit-status when the program
+	// produced no results.
+	exitNoResults = 4
+	// exitTimeout is the exit status when --timeout expires, the same as
+	// timeout(1).
+	exitTimeout = 124
+)
+
 func main() {
 	var flags flags
 
@@ -15,17 +34,28 @@ func main() {
 	jsonKwargsFlag := pflagutil.NewKwargJSONFlag(&flags.Jsonkwargs)
 	stringPositionalArgsFlag := pflagutil.NewPositionalArgStringFlag(&flags.Args)
 	jsonPositionalArgsFlag := pflagutil.NewPositionalArgJSONFlag(&flags.Jsonargs)
+	namedArgFlag := pflagutil.NewKwargStringFlag(&flags.NamedArgs)
+	namedJSONArgFlag := pflagutil.NewKwargJSONFlag(&flags.NamedJSONArgs)
+	rawFileFlag := pflagutil.NewKwargStringFlag(&flags.RawFiles)
+	slurpFileFlag := pflagutil.NewKwargStringFlag(&flags.SlurpFiles)
+	dataFileFlag := pflagutil.NewKwargStringFlag(&flags.DataFiles)
 
 	var rootCmd = &cobra.Command{
 		Use:   "faq [flags] [filter string] [files...]",
 		Short: "format agnostic querier",
 		Long: `faq is a tool intended to be a more flexible jq, supporting additional formats.
-The additional formats are converted into JSON and processed with libjq.
+The additional formats are converted into JSON and processed with libjq or gojq.
 
 Supported formats:
 - BSON
 - Bencode
+- CBOR
+- CSV and TSV
+- HCL (Terraform and Nomad)
+- INI, gitconfig and systemd units
 - JSON
+- Lines and raw text
+- MessagePack
 - Property Lists
 - TOML
 - XML
@@ -44,23 +74,77 @@ https://xyproto.github.io/splash/docs/
 	rootCmd.Flags().BoolVar(&flags.Debug, "debug", false, "enable debug logging")
 	rootCmd.Flags().StringVarP(&flags.InputFormat, "input-format", "f", "auto", "input format")
 	rootCmd.Flags().StringVarP(&flags.OutputFormat, "output-format", "o", "auto", "output format")
+	rootCmd.Flags().StringVar(&flags.Engine, "engine", faq.DefaultEngine(), fmt.Sprintf("jq engine used to execute the program (%s); libjq keeps the order of keys, while gojq, the only engine without cgo, sorts them", strings.Join(faq.Engines(), ", ")))
+	rootCmd.Flags().BoolVar(&flags.LosslessNumbers, "lossless-numbers", false, "write numbers of the results that are equal to a number of the input exactly as the input wrote them, such as integers beyond 2^53 and decimals like 1.50")
 	rootCmd.Flags().StringVarP(&flags.ProgramFile, "program-file", "F", "", "If specified, read the file provided as the jq program for faq.")
 	rootCmd.Flags().BoolVarP(&flags.Raw, "raw-output", "r", false, "output raw strings, not JSON texts")
 	rootCmd.Flags().BoolVarP(&flags.Color, "color-output", "C", true, "colorize the output")
 	rootCmd.Flags().BoolVarP(&flags.Monochrome, "monochrome-output", "M", false, "monochrome (don't colorize the output)")
 	rootCmd.Flags().BoolVarP(&flags.Pretty, "pretty-output", "p", true, "pretty-printed output")
 	rootCmd.Flags().BoolVarP(&flags.Compact, "compact-output", "c", false, "compact output (don't pretty print the output)")
+	rootCmd.Flags().BoolVar(&flags.Edit, "edit", false, "write the results of each YAML or TOML file as edits of the file, keeping its comments and formatting where values didn't change")
+	rootCmd.Flags().BoolVar(&flags.Reencode, "reencode", false, "with --edit, re-encode files that can't be edited, losing their comments and formatting, instead of failing")
+	rootCmd.Flags().BoolVarP(&flags.InPlace, "in-place", "i", false, "write the results of each file back to the file in its own format instead of to stdout, replacing it atomically")
+	rootCmd.Flags().BoolVar(&flags.AllowEmpty, "allow-empty", false, "with --in-place, empty the files the program produces no results for instead of failing")
+	rootCmd.Flags().StringVar(&flags.BackupSuffix, "backup-suffix", "", "with --in-place, keep a copy of each file's original contents at its path with this suffix appended")
+	rootCmd.Flags().BoolVar(&flags.DryRun, "dry-run", false, "with --in-place, print a unified diff of the changes instead of making them")
+	rootCmd.Flags().StringVar(&flags.CSVDelimiter, "csv-delimiter", ",", "character separating the fields of CSV")
+	rootCmd.Flags().BoolVar(&flags.CSVNoHeader, "csv-no-header", false, "read CSV and TSV rows as arrays rather than as objects keyed by the first row, and write no header row")
+	rootCmd.Flags().StringVar(&flags.CSVQuote, "csv-quote", "minimal", "how CSV and TSV fields are quoted (minimal, all, none)")
+	rootCmd.Flags().BoolVarP(&flags.RawInput, "raw-input", "R", false, "read each line of the input as a string rather than parsing it; with --slurp, read all of the input as one string")
 	rootCmd.Flags().BoolVarP(&flags.Slurp, "slurp", "s", false, "read (slurp) all inputs into an array; apply filter to it")
+	rootCmd.Flags().BoolVar(&flags.Stream, "stream", false, "parse the input in streaming fashion, producing [path, leaf] and [path] events like jq --stream")
+	rootCmd.Flags().IntVarP(&flags.Jobs, "jobs", "j", 1, "number of input files to decode and process concurrently, unless the program may call input or inputs")
+	rootCmd.Flags().BoolVar(&flags.Unordered, "unordered", false, "with --jobs, write the results of each file as soon as they're ready rather than in the order the files were given")
+	rootCmd.Flags().IntVar(&flags.Limit, "limit", 0, "stop after writing this many results (0 means no limit)")
+	rootCmd.Flags().BoolVar(&flags.First, "first", false, "stop after writing the first result, the same as --limit 1")
+	rootCmd.Flags().DurationVar(&flags.Timeout, "timeout", 0, "stop and exit with status 124 if processing takes longer than this duration, e.g. 30s (0 means no timeout)")
+	rootCmd.Flags().BoolVarP(&flags.ExitStatus, "exit-status", "e", false, "exit with status 1 if the last result was false or null, or 4 if there were no results")
 	rootCmd.Flags().BoolVarP(&flags.ProvideNull, "null-input", "n", false, "use `null` as the single input value")
 	rootCmd.Flags().Var(stringPositionalArgsFlag, "args", `Takes a value and adds it to the position arguments list. Values are always strings. Positional arguments are available as $ARGS.positional[]. Specify --args multiple times to pass additional arguments.`)
 	rootCmd.Flags().Var(jsonPositionalArgsFlag, "jsonargs", `Takes a value and adds it to the position arguments list. Values are parsed as JSON values. Positional arguments are available as $ARGS.positional[]. Specify --jsonargs multiple times to pass additional arguments.`)
 	rootCmd.Flags().Var(stringKwargsFlag, "kwargs", `Takes a key=value pair, setting $key to <value>: --kwargs foo=bar sets $foo to "bar". Values are always strings. Named arguments are also available as $ARGS.named[]. Specify --kwargs multiple times to add more arguments.`)
 	rootCmd.Flags().Var(jsonKwargsFlag, "jsonkwargs", `Takes a key=value pair, setting $key to the JSON value of <value>: --kwargs foo={"fizz": "buzz"} sets $foo to the json object {"fizz": "buzz"}. Values are parsed as JSON values. Named arguments are also available as $ARGS.named[]. Specify --jsonkwargs multiple times to add more arguments.`)
+	rootCmd.Flags().Var(namedArgFlag, "arg", "Takes a `name value` pair, setting $name to the string value, like jq. Also available as $ARGS.named[]. Specify --arg multiple times to add more arguments.")
+	rootCmd.Flags().Var(namedJSONArgFlag, "argjson", "Takes a `name value` pair, setting $name to the JSON value, like jq. Also available as $ARGS.named[]. Specify --argjson multiple times to add more arguments.")
+	rootCmd.Flags().Var(rawFileFlag, "rawfile", "Takes a `name path` pair, setting $name to the contents of the file as a string, like jq. Also available as $ARGS.named[].")
+	rootCmd.Flags().Var(slurpFileFlag, "slurpfile", "Takes a `name path` pair, setting $name to an array of the JSON values in the file, like jq. Also available as $ARGS.named[].")
+	rootCmd.Flags().Var(dataFileFlag, "datafile", "Takes a `name=path[:format]` pair, setting $name to the value of the file decoded from any supported format, or to an array of its documents if it has several. The format is detected if it isn't given. Also available as $ARGS.named[].")
 	rootCmd.Flags().BoolVarP(&flags.PrintVersion, "version", "v", false, "Print the version and exit.")
 
 	_ = rootCmd.Flags().MarkHidden("debug")
 
-	if err := rootCmd.Execute(); err != nil {
+	// --arg and the other flags taking two arguments are rewritten to take a
+	// single name=value argument, which pflag can parse, wherever they are
+	// before a "--".
+	args, err := pflagutil.JoinPairArgs(rootCmd.Flags(), os.Args[1:], "arg", "argjson", "rawfile", "slurpfile")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
+	rootCmd.SetArgs(args)
+
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(exitStatus(err))
+	}
+}
+
+// exitStatus returns the exit status for an error returned by runCmdFunc.
+func exitStatus(err error) int {
+	var compileErr *faq.CompileError
+	var decodeErr *faq.DecodeError
+	var haltErr *faq.HaltError
+	switch {
+	case errors.As(err, &haltErr):
+		return haltErr.ExitCode
+	case errors.Is(err, faq.ErrTimeout):
+		return exitTimeout
+	case errors.Is(err, faq.ErrNoResults):
+		return exitNoResults
+	case errors.As(err, &compileErr):
+		return exitCompile
+	case errors.As(err, &decodeErr):
+		return exitDecode
+	}
+	return 1
 }
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh/terminal"
 
-	"github.com/jzelinskie/faq/internal/faq"
 	"github.com/jzelinskie/faq/internal/version"
+	"github.com/jzelinskie/faq/pkg/faq"
 	"github.com/jzelinskie/faq/pkg/objconv"
 )
 
@@ -30,6 +36,45 @@ func runCmdFunc(cmd *cobra.Command, args []string, flags flags) error {
 		return errors.New("no arguments provided")
 	}
 
+	if flags.Timeout < 0 {
+		return fmt.Errorf("invalid --timeout %s, must not be negative", flags.Timeout)
+	}
+
+	if flags.Limit < 0 {
+		return fmt.Errorf("invalid --limit %d, must not be negative", flags.Limit)
+	}
+
+	limit := flags.Limit
+	if flags.First {
+		if cmd.Flags().Changed("limit") {
+			return errors.New("--first and --limit cannot be used together")
+		}
+		limit = 1
+	}
+
+	if flags.Jobs < 1 {
+		return fmt.Errorf("invalid --jobs %d, must be at least 1", flags.Jobs)
+	}
+
+	if !flags.InPlace && (flags.DryRun || flags.AllowEmpty || cmd.Flags().Changed("backup-suffix")) {
+		return errors.New("--dry-run, --allow-empty and --backup-suffix require --in-place")
+	}
+	if flags.InPlace {
+		switch {
+		case flags.ProvideNull || flags.Slurp:
+			return errors.New("--in-place cannot be used with --null-input or --slurp")
+		case cmd.Flags().Changed("output-format"):
+			return errors.New("--in-place writes each file in its own format and cannot be used with --output-format")
+		case limit > 0:
+			return errors.New("--in-place cannot be used with --limit or --first, which would cut files short")
+		}
+	}
+
+	csv, err := csvOptions(flags)
+	if err != nil {
+		return err
+	}
+
 	outputFile := os.Stdout
 
 	// If monochrome is true, disable color, as it takes higher precedence then
@@ -46,11 +91,13 @@ func runCmdFunc(cmd *cobra.Command, args []string, flags flags) error {
 		color = flags.Color && !flags.Monochrome
 	}
 
+	// CBOR is written to a terminal in diagnostic notation, since its binary
+	// encoding can't be read there.
+	cborDiagnostic := terminal.IsTerminal(int(outputFile.Fd()))
+
 	// Check to see execution is in an interactive terminal and set the args
 	// and flags as such.
 	var program string
-
-	var files []faq.File
 	if flags.ProgramFile != "" {
 		programBytes, err := ioutil.ReadFile(flags.ProgramFile)
 		if err != nil {
@@ -64,90 +111,192 @@ func runCmdFunc(cmd *cobra.Command, args []string, flags flags) error {
 		args = args[1:]
 	}
 
-	if flags.ProvideNull {
-		if flags.InputFormat == "auto" {
-			flags.InputFormat = "json"
-		}
-		// Set output format to json if not explicitly set.
-		if !cmd.Flags().Changed("output-format") {
-			flags.OutputFormat = "json"
-		}
-	} else {
-		if len(args) == 0 {
-			files = []faq.File{faq.NewFile("/dev/stdin", os.Stdin)}
-		} else if len(args) != 0 {
-			// Verify all files exist, and open them.
-			for _, path := range args {
-				file, err := faq.OpenFile(path)
-				if err != nil {
-					return err
-				}
-				defer file.Close()
-				files = append(files, file)
+	if flags.InPlace && len(args) == 0 {
+		return errors.New("--in-place requires files to write to")
+	}
+
+	// With --null-input, the inputs are only read by the input and inputs
+	// builtins.
+	var inputs []faq.Input
+	if len(args) == 0 {
+		inputs = []faq.Input{{Name: "/dev/stdin", Reader: os.Stdin}}
+	} else if len(args) != 0 {
+		// Verify all files exist, and open them.
+		for _, path := range args {
+			path = os.ExpandEnv(path)
+			file, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("failed to read file at %s: `%s`", path, err)
 			}
+			defer file.Close()
+			inputs = append(inputs, faq.Input{Name: path, Reader: file})
 		}
+	}
 
+	arguments, err := programArguments(flags, csv)
+	if err != nil {
+		return err
 	}
 
-	programArgs := faq.ProgramArguments{
-		Args:       flags.Args,
-		Jsonargs:   flags.Jsonargs,
-		Kwargs:     flags.Kwargs,
-		Jsonkwargs: flags.Jsonkwargs,
+	runner := &faq.Runner{
+		Program:         program,
+		Arguments:       arguments,
+		Engine:          flags.Engine,
+		LosslessNumbers: flags.LosslessNumbers,
+		InputFormat:     flags.InputFormat,
+		OutputFormat:    flags.OutputFormat,
+		CSV:             csv,
+		CBORDiagnostic:  cborDiagnostic,
+		NullInput:       flags.ProvideNull,
+		Slurp:           flags.Slurp,
+		RawInput:        flags.RawInput,
+		Stream:          flags.Stream,
+		Raw:             flags.Raw,
+		Pretty:          !flags.Compact && flags.Pretty,
+		Color:           color,
+		Edit:            flags.Edit,
+		Reencode:        flags.Reencode,
+		InPlace:         flags.InPlace,
+		AllowEmpty:      flags.AllowEmpty,
+		BackupSuffix:    flags.BackupSuffix,
+		DryRun:          flags.DryRun,
+		Jobs:            flags.Jobs,
+		Unordered:       flags.Unordered,
+		Limit:           limit,
+		ExitStatus:      flags.ExitStatus,
 	}
-	outputConf := faq.OutputConfig{
-		Pretty: !flags.Raw && !flags.Compact && flags.Pretty,
-		Color:  !flags.Raw && color,
+
+	ctx := context.Background()
+	if flags.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flags.Timeout)
+		defer cancel()
 	}
 
-	if flags.ProvideNull {
-		// If --output-format is auto, and we're taking a null input, we just
-		// default to JSON output
-		if flags.OutputFormat == "auto" {
-			flags.OutputFormat = "json"
-		}
-		encoding, ok := objconv.ByName(flags.OutputFormat)
-		if !ok {
-			return fmt.Errorf("invalid --output-format %s", flags.OutputFormat)
+	// Results are flushed as they're written, so buffering only combines the
+	// writes made while encoding each of them.
+	output := bufio.NewWriter(outputFile)
+	if err := runner.Run(ctx, output, inputs...); err != nil {
+		output.Flush()
+		// The results of --exit-status aren't failures to report.
+		if errors.Is(err, faq.ErrFalseResult) || errors.Is(err, faq.ErrNoResults) {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
 		}
-		err := faq.ProcessInput(nil, program, programArgs, outputFile, encoding, outputConf, flags.Raw)
-		if err != nil {
-			return err
+		var haltErr *faq.HaltError
+		var editErr *objconv.EditError
+		switch {
+		case errors.As(err, &haltErr):
+			// Programs that halt print their own message, like jq.
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			fmt.Fprint(cmd.ErrOrStderr(), haltErr.Message)
+		case errors.As(err, &editErr):
+			return fmt.Errorf("%w; use --reencode to write it without its comments and formatting", err)
+		case errors.Is(err, faq.ErrEmptyFile):
+			return fmt.Errorf("%w; use --allow-empty to empty it", err)
 		}
-		return nil
+		return err
+	}
+	return output.Flush()
+}
+
+// programArguments combines the arguments of faq's own flags with those of
+// the flags it shares with jq, reading the files given to --rawfile,
+// --slurpfile and --datafile. Data files in CSV are read with the options csv.
+func programArguments(flags flags, csv objconv.CSVEncoding) (faq.Arguments, error) {
+	arguments := faq.Arguments{
+		Args:       flags.Args,
+		JSONArgs:   flags.Jsonargs,
+		Kwargs:     make(map[string]string),
+		JSONKwargs: make(map[string]interface{}),
+	}
+	for name, value := range flags.Kwargs {
+		arguments.Kwargs[name] = value
+	}
+	for name, value := range flags.NamedArgs {
+		arguments.Kwargs[name] = value
+	}
+	for name, value := range flags.Jsonkwargs {
+		arguments.JSONKwargs[name] = value
+	}
+	for name, value := range flags.NamedJSONArgs {
+		arguments.JSONKwargs[name] = value
 	}
 
-	if flags.Slurp {
-		if flags.OutputFormat == "" {
-			return fmt.Errorf("must specify --output-format when using --slurp")
+	for name, path := range flags.RawFiles {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return arguments, fmt.Errorf("unable to read --rawfile %s: err %v", path, err)
 		}
-		encoding, ok := objconv.ByName(flags.OutputFormat)
-		if !ok {
-			return fmt.Errorf("invalid --output-format %s", flags.OutputFormat)
+		arguments.Kwargs[name] = string(contents)
+	}
+	for name, path := range flags.SlurpFiles {
+		values, err := readJSONValues(path)
+		if err != nil {
+			return arguments, fmt.Errorf("unable to read --slurpfile %s: err %v", path, err)
 		}
-		err := faq.SlurpAllFiles(flags.InputFormat, files, program, programArgs, outputFile, encoding, outputConf, flags.Raw)
+		arguments.JSONKwargs[name] = values
+	}
+	for name, spec := range flags.DataFiles {
+		path, format := parseDataFile(spec)
+		value, err := readDataFile(path, format, csv)
 		if err != nil {
-			return err
+			return arguments, fmt.Errorf("unable to read --datafile %s: err %v", path, err)
 		}
-	} else {
-		// If --output-format is auto, then use --input-format as the default
-		// output-format, otherwise try to detect the format of the input file
-		// and use that as the output format.
-		if flags.OutputFormat == "auto" && flags.InputFormat != "auto" {
-			flags.OutputFormat = flags.InputFormat
+		arguments.JSONKwargs[name] = value
+	}
+
+	return arguments, nil
+}
+
+// parseDataFile splits the path[:format] value of --datafile. The format is
+// only split off if it's the name of a format, so that paths may contain
+// colons.
+func parseDataFile(spec string) (path, format string) {
+	if i := strings.LastIndex(spec, ":"); i != -1 {
+		if _, ok := objconv.ByName(spec[i+1:]); ok {
+			return spec[:i], spec[i+1:]
 		}
-		encoding, newFile, err := faq.DetermineEncoding(flags.OutputFormat, files[0])
-		if err != nil {
-			return fmt.Errorf("invalid --output-format %s: %v", flags.OutputFormat, err)
+	}
+	return spec, "auto"
+}
+
+// readDataFile decodes the file at path in format, with the options csv if it's
+// in CSV.
+func readDataFile(path, format string, csv objconv.CSVEncoding) (interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	runner := &faq.Runner{CSV: csv}
+	return runner.DecodeInput(faq.Input{Name: path, Reader: file}, format)
+}
+
+// readJSONValues returns the JSON values in the file at path.
+func readJSONValues(path string) ([]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	values := []interface{}{}
+	for {
+		var value interface{}
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return values, nil
 		}
-		files[0] = newFile
-		err = faq.ProcessEachFile(flags.InputFormat, files, program, programArgs, outputFile, encoding, outputConf, flags.Raw)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		values = append(values, value)
 	}
-
-	return nil
 }
 
 // Flags are the configuration flags for faq
@@ -155,6 +304,7 @@ type flags struct {
 	Debug        bool
 	InputFormat  string
 	OutputFormat string
+	Engine       string
 	ProgramFile  string
 	Raw          bool
 	Color        bool
@@ -162,10 +312,65 @@ type flags struct {
 	Pretty       bool
 	Compact      bool
 	Slurp        bool
+	RawInput     bool
+	Stream       bool
 	ProvideNull  bool
+	Jobs         int
+	Unordered    bool
+	Limit        int
+	First        bool
+	Timeout      time.Duration
+	ExitStatus   bool
 	Args         []string
 	Jsonargs     []interface{}
 	Kwargs       map[string]string
 	Jsonkwargs   map[string]interface{}
+	// NamedArgs, NamedJSONArgs, RawFiles and SlurpFiles are the name=value
+	// arguments of jq's --arg, --argjson, --rawfile and --slurpfile.
+	NamedArgs     map[string]string
+	NamedJSONArgs map[string]interface{}
+	RawFiles      map[string]string
+	SlurpFiles    map[string]string
+	// DataFiles are the name=path[:format] arguments of --datafile.
+	DataFiles map[string]string
+	// LosslessNumbers is --lossless-numbers.
+	LosslessNumbers bool
+	// Edit and Reencode are --edit and --reencode.
+	Edit     bool
+	Reencode bool
+	// InPlace, AllowEmpty, BackupSuffix and DryRun are --in-place,
+	// --allow-empty, --backup-suffix and --dry-run.
+	InPlace      bool
+	AllowEmpty   bool
+	BackupSuffix string
+	DryRun       bool
+	// CSVDelimiter, CSVNoHeader and CSVQuote are --csv-delimiter,
+	// --csv-no-header and --csv-quote.
+	CSVDelimiter string
+	CSVNoHeader  bool
+	CSVQuote     string
 	PrintVersion bool
 }
+
+// csvOptions returns the options of the csv and tsv encodings given by the
+// --csv-* flags.
+func csvOptions(flags flags) (objconv.CSVEncoding, error) {
+	var quote objconv.CSVQuoting
+	switch flags.CSVQuote {
+	case "minimal":
+		quote = objconv.CSVQuoteMinimal
+	case "all":
+		quote = objconv.CSVQuoteAll
+	case "none":
+		quote = objconv.CSVQuoteNone
+	default:
+		return objconv.CSVEncoding{}, fmt.Errorf("invalid --csv-quote %s, must be minimal, all or none", flags.CSVQuote)
+	}
+
+	delimiter := []rune(flags.CSVDelimiter)
+	if len(delimiter) != 1 || delimiter[0] == '"' || delimiter[0] == '\r' || delimiter[0] == '\n' {
+		return objconv.CSVEncoding{}, fmt.Errorf("invalid --csv-delimiter %q, must be a single character other than a quote or newline", flags.CSVDelimiter)
+	}
+
+	return objconv.CSVEncoding{Delimiter: delimiter[0], NoHeader: flags.CSVNoHeader, Quote: quote}, nil
+}
@@ -1,22 +1,43 @@
 module github.com/jzelinskie/faq
 
-go 1.16
+go 1.18
 
 require (
 	github.com/Azure/draft v0.16.0
 	github.com/BurntSushi/toml v0.3.1
 	github.com/alecthomas/chroma v0.8.2
-	github.com/clbanning/mxj/v2 v2.5.5
-	github.com/ghodss/yaml v1.0.0
 	github.com/globalsign/mgo v0.0.0-20181015135952-eeefdecb41b8
 	github.com/go-xmlfmt/xmlfmt v0.0.0-20191208150333-d5b6f63a941b
-	github.com/jbrukh/bayesian v0.0.0-20200318221351-d726b684ca4a // indirect
+	github.com/hashicorp/hcl/v2 v2.19.1
+	github.com/itchyny/gojq v0.12.7
+	github.com/sergi/go-diff v1.0.0
 	github.com/sirupsen/logrus v1.8.0
 	github.com/spf13/cobra v1.1.3
 	github.com/spf13/pflag v1.0.5
+	github.com/zclconf/go-cty v1.13.1
 	github.com/zeebo/bencode v1.0.0
-	golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
-	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	golang.org/x/crypto v0.10.0
+	golang.org/x/net v0.11.0
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 	howett.net/plist v0.0.0-20201203080718-1454fab16a06
 )
+
+require (
+	github.com/agext/levenshtein v1.2.1 // indirect
+	github.com/apparentlymart/go-textseg/v13 v13.0.0 // indirect
+	github.com/apparentlymart/go-textseg/v15 v15.0.0 // indirect
+	github.com/danwakefield/fnmatch v0.0.0-20160403171240-cbb64ac3d964 // indirect
+	github.com/dlclark/regexp2 v1.2.0 // indirect
+	github.com/ghodss/yaml v1.0.0 // indirect
+	github.com/google/go-cmp v0.5.8 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/itchyny/timefmt-go v0.1.3 // indirect
+	github.com/jbrukh/bayesian v0.0.0-20200318221351-d726b684ca4a // indirect
+	github.com/magefile/mage v1.10.0 // indirect
+	github.com/mitchellh/go-wordwrap v0.0.0-20150314170334-ad45545899c7 // indirect
+	github.com/stretchr/testify v1.8.2 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	golang.org/x/term v0.10.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
@@ -0,0 +1,71 @@
+package faq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jzelinskie/faq/internal/jq"
+	"github.com/jzelinskie/faq/pkg/objconv"
+)
+
+// builtinFormats are the formats that have fromX and toX builtins: one name
+// for each text format, leaving out aliases and the binary formats, which
+// can't be held in a jq string.
+var builtinFormats = []string{"csv", "gitconfig", "hcl", "ini", "lines", "plist", "systemd", "toml", "tsv", "xml", "yaml"}
+
+// WithBuiltins returns an Engine that compiles programs with engine along with
+// a fromX and toX builtin for each format X of builtinFormats, which decode a
+// string in the format and encode a value as a string in the format. The
+// encodings are configured by encodings.
+func WithBuiltins(engine jq.Engine, encodings EncodingConfig) jq.Engine {
+	functions := make(map[string]jq.Function, 2*len(builtinFormats))
+	for _, name := range builtinFormats {
+		encoding, _ := objconv.ByName(name)
+		encoding = encodings.Configure(encoding)
+		functions["from"+name] = decodeFunction("from"+name, encoding)
+		functions["to"+name] = encodeFunction("to"+name, encoding)
+	}
+	return jq.WithFunctions(engine, functions)
+}
+
+// decodeFunction returns a jq.Function that decodes its string input with
+// encoding, producing the value of each of its documents.
+func decodeFunction(name string, encoding objconv.Encoding) jq.Function {
+	return func(input []byte) ([]byte, error) {
+		var s string
+		if err := json.Unmarshal(input, &s); err != nil {
+			return nil, fmt.Errorf("%s cannot be applied to %s, it must be a string", name, input)
+		}
+
+		decoder := encoding.NewDecoder(strings.NewReader(s))
+		var dataList [][]byte
+		for {
+			data, err := decoder.MarshalJSONBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s failed to decode its input: %s", name, err)
+			}
+			if len(bytes.TrimSpace(data)) != 0 {
+				dataList = append(dataList, data)
+			}
+		}
+		return bytes.Join(dataList, []byte{'\n'}), nil
+	}
+}
+
+// encodeFunction returns a jq.Function that encodes its input with encoding
+// and returns the result as a string.
+func encodeFunction(name string, encoding objconv.Encoding) jq.Function {
+	return func(input []byte) ([]byte, error) {
+		var buf bytes.Buffer
+		if err := encoding.NewEncoder(&buf).UnmarshalJSONBytes(input, false, false); err != nil {
+			return nil, fmt.Errorf("%s failed to encode its input: %s", name, err)
+		}
+		return json.Marshal(buf.String())
+	}
+}
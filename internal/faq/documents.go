@@ -0,0 +1,91 @@
+package faq
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jzelinskie/faq/internal/jq"
+	"github.com/jzelinskie/faq/pkg/objconv"
+)
+
+var _ jq.Inputs = &documents{}
+
+// documents decodes the JSON values of files one file after another.
+//
+// The same documents are read by the loop running a program against each
+// value and by the program's input and inputs builtins, so a value read by
+// the program is skipped by the loop, as in jq.
+type documents struct {
+	inputFormat string
+	stream      bool
+	encodings   EncodingConfig
+	files       []File
+
+	// file is the file that decoder is decoding, and itemNum is the number of
+	// values that have been decoded from it.
+	file    File
+	decoder objconv.Decoder
+	itemNum int
+}
+
+func newDocuments(inputFormat string, stream bool, encodings EncodingConfig, files []File) *documents {
+	return &documents{inputFormat: inputFormat, stream: stream, encodings: encodings, files: files}
+}
+
+// reset replaces the files that remain to be decoded.
+func (d *documents) reset(files []File) {
+	d.files = files
+	d.file = nil
+	d.decoder = nil
+}
+
+// Next implements jq.Inputs.
+func (d *documents) Next() ([]byte, error) {
+	for {
+		if d.decoder == nil {
+			if len(d.files) == 0 {
+				return nil, io.EOF
+			}
+
+			decoderEncoding, file, err := DetermineEncoding(d.inputFormat, d.files[0], d.encodings)
+			if err != nil {
+				return nil, err
+			}
+			d.files = d.files[1:]
+			d.file = file
+			d.decoder = newDecoder(decoderEncoding, file.Reader(), d.stream)
+			d.itemNum = 0
+		}
+
+		data, err := d.decoder.MarshalJSONBytes()
+		if err == io.EOF {
+			d.decoder = nil
+			continue
+		}
+		if err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to jsonify file at %s: `%s`", d.file.Path(), err)}
+		}
+
+		d.itemNum++
+		logrus.Debugf("file: %s (item %d), jsonified:\n%s", d.file.Path(), d.itemNum, string(data))
+		return data, nil
+	}
+}
+
+// Filename implements jq.Inputs.
+func (d *documents) Filename() string {
+	if d.file == nil {
+		return ""
+	}
+	return d.file.Path()
+}
+
+// Index implements jq.Inputs.
+func (d *documents) Index() int {
+	if d.file == nil {
+		return -1
+	}
+	return d.itemNum - 1
+}
@@ -0,0 +1,27 @@
+package faq
+
+import "errors"
+
+// ErrEmptyFile is returned when a file would be written in place without any
+// results, unless OutputConfig.AllowEmpty is set.
+var ErrEmptyFile = errors.New("the program produced no results to write back")
+
+// CompileError is returned when a jq program fails to compile.
+type CompileError struct {
+	err error
+}
+
+func (e *CompileError) Error() string { return e.err.Error() }
+
+// Unwrap returns the error reported by the jq engine.
+func (e *CompileError) Unwrap() error { return e.err }
+
+// DecodeError is returned when an input cannot be decoded into JSON values.
+type DecodeError struct {
+	err error
+}
+
+func (e *DecodeError) Error() string { return e.err.Error() }
+
+// Unwrap returns the error reported while decoding.
+func (e *DecodeError) Unwrap() error { return e.err }
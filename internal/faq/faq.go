@@ -2,6 +2,7 @@ package faq
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,44 +21,145 @@ import (
 
 // ProcessEachFile takes a list of files, and for each, attempts to convert it
 // to a JSON value and runs ExecuteProgram against each.
-func ProcessEachFile(inputFormat string, files []File, program string, programArgs ProgramArguments, outputWriter io.Writer, outputEncoding objconv.Encoding, outputConf OutputConfig, rawOutput bool) error {
-	encoder := outputEncoding.NewEncoder(outputWriter)
-	for _, file := range files {
-		decoderEncoding, file, err := DetermineEncoding(inputFormat, file)
-		if err != nil {
+//
+// The program's input and inputs builtins read the values that follow the
+// one it is being run against. If processConf.NullInput is set, the program
+// is run once against null and can only read the values through them.
+//
+// If processConf.Jobs is greater than one, files are decoded and evaluated
+// concurrently. Results are still written in the order of the files unless
+// processConf.Unordered is set. Programs that may call input or inputs are
+// run against the files one at a time regardless, since they read values
+// from the files that follow.
+//
+// If outputConf.Edit or outputConf.InPlace is set, files are processed one at
+// a time, as they are when processConf.Jobs is greater than one, so that the
+// results of each file can be written as edits of it or back to it.
+func ProcessEachFile(ctx context.Context, inputFormat string, files []File, engine jq.Engine, program string, programArgs ProgramArguments, outputWriter io.Writer, outputEncoding objconv.Encoding, outputConf OutputConfig, rawOutput bool, processConf ProcessConfig) error {
+	results := newResultWriter(outputWriter, outputEncoding, outputConf, rawOutput)
+	if processConf.Jobs > 1 && len(files) > 1 && !processConf.NullInput && !jq.ReadsInputs(program) {
+		return processFilesConcurrently(ctx, inputFormat, files, engine, program, programArgs, results, rawOutput, processConf)
+	}
+
+	docs := newDocuments(inputFormat, processConf.Stream, processConf.Encodings, files)
+	prog, err := compileProgram(engine, program, programArgs, docs)
+	if err != nil {
+		return err
+	}
+	defer prog.Close()
+
+	if processConf.NullInput {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		return processInput(ctx, nil, prog, results, rawOutput, processConf.Limit)
+	}
+	if results.outputConf.Edit || results.outputConf.InPlace {
+		return processFilesSeparately(ctx, inputFormat, files, docs, prog, results, rawOutput, processConf)
+	}
+	return processDocuments(ctx, docs, prog, rawOutput, processConf.Limit, results.write)
+}
 
-		decoder := decoderEncoding.NewDecoder(file.Reader())
-
-		itemNum := 1
-		for {
-			data, err := decoder.MarshalJSONBytes()
-			if err == io.EOF {
+// processFilesSeparately runs prog against the values of each file in turn,
+// writing the results of each file as edits of it or back to it. The
+// program's input builtins only read the values of the file being processed.
+func processFilesSeparately(ctx context.Context, inputFormat string, files []File, docs *documents, prog jq.Program, results *resultWriter, rawOutput bool, processConf ProcessConfig) error {
+	limit := processConf.Limit
+	written := 0
+	write := func(output string, document int) error {
+		written++
+		return results.write(output, document)
+	}
+	for _, file := range files {
+		remaining := 0
+		if limit > 0 {
+			if written >= limit {
 				break
 			}
-			if err != nil {
-				return fmt.Errorf("failed to jsonify file at %s: `%s`", file.Path(), err)
-			}
+			remaining = limit - written
+		}
 
-			logrus.Debugf("file: %s (item %d), jsonified:\n%s", file.Path(), itemNum, string(data))
+		output, file, err := results.openFile(inputFormat, file, processConf.Encodings)
+		if err != nil {
+			return err
+		}
+		results.startFile(output)
+		docs.reset([]File{file})
+		if err := processDocuments(ctx, docs, prog, rawOutput, remaining, write); err != nil {
+			return err
+		}
+		if err := results.finishFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// processDocuments runs prog against each value of docs, passing each result
+// to fn as soon as it's produced along with the index of the value in its
+// file, or -1 if the program read other values through its input builtins
+// before producing the result. It stops early if ctx is done.
+//
+// If limit is positive, processDocuments stops decoding and evaluating values
+// once limit results have been passed to fn.
+func processDocuments(ctx context.Context, docs *documents, prog jq.Program, rawOutput bool, limit int, fn func(output string, document int) error) error {
+	produced := 0
+	for limit <= 0 || produced < limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
-			err = processInput(&data, program, programArgs, encoder, outputConf, rawOutput)
-			if err != nil {
-				return err
+		data, err := docs.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		runLimit := 0
+		if limit > 0 {
+			runLimit = limit - produced
+		}
+		document := docs.Index()
+		n, err := runProgram(ctx, prog, data, rawOutput, runLimit, func(output string) error {
+			if docs.Index() != document {
+				return fn(output, -1)
 			}
-			itemNum++
+			return fn(output, document)
+		})
+		produced += n
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// runProgram runs prog against input, passing each result to fn, and returns
+// the number of results that were passed to it. If limit is positive, prog is
+// stopped once it has produced limit results.
+func runProgram(ctx context.Context, prog jq.Program, input []byte, rawOutput bool, limit int, fn func(output string) error) (int, error) {
+	produced := 0
+	err := prog.Run(ctx, input, rawOutput, func(output string) error {
+		produced++
+		if err := fn(output); err != nil {
+			return err
+		}
+		if limit > 0 && produced >= limit {
+			return jq.Stop
+		}
+		return nil
+	})
+	return produced, err
+}
+
 // SlurpAllFiles takes a list of files, and for each, attempts to convert it to
 // a JSON value and appends each JSON value to an array, and passes that array
 // as the input ExecuteProgram.
-func SlurpAllFiles(inputFormat string, files []File, program string, programArgs ProgramArguments, outputWriter io.Writer, encoding objconv.Encoding, outputConf OutputConfig, rawOutput bool) error {
-	data, err := combineJSONFilesToJSONArray(files, inputFormat)
+func SlurpAllFiles(ctx context.Context, inputFormat string, files []File, engine jq.Engine, program string, programArgs ProgramArguments, outputWriter io.Writer, encoding objconv.Encoding, outputConf OutputConfig, rawOutput bool, processConf ProcessConfig) error {
+	data, err := combineJSONFilesToJSONArray(files, inputFormat, processConf.Stream, processConf.Encodings)
 	if err != nil {
 		return err
 	}
@@ -68,104 +170,381 @@ func SlurpAllFiles(inputFormat string, files []File, program string, programArgs
 	}
 	logrus.Debugf("files: %q, jsonified:\n%s", paths, string(data))
 
-	encoder := encoding.NewEncoder(outputWriter)
-	return processInput(&data, program, programArgs, encoder, outputConf, rawOutput)
-}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-// ProcessInput takes input, a single JSON value, and runs program via libjq
-// against it, writing the results to outputWriter.
-func ProcessInput(input *[]byte, program string, programArgs ProgramArguments, outputWriter io.Writer, encoding objconv.Encoding, outputConf OutputConfig, rawOutput bool) error {
-	encoder := encoding.NewEncoder(outputWriter)
-	return processInput(input, program, programArgs, encoder, outputConf, rawOutput)
+	prog, err := compileProgram(engine, program, programArgs, nil)
+	if err != nil {
+		return err
+	}
+	defer prog.Close()
+
+	results := newResultWriter(outputWriter, encoding, outputConf, rawOutput)
+	return processInput(ctx, &data, prog, results, rawOutput, processConf.Limit)
 }
 
-func processInput(input *[]byte, program string, programArgs ProgramArguments, encoder objconv.Encoder, outputConf OutputConfig, rawOutput bool) error {
-	outputs, err := ExecuteProgram(input, program, programArgs, rawOutput)
+// ProcessInput takes input, a single JSON value, and runs program via engine
+// against it, writing the results to outputWriter.
+func ProcessInput(ctx context.Context, input *[]byte, engine jq.Engine, program string, programArgs ProgramArguments, outputWriter io.Writer, encoding objconv.Encoding, outputConf OutputConfig, rawOutput bool, processConf ProcessConfig) error {
+	prog, err := compileProgram(engine, program, programArgs, nil)
 	if err != nil {
 		return err
 	}
+	defer prog.Close()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	results := newResultWriter(outputWriter, encoding, outputConf, rawOutput)
+	return processInput(ctx, input, prog, results, rawOutput, processConf.Limit)
+}
 
+func processInput(ctx context.Context, input *[]byte, prog jq.Program, results *resultWriter, rawOutput bool, limit int) error {
+	if input == nil {
+		input = new([]byte)
+		*input = []byte("null")
+	}
+
+	_, err := runProgram(ctx, prog, *input, rawOutput, limit, func(output string) error {
+		return results.write(output, -1)
+	})
+	return err
+}
+
+// flusher is implemented by writers that buffer their output, such as
+// bufio.Writer.
+type flusher interface {
+	Flush() error
+}
+
+// resultWriter encodes the results of running a program.
+type resultWriter struct {
+	w          io.Writer
+	encoding   objconv.Encoding
+	encoder    objconv.Encoder
+	outputConf OutputConfig
+
+	// shared is the encoder of the results that aren't written as edits of
+	// a file or in place.
+	shared objconv.Encoder
+	// editor writes the results of the file being processed as edits of it
+	// instead of encoder, if they are.
+	editor objconv.EditEncoder
+
+	// file is the file whose results are being written, and buf holds them
+	// if they're written in place. fileResults counts them.
+	file        *fileOutput
+	buf         bytes.Buffer
+	fileResults int
+}
+
+func newResultWriter(w io.Writer, encoding objconv.Encoding, outputConf OutputConfig, rawOutput bool) *resultWriter {
 	if rawOutput {
 		outputConf.Color = false
 		outputConf.Pretty = false
+		outputConf.Edit = false
+	}
+	if outputConf.InPlace {
+		outputConf.Color = false
 	}
+	encoder := encoding.NewEncoder(w)
+	return &resultWriter{w: w, encoding: encoding, encoder: encoder, outputConf: outputConf, shared: encoder}
+}
 
-	for _, output := range outputs {
-		err := encoder.UnmarshalJSONBytes([]byte(output), outputConf.Color, outputConf.Pretty)
-		if err != nil {
+// wrapper is implemented by Encodings that wrap another Encoding, such as to
+// keep track of the results they're given.
+type wrapper interface {
+	// Unwrap returns the wrapped Encoding.
+	Unwrap() objconv.Encoding
+	// Wrap returns an Encoding that wraps encoding the same way.
+	Wrap(encoding objconv.Encoding) objconv.Encoding
+}
+
+// fileOutput describes how the results of a file that is processed on its own
+// are written.
+type fileOutput struct {
+	path string
+	// encoding is the encoding of the file, which the results written in
+	// place are encoded in.
+	encoding objconv.Encoding
+	// original is the contents of the file.
+	original []byte
+	// edit is set if the results are written as edits of original.
+	edit bool
+}
+
+// openFile reads file if its results are written in place, or as edits of it,
+// which they are if outputConf.Edit is set and file is in the output
+// encoding, which is an objconv.Editor. It returns how the results of file are
+// written, or nil if they're written as usual, and a File that reads file from
+// the start.
+//
+// openFile doesn't modify rw, so it may be called from any goroutine.
+func (rw *resultWriter) openFile(inputFormat string, file File, encodings EncodingConfig) (*fileOutput, File, error) {
+	if !rw.outputConf.Edit && !rw.outputConf.InPlace {
+		return nil, file, nil
+	}
+
+	encoding, file, err := DetermineEncoding(inputFormat, file, encodings)
+	if err != nil {
+		return nil, nil, err
+	}
+	outputEncoding := encoding
+	if !rw.outputConf.InPlace {
+		outputEncoding = rw.encoding
+		if w, ok := outputEncoding.(wrapper); ok {
+			outputEncoding = w.Unwrap()
+		}
+	}
+	_, isEditor := encoding.(objconv.Editor)
+	edit := rw.outputConf.Edit && isEditor && encoding == outputEncoding
+	if !edit && !rw.outputConf.InPlace {
+		return nil, file, nil
+	}
+
+	original, err := ioutil.ReadAll(file.Reader())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file at %s: `%s`", file.Path(), err)
+	}
+	output := &fileOutput{file.Path(), encoding, original, edit}
+	return output, NewFile(file.Path(), ioutil.NopCloser(bytes.NewReader(original))), nil
+}
+
+// startFile makes the results that follow be written as output, returned by
+// openFile, describes. If output is nil, they're encoded as usual.
+func (rw *resultWriter) startFile(output *fileOutput) {
+	rw.file = output
+	rw.fileResults = 0
+	rw.editor = nil
+	if output == nil {
+		rw.encoder = rw.shared
+		return
+	}
+
+	w, encoding := rw.w, rw.encoding
+	if rw.outputConf.InPlace {
+		rw.buf.Reset()
+		w, encoding = &rw.buf, output.encoding
+		if wrapper, ok := rw.encoding.(wrapper); ok {
+			encoding = wrapper.Wrap(encoding)
+		}
+	}
+	if editor, ok := encoding.(objconv.Editor); ok && output.edit {
+		rw.editor = editor.NewEditEncoder(w, output.original, rw.outputConf.Reencode)
+		return
+	}
+	rw.encoder = encoding.NewEncoder(w)
+}
+
+// finishFile writes the results of the file passed to startFile back to it,
+// or writes a unified diff of the changes to the underlying writer instead if
+// outputConf.DryRun is set. It does nothing unless outputConf.InPlace is set.
+// A file the program produced no results for is ErrEmptyFile rather than
+// being emptied, unless outputConf.AllowEmpty is set.
+func (rw *resultWriter) finishFile() error {
+	output := rw.file
+	rw.file = nil
+	if output == nil || !rw.outputConf.InPlace {
+		return nil
+	}
+	if rw.fileResults == 0 && !rw.outputConf.AllowEmpty {
+		return fmt.Errorf("failed to write file at %s: %w", output.path, ErrEmptyFile)
+	}
+
+	if rw.outputConf.DryRun {
+		if _, err := io.WriteString(rw.w, unifiedDiff(output.path, output.original, rw.buf.Bytes())); err != nil {
 			return err
 		}
+		if f, ok := rw.w.(flusher); ok {
+			return f.Flush()
+		}
+		return nil
 	}
+	if bytes.Equal(rw.buf.Bytes(), output.original) {
+		return nil
+	}
+	return writeFileAtomically(output.path, rw.buf.Bytes(), output.original, rw.outputConf.BackupSuffix)
+}
 
+// write encodes a result of the value at index document of its file, or -1 if
+// it didn't come from exactly one. Results written as edits are edits of that
+// value's document. If the underlying writer buffers its output, it is
+// flushed so that a reader on the other end of a pipe sees the result
+// immediately.
+func (rw *resultWriter) write(output string, document int) error {
+	rw.fileResults++
+	var err error
+	if rw.editor != nil {
+		err = rw.editor.EditJSONBytes(document, []byte(output), rw.outputConf.Color, rw.outputConf.Pretty)
+	} else {
+		err = rw.encoder.UnmarshalJSONBytes([]byte(output), rw.outputConf.Color, rw.outputConf.Pretty)
+	}
+	if err != nil {
+		var editErr *objconv.EditError
+		if errors.As(err, &editErr) && rw.file != nil {
+			return fmt.Errorf("failed to edit file at %s: %w", rw.file.path, err)
+		}
+		return err
+	}
+	if f, ok := rw.w.(flusher); ok {
+		return f.Flush()
+	}
 	return nil
 }
 
-// ExecuteProgram takes input, a single JSON value, and runs program via libjq
+// ExecuteProgram takes input, a single JSON value, and runs program via engine
 // against it, returning the results.
-func ExecuteProgram(input *[]byte, program string, programArgs ProgramArguments, rawOutput bool) ([]string, error) {
+func ExecuteProgram(ctx context.Context, input *[]byte, engine jq.Engine, program string, programArgs ProgramArguments, rawOutput bool) ([]string, error) {
 	if input == nil {
 		input = new([]byte)
 		*input = []byte("null")
 	}
 
-	args, err := marshalJqArgs(*input, programArgs)
+	args, err := marshalJqArgs(programArgs)
 	if err != nil {
 		return nil, err
 	}
 
-	return jq.Exec(program, args, *input, rawOutput)
+	return jq.Exec(ctx, engine, program, args, *input, rawOutput)
 }
 
-func combineJSONFilesToJSONArray(files []File, inputFormat string) ([]byte, error) {
-	var buf bytes.Buffer
+// compileProgram compiles program with programArgs so that it can be run
+// against every input without being recompiled. The program's input builtin
+// reads from inputs, which may be nil.
+func compileProgram(engine jq.Engine, program string, programArgs ProgramArguments, inputs jq.Inputs) (jq.Program, error) {
+	args, err := marshalJqArgs(programArgs)
+	if err != nil {
+		return nil, err
+	}
 
-	// append the first array bracket
-	buf.WriteRune('[')
+	prog, err := engine.Compile(program, args, inputs)
+	if err != nil {
+		return nil, &CompileError{err}
+	}
+	return prog, nil
+}
+
+// newDecoder returns a Decoder for the values of r, or for their events if
+// stream is set.
+func newDecoder(encoding objconv.Encoding, r io.Reader, stream bool) objconv.Decoder {
+	if stream {
+		return objconv.NewStreamDecoder(encoding, r)
+	}
+	return encoding.NewDecoder(r)
+}
 
-	// iterate over each file, appending it's contents to an array
-	for i, file := range files {
-		encoding, file, err := DetermineEncoding(inputFormat, file)
+func combineJSONFilesToJSONArray(files []File, inputFormat string, stream bool, encodings EncodingConfig) ([]byte, error) {
+	var dataList [][]byte
+	for _, file := range files {
+		fileDataList, err := DecodeFile(inputFormat, file, stream, encodings)
 		if err != nil {
 			return nil, err
 		}
+		dataList = append(dataList, fileDataList...)
+	}
 
-		decoder := encoding.NewDecoder(file.Reader())
-		var dataList [][]byte
-		for {
-			data, err := decoder.MarshalJSONBytes()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return nil, fmt.Errorf("failed to jsonify file at %s: `%s`", file.Path(), err)
-			}
-			if len(bytes.TrimSpace(data)) != 0 {
-				dataList = append(dataList, data)
-			}
+	var buf bytes.Buffer
+	buf.WriteRune('[')
+	buf.Write(bytes.Join(dataList, []byte{','}))
+	buf.WriteRune(']')
+	return buf.Bytes(), nil
+}
+
+// DecodeFile returns every JSON value of file, or its events if stream is set,
+// determining its encoding from inputFormat as DetermineEncoding does.
+func DecodeFile(inputFormat string, file File, stream bool, encodings EncodingConfig) ([][]byte, error) {
+	encoding, file, err := DetermineEncoding(inputFormat, file, encodings)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := newDecoder(encoding, file.Reader(), stream)
+	var dataList [][]byte
+	for {
+		data, err := decoder.MarshalJSONBytes()
+		if err == io.EOF {
+			return dataList, nil
 		}
-		// for each json value in dataList, write it, plus a comma after
-		// it, as long it isn't the last item in dataList
-		for j, data := range dataList {
-			buf.Write(data)
-			if j != len(dataList)-1 {
-				buf.WriteRune(',')
-			}
+		if err != nil {
+			return nil, &DecodeError{fmt.Errorf("failed to jsonify file at %s: `%s`", file.Path(), err)}
 		}
-		// append a comma between each file
-		if len(dataList) != 0 && i != len(files)-1 {
-			buf.WriteRune(',')
+		if len(bytes.TrimSpace(data)) != 0 {
+			dataList = append(dataList, data)
 		}
 	}
-	// append the last array bracket
-	buf.WriteRune(']')
-
-	return buf.Bytes(), nil
 }
 
 // OutputConfig contains configuration for out to print out values
 type OutputConfig struct {
 	Pretty bool
 	Color  bool
+	// Edit writes the results of each file that is in the output encoding
+	// as edits of the file when the encoding is an objconv.Editor, keeping
+	// the comments and formatting of the parts whose values didn't change.
+	// It only applies to ProcessEachFile without processConf.NullInput.
+	Edit bool
+	// Reencode encodes the results of files that can't be written as edits
+	// of them as usual, rather than failing with an *objconv.EditError.
+	Reencode bool
+	// InPlace writes the results of each file back to the file, encoded in
+	// its own encoding, instead of to the output writer. Files are replaced
+	// atomically, and only if their contents changed. Like Edit, it only
+	// applies to ProcessEachFile without processConf.NullInput.
+	InPlace bool
+	// AllowEmpty writes files that the program produced no results for in
+	// place, emptying them, rather than failing with ErrEmptyFile.
+	AllowEmpty bool
+	// BackupSuffix is appended to the path of each file written in place to
+	// keep a copy of its original contents, unless it's empty.
+	BackupSuffix string
+	// DryRun writes a unified diff of the changes InPlace would make to the
+	// output writer instead of making them.
+	DryRun bool
+}
+
+// ProcessConfig contains configuration for how files are processed
+type ProcessConfig struct {
+	// Jobs is the number of files that are decoded and evaluated
+	// concurrently.
+	Jobs int
+	// Unordered allows the results of each file to be written as soon as
+	// they're available rather than in the order the files were provided.
+	Unordered bool
+	// Limit is the maximum number of results that are written. Once it has
+	// been reached, no more values are decoded or evaluated. Zero means there
+	// is no limit.
+	Limit int
+	// Stream evaluates the program against the events of jq's --stream mode
+	// rather than against whole values.
+	Stream bool
+	// NullInput runs the program once against null rather than against each
+	// value. The values are still available to its input builtin.
+	NullInput bool
+	// Encodings configures the encodings files are decoded from.
+	Encodings EncodingConfig
+}
+
+// EncodingConfig contains the options of the encodings that have them.
+type EncodingConfig struct {
+	// CSV holds the options of the csv and tsv encodings. Its Delimiter
+	// only applies to csv, since tsv is always delimited by tabs.
+	CSV objconv.CSVEncoding
+}
+
+// Configure returns encoding with the options of conf.
+func (conf EncodingConfig) Configure(encoding objconv.Encoding) objconv.Encoding {
+	csv, ok := encoding.(objconv.CSVEncoding)
+	if !ok {
+		return encoding
+	}
+	if csv.Delimiter != '\t' {
+		csv.Delimiter = conf.CSV.Delimiter
+	}
+	csv.NoHeader = conf.CSV.NoHeader
+	csv.Quote = conf.CSV.Quote
+	return csv
 }
 
 // ProgramArguments contains the arguments to a JQ program
@@ -176,7 +555,7 @@ type ProgramArguments struct {
 	Jsonkwargs map[string]interface{}
 }
 
-func marshalJqArgs(jsonBytes []byte, jqArgs ProgramArguments) ([]byte, error) {
+func marshalJqArgs(jqArgs ProgramArguments) ([]byte, error) {
 	var positionalArgsArray []interface{}
 	programArgs := make(map[string]interface{})
 	namedArgs := make(map[string]interface{})
@@ -203,13 +582,17 @@ func marshalJqArgs(jsonBytes []byte, jqArgs ProgramArguments) ([]byte, error) {
 }
 
 // DetermineEncoding returns an Encoding based on a file format and an input
-// file if input format is "auto". Since auto detection may consume the file,
-// DetermineEncoding returns a copy of the original File.
-func DetermineEncoding(format string, file File) (objconv.Encoding, File, error) {
+// file if input format is "auto", configured by encodings. Since auto
+// detection may consume the file, DetermineEncoding returns a copy of the
+// original File.
+func DetermineEncoding(format string, file File, encodings EncodingConfig) (objconv.Encoding, File, error) {
 	var encoding objconv.Encoding
 	var err error
 	if format == "auto" {
 		encoding, file, err = detectFormat(file)
+		if err != nil {
+			err = &DecodeError{err}
+		}
 	} else {
 		var ok bool
 		encoding, ok = objconv.ByName(format)
@@ -221,7 +604,7 @@ func DetermineEncoding(format string, file File) (objconv.Encoding, File, error)
 		return nil, file, err
 	}
 
-	return encoding, file, nil
+	return encodings.Configure(encoding), file, nil
 }
 
 var yamlSeparator = []byte("---")
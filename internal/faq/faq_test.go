@@ -2,11 +2,18 @@ package faq
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
 
+	"github.com/jzelinskie/faq/internal/jq"
 	"github.com/jzelinskie/faq/pkg/objconv"
 )
 
@@ -189,30 +196,229 @@ bar: false
 		},
 	}
 
-	for _, testCase := range testCases {
-		testCase := testCase
-		t.Run(testCase.name, func(t *testing.T) {
-			var files []File
-			for i, fileContent := range testCase.inputFileContents {
-				files = append(files, newFileFromString("test-path-"+strconv.Itoa(i), fileContent))
-			}
+	for _, jobs := range []int{1, 4} {
+		for _, testCase := range testCases {
+			testCase := testCase
+			processConf := ProcessConfig{Jobs: jobs}
+			t.Run(testCase.name+" jobs="+strconv.Itoa(jobs), func(t *testing.T) {
+				var files []File
+				for i, fileContent := range testCase.inputFileContents {
+					files = append(files, newFileFromString("test-path-"+strconv.Itoa(i), fileContent))
+				}
+
+				encoding, ok := objconv.ByName(testCase.outputFormat)
+				if !ok {
+					t.Errorf("invalid format: %s", testCase.outputFormat)
+				}
+
+				var outputBuf bytes.Buffer
+				err := ProcessEachFile(context.Background(), testCase.inputFormat, files, defaultEngine(t), testCase.program, ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, testCase.raw, processConf)
+				if err != nil {
+					t.Errorf("expected no err, got %#v", err)
+				}
+
+				output := outputBuf.String()
+				if output != testCase.expectedOutput {
+					t.Errorf("incorrect output expected=%s, got=%s", testCase.expectedOutput, output)
+				}
+			})
+		}
+	}
+}
+
+func TestProcessEachFileConcurrently(t *testing.T) {
+	var contents []string
+	var expectedLines []string
+	for i := 0; i < 50; i++ {
+		contents = append(contents, "---\nfile: "+strconv.Itoa(i)+"\n---\nfile: "+strconv.Itoa(i)+"\n")
+		expectedLines = append(expectedLines, strconv.Itoa(i), strconv.Itoa(i))
+	}
+	encoding, _ := objconv.ByName("json")
+
+	for _, processConf := range []ProcessConfig{{Jobs: 8}, {Jobs: 8, Unordered: true}} {
+		var files []File
+		for i, content := range contents {
+			files = append(files, newFileFromString("test-path-"+strconv.Itoa(i), content))
+		}
+
+		var outputBuf bytes.Buffer
+		err := ProcessEachFile(context.Background(), "yaml", files, defaultEngine(t), ".file", ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, processConf)
+		if err != nil {
+			t.Fatalf("expected no err, got %#v", err)
+		}
+
+		lines := strings.Split(strings.TrimSuffix(outputBuf.String(), "\n"), "\n")
+		if processConf.Unordered {
+			sort.Slice(lines, func(i, j int) bool {
+				a, _ := strconv.Atoi(lines[i])
+				b, _ := strconv.Atoi(lines[j])
+				return a < b
+			})
+		}
+		if !reflect.DeepEqual(lines, expectedLines) {
+			t.Errorf("incorrect output with %+v: %q", processConf, lines)
+		}
+	}
+}
+
+func TestProcessEachFileConcurrentlyError(t *testing.T) {
+	files := []File{
+		newFileFromString("test-path-0", `{"a":1}`),
+		newFileFromString("test-path-1", `{"a":2}`),
+		newFileFromString("test-path-2", `{"a":`),
+		newFileFromString("test-path-3", `{"a":4}`),
+	}
+	encoding, _ := objconv.ByName("json")
+
+	var outputBuf bytes.Buffer
+	err := ProcessEachFile(context.Background(), "json", files, defaultEngine(t), ".a", ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, ProcessConfig{Jobs: 4})
+	if err == nil || !strings.Contains(err.Error(), "test-path-2") {
+		t.Errorf("expected an error for test-path-2, got %v", err)
+	}
+	if output := outputBuf.String(); output != "1\n2\n" {
+		t.Errorf("expected the files preceding the error to be written, got %q", output)
+	}
+}
 
-			encoding, ok := objconv.ByName(testCase.outputFormat)
-			if !ok {
-				t.Errorf("invalid format: %s", testCase.outputFormat)
+func TestProcessEachFileLimit(t *testing.T) {
+	encoding, _ := objconv.ByName("json")
+	for _, jobs := range []int{1, 4} {
+		for limit, expectedOutput := range map[int]string{1: "1\n", 2: "1\n2\n", 4: "1\n2\n3\n4\n"} {
+			// The last file is invalid, so it must not be decoded once the
+			// limit is reached.
+			files := []File{
+				newFileFromString("test-path-0", `[1, 2] [3]`),
+				newFileFromString("test-path-1", `[4, 5, 6]`),
+				newFileFromString("test-path-2", `[`),
 			}
 
 			var outputBuf bytes.Buffer
-			err := ProcessEachFile(testCase.inputFormat, files, testCase.program, ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, testCase.raw)
+			processConf := ProcessConfig{Jobs: jobs, Limit: limit}
+			err := ProcessEachFile(context.Background(), "json", files, defaultEngine(t), ".[]", ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, processConf)
 			if err != nil {
-				t.Errorf("expected no err, got %#v", err)
+				t.Errorf("expected no err with %+v, got %v", processConf, err)
+			}
+			if output := outputBuf.String(); output != expectedOutput {
+				t.Errorf("incorrect output with %+v expected=%q, got=%q", processConf, expectedOutput, output)
 			}
+		}
+	}
+}
 
-			output := outputBuf.String()
-			if output != testCase.expectedOutput {
-				t.Errorf("incorrect output expected=%s, got=%s", testCase.expectedOutput, output)
+func TestProcessEachFileStream(t *testing.T) {
+	encoding, _ := objconv.ByName("json")
+	for _, jobs := range []int{1, 4} {
+		files := []File{
+			newFileFromString("test-path-0.json", `{"a":[1,{"b":2}]}`),
+			newFileFromString("test-path-1.yaml", "c: three\n"),
+		}
+
+		var outputBuf bytes.Buffer
+		processConf := ProcessConfig{Jobs: jobs, Stream: true}
+		err := ProcessEachFile(context.Background(), "auto", files, defaultEngine(t), "select(length == 2) | .[1]", ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, processConf)
+		if err != nil {
+			t.Errorf("expected no err with %+v, got %v", processConf, err)
+		}
+		if output, expected := outputBuf.String(), "1\n2\n\"three\"\n"; output != expected {
+			t.Errorf("incorrect output with %+v expected=%q, got=%q", processConf, expected, output)
+		}
+	}
+}
+
+func TestProcessEachFileTabular(t *testing.T) {
+	encoding, _ := objconv.ByName("json")
+	files := []File{
+		newFileFromString("test-path-0.csv", "name,age\nann,30\n"),
+		newFileFromString("test-path-1.tsv", "name\tage\nbob\t41\n"),
+	}
+
+	var outputBuf bytes.Buffer
+	err := ProcessEachFile(context.Background(), "auto", files, defaultEngine(t), ".name", ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, ProcessConfig{Jobs: 1})
+	if err != nil {
+		t.Errorf("expected no err, got %v", err)
+	}
+	if output, expected := outputBuf.String(), "\"ann\"\n\"bob\"\n"; output != expected {
+		t.Errorf("incorrect output expected=%q, got=%q", expected, output)
+	}
+}
+
+func TestProcessEachFileInputs(t *testing.T) {
+	testCases := []struct {
+		program        string
+		processConf    ProcessConfig
+		expectedOutput string
+	}{
+		{"[., input]", ProcessConfig{}, "[1,2]\n[3,4]\n"},
+		{"[., input]", ProcessConfig{Limit: 1}, "[1,2]\n"},
+		{"[inputs]", ProcessConfig{NullInput: true}, "[1,2,3,4]\n"},
+		{"[., input]", ProcessConfig{NullInput: true, Jobs: 4}, "[null,1]\n"},
+	}
+
+	encoding, _ := objconv.ByName("json")
+	for _, testCase := range testCases {
+		files := []File{
+			newFileFromString("test-path-0.json", `1 2 3`),
+			newFileFromString("test-path-1.yaml", "4\n"),
+		}
+
+		var outputBuf bytes.Buffer
+		err := ProcessEachFile(context.Background(), "auto", files, defaultEngine(t), testCase.program, ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, testCase.processConf)
+		if err != nil {
+			t.Errorf("expected no err for %s with %+v, got %v", testCase.program, testCase.processConf, err)
+		}
+		if output := outputBuf.String(); output != testCase.expectedOutput {
+			t.Errorf("incorrect output for %s with %+v expected=%q, got=%q", testCase.program, testCase.processConf, testCase.expectedOutput, output)
+		}
+	}
+}
+
+func TestProcessEachFileJobsInputs(t *testing.T) {
+	programs := []string{
+		"[., input]",
+		"[., inputs]",
+		"[., input_filename, first(inputs)?]",
+		`include "missing"; .`,
+	}
+
+	encoding, _ := objconv.ByName("json")
+	for _, program := range programs {
+		var outputs [2]string
+		var errs [2]error
+		for i, jobs := range []int{1, 4} {
+			files := []File{
+				newFileFromString("test-path-0.json", `1 2 3`),
+				newFileFromString("test-path-1.yaml", "4\n---\n5\n"),
+				newFileFromString("test-path-2.json", `6`),
 			}
-		})
+
+			var outputBuf bytes.Buffer
+			errs[i] = ProcessEachFile(context.Background(), "auto", files, defaultEngine(t), program, ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, ProcessConfig{Jobs: jobs})
+			outputs[i] = outputBuf.String()
+		}
+		if outputs[0] != outputs[1] || (errs[0] == nil) != (errs[1] == nil) {
+			t.Errorf("%s: different results with 1 and 4 jobs: %q (%v) and %q (%v)", program, outputs[0], errs[0], outputs[1], errs[1])
+		}
+	}
+}
+
+func TestProcessEachFileInputFilename(t *testing.T) {
+	program := `[input_filename, $__doc_index]`
+	encoding, _ := objconv.ByName("json")
+	for _, jobs := range []int{1, 4} {
+		files := []File{
+			newFileFromString("test-path-0.yaml", "a: 1\n---\nb: 2\n"),
+			newFileFromString("test-path-1.json", `{"c":3}`),
+		}
+
+		var outputBuf bytes.Buffer
+		err := ProcessEachFile(context.Background(), "auto", files, defaultEngine(t), program, ProgramArguments{}, &outputBuf, encoding, OutputConfig{}, false, ProcessConfig{Jobs: jobs})
+		if err != nil {
+			t.Errorf("expected no err with %d jobs, got %v", jobs, err)
+		}
+		expected := "[\"test-path-0.yaml\",0]\n[\"test-path-0.yaml\",1]\n[\"test-path-1.json\",0]\n"
+		if output := outputBuf.String(); output != expected {
+			t.Errorf("incorrect output with %d jobs expected=%q, got=%q", jobs, expected, output)
+		}
 	}
 }
 
@@ -332,7 +538,7 @@ cats: dogs
 			}
 			encoder, _ := objconv.ByName(testCase.outputFormat)
 			var outputBuf bytes.Buffer
-			err := SlurpAllFiles(testCase.inputFormat, files, testCase.program, ProgramArguments{}, &outputBuf, encoder, OutputConfig{}, testCase.raw)
+			err := SlurpAllFiles(context.Background(), testCase.inputFormat, files, defaultEngine(t), testCase.program, ProgramArguments{}, &outputBuf, encoder, OutputConfig{}, testCase.raw, ProcessConfig{})
 			if err != nil {
 				t.Errorf("expected no err, got %#v", err)
 			}
@@ -355,6 +561,7 @@ func TestExecuteProgram(t *testing.T) {
 		programArgs    ProgramArguments
 		expectedOutput string
 		raw            bool
+		expectedErr    bool
 	}{
 		{
 			name:           "null input simple program",
@@ -364,25 +571,361 @@ func TestExecuteProgram(t *testing.T) {
 			inputFormat:    "json",
 			outputFormat:   "json",
 		},
+		{
+			name:           "object input path program",
+			program:        ".foo.bar[1]",
+			input:          bytesPtr(`{"foo":{"bar":[1,"two",3]}}`),
+			expectedOutput: `"two"` + "\n",
+			inputFormat:    "json",
+			outputFormat:   "json",
+		},
+		{
+			name:           "array input multiple outputs",
+			program:        ".[] | select(. > 1) * 2",
+			input:          bytesPtr(`[1,2,3]`),
+			expectedOutput: "4\n6\n",
+			inputFormat:    "json",
+			outputFormat:   "json",
+		},
+		{
+			name:           "raw string output",
+			program:        ".name",
+			input:          bytesPtr(`{"name":"faq"}`),
+			expectedOutput: "faq\n",
+			inputFormat:    "json",
+			outputFormat:   "json",
+			raw:            true,
+		},
+		{
+			name:    "named and positional arguments",
+			program: "[$fizz, $buzz.answer, $ARGS.positional[0], $ARGS.named.fizz]",
+			input:   nil,
+			programArgs: ProgramArguments{
+				Args:       []string{"first"},
+				Kwargs:     map[string]string{"fizz": "test1"},
+				Jsonkwargs: map[string]interface{}{"buzz": map[string]interface{}{"answer": 42}},
+			},
+			expectedOutput: `["test1",42,"first","test1"]` + "\n",
+			inputFormat:    "json",
+			outputFormat:   "json",
+		},
+		{
+			name:           "large integers",
+			program:        ".",
+			input:          bytesPtr(`12345678`),
+			expectedOutput: "12345678\n",
+			inputFormat:    "json",
+			outputFormat:   "json",
+		},
+		{
+			name:         "runtime error",
+			program:      ".foo",
+			input:        bytesPtr(`[]`),
+			inputFormat:  "json",
+			outputFormat: "json",
+			expectedErr:  true,
+		},
+		{
+			name:         "compile error",
+			program:      ".foo |",
+			input:        nil,
+			inputFormat:  "json",
+			outputFormat: "json",
+			expectedErr:  true,
+		},
 	}
-	for _, testCase := range testCases {
-		testCase := testCase
-		t.Run(testCase.name, func(t *testing.T) {
-			encoder, _ := objconv.ByName(testCase.outputFormat)
-			var outputBuf bytes.Buffer
-			err := ProcessInput(testCase.input, testCase.program, testCase.programArgs, &outputBuf, encoder, OutputConfig{}, testCase.raw)
-			if err != nil {
-				t.Errorf("expected no err, got %#v", err)
-			}
+	for _, engineName := range jq.Names() {
+		engine, _ := jq.ByName(engineName)
+		for _, testCase := range testCases {
+			testCase := testCase
+			t.Run(engineName+"/"+testCase.name, func(t *testing.T) {
+				encoder, _ := objconv.ByName(testCase.outputFormat)
+				var outputBuf bytes.Buffer
+				err := ProcessInput(context.Background(), testCase.input, engine, testCase.program, testCase.programArgs, &outputBuf, encoder, OutputConfig{}, testCase.raw, ProcessConfig{})
+				if testCase.expectedErr {
+					if err == nil {
+						t.Errorf("expected err, got nil")
+					}
+					return
+				}
+				if err != nil {
+					t.Errorf("expected no err, got %#v", err)
+				}
 
-			output := outputBuf.String()
-			if output != testCase.expectedOutput {
-				t.Errorf("incorrect output expected=%s, got=%s", testCase.expectedOutput, output)
+				output := outputBuf.String()
+				if output != testCase.expectedOutput {
+					t.Errorf("incorrect output expected=%s, got=%s", testCase.expectedOutput, output)
+				}
+			})
+		}
+	}
+}
+
+func TestProcessInputStreaming(t *testing.T) {
+	encoding, _ := objconv.ByName("json")
+	for _, engineName := range jq.Names() {
+		engine, _ := jq.ByName(engineName)
+		t.Run(engineName, func(t *testing.T) {
+			// Results produced before the error must already have been
+			// written and flushed.
+			var output flushRecorder
+			err := ProcessInput(context.Background(), nil, engine, `1, 2, error("boom")`, ProgramArguments{}, &output, encoding, OutputConfig{}, false, ProcessConfig{})
+			if err == nil || !strings.Contains(err.Error(), "boom") {
+				t.Errorf("expected an error containing boom, got %v", err)
+			}
+			if output.String() != "1\n2\n" {
+				t.Errorf("incorrect output expected=%q, got=%q", "1\n2\n", output.String())
+			}
+			if output.flushes != 2 {
+				t.Errorf("expected 2 flushes, got %d", output.flushes)
 			}
 		})
 	}
 }
 
+func TestFormatBuiltins(t *testing.T) {
+	testCases := []struct {
+		program        string
+		expectedOutput string
+		expectedErr    bool
+	}{
+		{`{"a": [1, 2]} | toyaml`, `"a:\n- 1\n- 2\n"`, false},
+		{`["a: 1\n---\nb: 2\n" | fromyaml]`, `[{"a":1},{"b":2}]`, false},
+		{`["a: 1\n" | fromyaml]`, `[{"a":1}]`, false},
+		{`[{"a": 1, "b": "x"}] | tocsv`, `"a;b\n1;x\n"`, false},
+		{`["a\tb\n1\tx\n" | fromtsv]`, `[{"a":"1","b":"x"}]`, false},
+		{`{"a": {"b": "c"}} | totoml | fromtoml`, `{"a":{"b":"c"}}`, false},
+		{`"<a><b>1</b></a>" | fromxml | .a.b`, `1`, false},
+		{`["x\ny" | fromlines]`, `["x","y"]`, false},
+		{`1 | fromyaml`, ``, true},
+		{`"a: [" | fromyaml`, ``, true},
+		{`"a: 1" | fromyml`, ``, true},
+		{`{} | tomsgpack`, ``, true},
+	}
+
+	encodings := EncodingConfig{CSV: objconv.CSVEncoding{Delimiter: ';'}}
+	for _, engineName := range jq.Names() {
+		engine, _ := jq.ByName(engineName)
+		engine = WithBuiltins(engine, encodings)
+		for _, testCase := range testCases {
+			output, err := ExecuteProgram(context.Background(), nil, engine, testCase.program, ProgramArguments{}, false)
+			if testCase.expectedErr {
+				if err == nil {
+					t.Errorf("%s: expected err for %s, got nil", engineName, testCase.program)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: expected no err for %s, got %v", engineName, testCase.program, err)
+				continue
+			}
+			if len(output) != 1 || output[0] != testCase.expectedOutput {
+				t.Errorf("%s: incorrect output for %s expected=%s, got=%q", engineName, testCase.program, testCase.expectedOutput, output)
+			}
+		}
+	}
+}
+
+// flushRecorder is a buffered writer that counts how often it's flushed.
+type flushRecorder struct {
+	bytes.Buffer
+	flushes int
+}
+
+func (r *flushRecorder) Flush() error {
+	r.flushes++
+	return nil
+}
+
+func defaultEngine(tb testing.TB) jq.Engine {
+	engine, ok := jq.ByName(jq.DefaultEngine())
+	if !ok {
+		tb.Fatalf("default engine %s is not registered", jq.DefaultEngine())
+	}
+	return engine
+}
+
+func bytesPtr(s string) *[]byte {
+	b := []byte(s)
+	return &b
+}
+
 func newFileFromString(path, content string) File {
 	return NewFile(path, ioutil.NopCloser(strings.NewReader(content)))
 }
+
+func BenchmarkProcessEachFile(b *testing.B) {
+	var stream strings.Builder
+	for i := 0; i < 1000; i++ {
+		stream.WriteString("---\nkind: Deployment\nmetadata:\n  name: web-" + strconv.Itoa(i) + "\nspec:\n  replicas: 3\n")
+	}
+	encoding, _ := objconv.ByName("json")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		files := []File{newFileFromString("bench.yaml", stream.String())}
+		err := ProcessEachFile(context.Background(), "yaml", files, defaultEngine(b), ".metadata.name", ProgramArguments{}, ioutil.Discard, encoding, OutputConfig{}, false, ProcessConfig{})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProcessEachFileInPlace(t *testing.T) {
+	for _, jobs := range []int{1, 4} {
+		dir, err := ioutil.TempDir("", "faq-in-place")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		contents := map[string]string{
+			"a.yaml": "# config\nname: web # the name\nreplicas: 2\n",
+			"b.json": `{"replicas": 2}`,
+			"c.json": "{\"replicas\":3}\n",
+		}
+		var files []File
+		for _, name := range []string{"a.yaml", "b.json", "c.json"} {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(contents[name]), 0600); err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, newFileFromString(path, contents[name]))
+		}
+
+		// The output encoding and writer aren't used for files written in
+		// place.
+		encoding, _ := objconv.ByName("xml")
+		var outputBuf bytes.Buffer
+		outputConf := OutputConfig{Edit: true, InPlace: true, BackupSuffix: ".bak"}
+		err = ProcessEachFile(context.Background(), "auto", files, defaultEngine(t), ".replicas = 3", ProgramArguments{}, &outputBuf, encoding, outputConf, false, ProcessConfig{Jobs: jobs})
+		if err != nil {
+			t.Fatalf("expected no err with jobs=%d, got %v", jobs, err)
+		}
+		if outputBuf.Len() != 0 {
+			t.Errorf("expected no output with jobs=%d, got %q", jobs, outputBuf.String())
+		}
+
+		expected := map[string]string{
+			"a.yaml":     "# config\nname: web # the name\nreplicas: 3\n",
+			"a.yaml.bak": contents["a.yaml"],
+			"b.json":     "{\"replicas\":3}\n",
+			"b.json.bak": contents["b.json"],
+			// Files that are unchanged aren't written.
+			"c.json": contents["c.json"],
+		}
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != len(expected) {
+			t.Errorf("expected %d files with jobs=%d, got %d", len(expected), jobs, len(infos))
+		}
+		for name, expectedContents := range expected {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("expected no err reading %s with jobs=%d, got %v", name, jobs, err)
+			} else if string(data) != expectedContents {
+				t.Errorf("incorrect contents of %s with jobs=%d expected=%q, got=%q", name, jobs, expectedContents, data)
+			}
+		}
+	}
+}
+
+func TestProcessEachFileInPlaceDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faq-in-place")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.toml")
+	contents := "# config\nname = \"web\"\nreplicas = 2\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var outputBuf bytes.Buffer
+	encoding, _ := objconv.ByName("json")
+	outputConf := OutputConfig{Edit: true, InPlace: true, DryRun: true}
+	err = ProcessEachFile(context.Background(), "auto", []File{newFileFromString(path, contents)}, defaultEngine(t), ".replicas = 3", ProgramArguments{}, &outputBuf, encoding, outputConf, false, ProcessConfig{})
+	if err != nil {
+		t.Fatalf("expected no err, got %v", err)
+	}
+	expected := "--- " + path + "\n+++ " + path + "\n@@ -1,3 +1,3 @@\n # config\n name = \"web\"\n-replicas = 2\n+replicas = 3\n"
+	if output := outputBuf.String(); output != expected {
+		t.Errorf("incorrect output expected=%q, got=%q", expected, output)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != contents {
+		t.Errorf("expected the file to be unchanged, got %q", data)
+	}
+}
+
+func TestProcessEachFileInPlaceEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faq-in-place")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.json")
+	contents := "{\"a\":1}\n"
+	for _, allowEmpty := range []bool{false, true} {
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		encoding, _ := objconv.ByName("json")
+		outputConf := OutputConfig{InPlace: true, AllowEmpty: allowEmpty}
+		err = ProcessEachFile(context.Background(), "auto", []File{newFileFromString(path, contents)}, defaultEngine(t), "select(.a == 2)", ProgramArguments{}, ioutil.Discard, encoding, outputConf, false, ProcessConfig{})
+
+		expected := contents
+		if allowEmpty {
+			expected = ""
+			if err != nil {
+				t.Errorf("expected no err with AllowEmpty, got %v", err)
+			}
+		} else if !errors.Is(err, ErrEmptyFile) {
+			t.Errorf("expected ErrEmptyFile, got %v", err)
+		}
+		if data, _ := ioutil.ReadFile(path); string(data) != expected {
+			t.Errorf("incorrect contents with allowEmpty=%t expected=%q, got=%q", allowEmpty, expected, data)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(from, to int) string {
+		var buf strings.Builder
+		for i := from; i <= to; i++ {
+			buf.WriteString(strconv.Itoa(i) + "\n")
+		}
+		return buf.String()
+	}
+
+	testCases := []struct {
+		a, b     string
+		expected string
+	}{
+		{"same\n", "same\n", ""},
+		{"", "new\n", "--- f\n+++ f\n@@ -0,0 +1 @@\n+new\n"},
+		{"a\nb", "a\nc", "--- f\n+++ f\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"},
+		{
+			// Changes less than seven lines apart share a hunk.
+			lines(1, 20),
+			"0\n" + lines(1, 6) + "x\n" + lines(8, 20),
+			"--- f\n+++ f\n@@ -1,10 +1,11 @@\n+0\n 1\n 2\n 3\n 4\n 5\n 6\n-7\n+x\n 8\n 9\n 10\n",
+		},
+		{
+			lines(1, 20),
+			lines(1, 1) + "x\n" + lines(3, 17) + "y\n" + lines(19, 20),
+			"--- f\n+++ f\n@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n 4\n 5\n@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+y\n 19\n 20\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		if diff := unifiedDiff("f", []byte(tc.a), []byte(tc.b)); diff != tc.expected {
+			t.Errorf("incorrect diff of %q and %q expected=%q, got=%q", tc.a, tc.b, tc.expected, diff)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package faq
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// diffContext is the number of unchanged lines around the changes of a
+// unified diff.
+const diffContext = 3
+
+// writeFileAtomically replaces the contents of the file at path with data.
+//
+// data is written to a temporary file in the same directory, which is then
+// renamed over the file, so that it's never left partially written. The file
+// keeps its permissions, and if path is a symlink, the file it points to is
+// replaced. If backupSuffix isn't empty, original is first written to the
+// path of the file with backupSuffix appended.
+func writeFileAtomically(path string, data, original []byte, backupSuffix string) error {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to write file at %s: `%s`", path, err)
+	}
+
+	if backupSuffix != "" {
+		if err := ioutil.WriteFile(path+backupSuffix, original, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write backup of file at %s: `%s`", path, err)
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".faq-")
+	if err != nil {
+		return fmt.Errorf("failed to write file at %s: `%s`", path, err)
+	}
+	// Once the temporary file has been renamed, there's nothing to remove.
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file at %s: `%s`", path, err)
+	}
+	return nil
+}
+
+// diffLine is a line of a unified diff: an unchanged line, a removed line or
+// an added line, marked by ' ', '-' or '+'.
+type diffLine struct {
+	mark byte
+	text string
+}
+
+// unifiedDiff returns the changes from a to b, the contents of the file at
+// path before and after they were made, as a unified diff. It returns "" if
+// they're the same.
+func unifiedDiff(path string, a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+
+	dmp := diffmatchpatch.New()
+	runesA, runesB, lineArray := dmp.DiffLinesToRunes(string(a), string(b))
+	diffs := dmp.DiffCharsToLines(dmp.DiffMainRunes(runesA, runesB, false), lineArray)
+
+	var lines []diffLine
+	for _, diff := range diffs {
+		mark := byte(' ')
+		switch diff.Type {
+		case diffmatchpatch.DiffDelete:
+			mark = '-'
+		case diffmatchpatch.DiffInsert:
+			mark = '+'
+		}
+		for _, text := range strings.SplitAfter(diff.Text, "\n") {
+			if text != "" {
+				lines = append(lines, diffLine{mark, text})
+			}
+		}
+	}
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", path, path)
+	// oldLine and newLine are the number of lines of a and b before lines[i].
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(lines); {
+		if lines[i].mark == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// A hunk starts with the context before the change and extends
+		// until the context after its last change, merging changes whose
+		// contexts overlap.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for unchanged := 0; end < len(lines) && unchanged <= 2*diffContext; end++ {
+			if lines[end].mark == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > i && lines[end-1].mark == ' ' {
+			end--
+		}
+		if end += diffContext; end > len(lines) {
+			end = len(lines)
+		}
+
+		oldStart, newStart := oldLine-(i-start), newLine-(i-start)
+		oldCount, newCount := 0, 0
+		for _, line := range lines[start:end] {
+			if line.mark != '+' {
+				oldCount++
+			}
+			if line.mark != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, line := range lines[start:end] {
+			buf.WriteByte(line.mark)
+			buf.WriteString(line.text)
+			if !strings.HasSuffix(line.text, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		oldLine, newLine = oldStart+oldCount, newStart+newCount
+		i = end
+	}
+	return buf.String()
+}
+
+// hunkRange formats the range of the lines of a hunk, whose first line is
+// the line after start.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
@@ -0,0 +1,156 @@
+package faq
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jzelinskie/faq/internal/jq"
+)
+
+// fileResult contains the outputs of running a program against every JSON
+// value in the file at index.
+type fileResult struct {
+	index   int
+	outputs []string
+	err     error
+	// documents holds the index of the value of the file each output came
+	// from, as processDocuments passes it.
+	documents []int
+
+	// output describes how the outputs are written, if they aren't written
+	// as usual.
+	output *fileOutput
+}
+
+// processFilesConcurrently decodes and evaluates files on a pool of
+// processConf.Jobs workers, each with its own compiled program.
+//
+// Because results is only ever used from the calling goroutine, results are
+// buffered per file. When processConf.Unordered is false, a file's results
+// are held until every file before it has been written, so output and errors
+// match the order of ProcessEachFile run sequentially.
+func processFilesConcurrently(ctx context.Context, inputFormat string, files []File, engine jq.Engine, program string, programArgs ProgramArguments, results *resultWriter, rawOutput bool, processConf ProcessConfig) error {
+	jobs := processConf.Jobs
+	if jobs > len(files) {
+		jobs = len(files)
+	}
+
+	progs := make([]jq.Program, 0, jobs)
+	docs := make([]*documents, 0, jobs)
+	defer func() {
+		for _, prog := range progs {
+			prog.Close()
+		}
+	}()
+	for i := 0; i < jobs; i++ {
+		// Each worker reads the file it is processing through its own
+		// documents. Programs that read the values of other files with input
+		// and inputs aren't processed concurrently.
+		workerDocs := newDocuments(inputFormat, processConf.Stream, processConf.Encodings, nil)
+		prog, err := compileProgram(engine, program, programArgs, workerDocs)
+		if err != nil {
+			return err
+		}
+		progs = append(progs, prog)
+		docs = append(docs, workerDocs)
+	}
+
+	indexes := make(chan int)
+	fileResults := make(chan fileResult)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	// Stop the workers and wait for them to exit before the deferred
+	// function above closes their programs. Cancelling ctx interrupts any
+	// files still being processed when returning early.
+	defer wg.Wait()
+	defer close(done)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		defer close(indexes)
+		for i := range files {
+			select {
+			case indexes <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for worker, prog := range progs {
+		wg.Add(1)
+		go func(prog jq.Program, docs *documents) {
+			defer wg.Done()
+			for i := range indexes {
+				result := fileResult{index: i}
+				output, file, err := results.openFile(inputFormat, files[i], processConf.Encodings)
+				result.output = output
+				result.err = err
+				if err == nil {
+					docs.reset([]File{file})
+					result.err = processDocuments(ctx, docs, prog, rawOutput, processConf.Limit, func(output string, document int) error {
+						result.outputs = append(result.outputs, output)
+						result.documents = append(result.documents, document)
+						return nil
+					})
+				}
+				select {
+				case fileResults <- result:
+				case <-done:
+					return
+				}
+			}
+		}(prog, docs[worker])
+	}
+
+	// write writes the outputs of a file, up to processConf.Limit in total,
+	// and reports whether the limit has been reached. Outputs written in
+	// place are written to the file once they all have been encoded.
+	written := 0
+	write := func(result fileResult) (bool, error) {
+		results.startFile(result.output)
+		outputs := result.outputs
+		limited := processConf.Limit > 0 && written+len(outputs) >= processConf.Limit
+		if limited {
+			outputs = outputs[:processConf.Limit-written]
+		}
+		written += len(outputs)
+		for i, output := range outputs {
+			if err := results.write(output, result.documents[i]); err != nil {
+				return false, err
+			}
+		}
+		return limited, results.finishFile()
+	}
+
+	pending := make(map[int]fileResult)
+	for next := 0; next < len(files); {
+		result := <-fileResults
+		if processConf.Unordered {
+			if result.err != nil {
+				return result.err
+			}
+			if limited, err := write(result); limited || err != nil {
+				return err
+			}
+			next++
+			continue
+		}
+
+		pending[result.index] = result
+		for result, ok := pending[next]; ok; result, ok = pending[next] {
+			if result.err != nil {
+				return result.err
+			}
+			if limited, err := write(result); limited || err != nil {
+				return err
+			}
+			delete(pending, next)
+			next++
+		}
+	}
+
+	return nil
+}
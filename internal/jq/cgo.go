@@ -1,3 +1,6 @@
+//go:build cgo && !nolibjq
+// +build cgo,!nolibjq
+
 package jq
 
 /*
@@ -21,5 +24,23 @@ void gojq_set_error_cb(jq_state *jq, unsigned long long id) {
 void gojq_reset_error_cb(jq_state *jq) {
 	jq_set_error_cb(jq, NULL, NULL);
 };
+
+jv inputCallback(unsigned long long);
+jv gojq_input_cb(jq_state *jq, void *data) {
+  return inputCallback((unsigned long long)data);
+};
+
+void debugCallback(unsigned long long, jv);
+void gojq_debug_cb(void *data, jv jv) {
+  debugCallback((unsigned long long)data, jv);
+};
+
+// This sets the jq_input_cb and jq_debug_cb to gojq_input_cb and
+// gojq_debug_cb, casting the id into a void*, for the same reason as
+// gojq_set_error_cb.
+void gojq_set_callbacks(jq_state *jq, unsigned long long id) {
+	jq_set_input_cb(jq, gojq_input_cb, (void*)id);
+	jq_set_debug_cb(jq, (jq_msg_cb)gojq_debug_cb, (void*)id);
+};
 */
 import "C"
@@ -1,7 +1,21 @@
-// Package jq implements C bindings to libjq 1.6-rc2+.
+//go:build cgo && !nolibjq
+// +build cgo,!nolibjq
+
+// This file implements an Engine using C bindings to libjq 1.6-rc2+.
+//
+// libjq reports errors via callbacks, which are routed back to the call that
+// caused them through an ID registered in callbackErrors. Likewise, the input
+// and debug callbacks find the state of their program through an ID
+// registered in programCallbacks. A jq_state cannot be shared between
+// threads, so each libjqProgram serializes access to its own state. The only
+// exception is jq_halt, which is used to stop a program from another
+// goroutine when the context of its run is done.
 //
-// Because libjq uses callbacks for error handling, this library should not
-// be considered thread-safe.
+// libjq has no API for defining builtins, so input_filename, $__doc_index,
+// stderr and registered Functions are implemented in jq as "host calls": a
+// marker naming the call, followed by its input, is passed to debug, whose
+// callback records it, and the following call to input returns its result.
+
 package jq
 
 /*
@@ -12,14 +26,18 @@ package jq
 
 void gojq_set_error_cb(jq_state*, unsigned long long);
 void gojq_reset_error_cb(jq_state*);
+void gojq_set_callbacks(jq_state*, unsigned long long);
 */
 import "C"
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"math/rand"
+	"io"
 	"reflect"
+	"sync"
 	"unsafe"
 )
 
@@ -29,52 +47,171 @@ func errorCallback(id C.ulonglong, failedJV C.jv) {
 	if err == nil {
 		panic("error callback executed for nonexistant error")
 	}
-	callbackID := uint64(id)
-	callbackErrors[callbackID] = append(callbackErrors[callbackID], err)
+	callbackErrors.append(uint64(id), err)
 }
 
-func jvToGoValue(jv C.jv) interface{} {
-	kind := C.jv_get_kind(jv)
-	switch kind {
-	case C.JV_KIND_NULL:
-		return nil
-	case C.JV_KIND_FALSE:
-		return false
-	case C.JV_KIND_TRUE:
-		return true
-	case C.JV_KIND_NUMBER:
-		val := C.jv_number_value(jv)
-		if C.jv_is_integer(jv) == 1 {
-			return int(val)
+// hostCallMarker is the first element of the array that is passed to debug to
+// make a host call.
+const hostCallMarker = "__faq_hostcall"
+
+// hostCallPrelude defines the builtins that are implemented as host calls.
+var hostCallPrelude = map[string]string{
+	"input_filename": `def input_filename: ["` + hostCallMarker + `", "input_filename"] | debug | input; `,
+	"stderr":         `def stderr: ["` + hostCallMarker + `", "stderr", .] | debug | input; `,
+	DocIndexVariable: `(["` + hostCallMarker + `", "doc_index"] | debug | input) as ` + DocIndexVariable + ` | `,
+}
+
+// functionPrelude returns the definition of a Function. Its host call returns
+// an array of its results.
+func functionPrelude(name string) string {
+	return `def ` + name + `: ["` + hostCallMarker + `", "` + name + `", .] | debug | input | .[]; `
+}
+
+//export inputCallback
+func inputCallback(id C.ulonglong) C.jv {
+	callbacks := programCallbacks.get(uint64(id))
+	if callbacks == nil {
+		return C.jv_invalid()
+	}
+	if name := callbacks.hostCall; name != "" {
+		callbacks.hostCall = ""
+		return callbacks.call(name)
+	}
+
+	inputs := callbacks.inputs
+	if inputs == nil {
+		return C.jv_invalid()
+	}
+
+	data, err := inputs.Next()
+	if err == io.EOF {
+		// An invalid value without a message tells libjq there are no more
+		// inputs.
+		return C.jv_invalid()
+	}
+	if err != nil {
+		return C.jv_invalid_with_msg(jvString(err.Error()))
+	}
+
+	dataPtr := C.CString(string(data))
+	defer C.free(unsafe.Pointer(dataPtr))
+	return C.jv_parse(dataPtr)
+}
+
+//export debugCallback
+func debugCallback(id C.ulonglong, msg C.jv) {
+	defer C.jv_free(msg)
+
+	if callbacks := programCallbacks.get(uint64(id)); callbacks != nil {
+		if name, input, ok := hostCall(msg); ok {
+			callbacks.hostCall = name
+			callbacks.hostCallInput = input
+			return
 		}
-		return float64(val)
-	case C.JV_KIND_STRING:
-		return C.GoString(C.jv_string_value(jv))
-	case C.JV_KIND_ARRAY:
-		arrayLen := int(C.jv_array_length(C.jv_copy(jv)))
-		array := make([]interface{}, arrayLen)
-		for i := 0; i < arrayLen; i++ {
-			v := C.jv_array_get(C.jv_copy(jv), C.int(i))
-			array[i] = jvToGoValue(v)
-			C.jv_free(v)
+	}
+
+	// Print messages the same way the jq command line tool does.
+	fmt.Fprintf(stderr, "[\"DEBUG:\",%s]\n", dumpJvToGoStr(msg))
+}
+
+// hostCall returns the name of the host call that msg makes, if it is one,
+// and its input as JSON if it has one.
+func hostCall(msg C.jv) (string, []byte, bool) {
+	if C.jv_get_kind(msg) != C.JV_KIND_ARRAY {
+		return "", nil, false
+	}
+	length := C.jv_array_length(C.jv_copy(msg))
+	if length != 2 && length != 3 {
+		return "", nil, false
+	}
+	marker, name := C.jv_array_get(C.jv_copy(msg), 0), C.jv_array_get(C.jv_copy(msg), 1)
+	defer C.jv_free(marker)
+	defer C.jv_free(name)
+
+	if C.jv_get_kind(marker) != C.JV_KIND_STRING || C.jv_get_kind(name) != C.JV_KIND_STRING ||
+		C.GoString(C.jv_string_value(marker)) != hostCallMarker {
+		return "", nil, false
+	}
+
+	var input []byte
+	if length == 3 {
+		inputJv := C.jv_array_get(C.jv_copy(msg), 2)
+		input = []byte(dumpJvToGoStr(inputJv))
+		C.jv_free(inputJv)
+	}
+	return C.GoString(C.jv_string_value(name)), input, true
+}
+
+// libjqCallbacks is the state of a program used by its input and debug
+// callbacks. They are only called by the goroutine running the program, so it
+// isn't guarded.
+type libjqCallbacks struct {
+	inputs    Inputs
+	functions map[string]Function
+	// hostCall is the name of the host call whose result is returned by the
+	// next call to the input callback, and hostCallInput is its input.
+	hostCall      string
+	hostCallInput []byte
+}
+
+// call returns the result of a host call.
+func (c *libjqCallbacks) call(name string) C.jv {
+	switch name {
+	case "input_filename":
+		if c.inputs == nil || c.inputs.Filename() == "" {
+			return C.jv_null()
 		}
-		return array
-	case C.JV_KIND_OBJECT:
-		obj := make(map[string]interface{})
-		for iter := C.jv_object_iter(jv); C.jv_object_iter_valid(jv, iter) == 1; iter = C.jv_object_iter_next(jv, iter) {
-			jvKey := C.jv_object_iter_key(jv, iter)
-			jvValue := C.jv_object_iter_value(jv, iter)
-			// This is safe because jv_object_iter_key asserts the jv is a string.
-			key := C.GoString(C.jv_string_value(jvKey))
-
-			obj[key] = jvToGoValue(jvValue)
-
-			C.jv_free(jvKey)
-			C.jv_free(jvValue)
+		return jvString(c.inputs.Filename())
+	case "doc_index":
+		if c.inputs == nil || c.inputs.Index() < 0 {
+			return C.jv_null()
+		}
+		return jvNumber(c.inputs.Index())
+	case "stderr":
+		// Like libjq's own stderr, which writes to C's stderr instead.
+		stderr.Write(c.hostCallInput)
+		inputPtr := C.CString(string(c.hostCallInput))
+		defer C.free(unsafe.Pointer(inputPtr))
+		return C.jv_parse(inputPtr)
+	}
+
+	fn, ok := c.functions[name]
+	if !ok {
+		return C.jv_invalid_with_msg(jvString("unknown host call " + name))
+	}
+	output, err := fn(c.hostCallInput)
+	if err != nil {
+		return C.jv_invalid_with_msg(jvString(err.Error()))
+	}
+	results, err := functionResults(output)
+	if err != nil {
+		return C.jv_invalid_with_msg(jvString(err.Error()))
+	}
+	array := "[" + string(bytes.Join(results, []byte{','})) + "]"
+	outputPtr := C.CString(array)
+	defer C.free(unsafe.Pointer(outputPtr))
+	return C.jv_parse(outputPtr)
+}
+
+// withHostCalls adds the definitions of the host calls that program uses,
+// including those of functions, after its module directives. The prelude is
+// kept on the line they end on so that the line numbers of errors are
+// unchanged.
+func withHostCalls(program string, functions map[string]Function) string {
+	names := programNames(program)
+	var prelude string
+	for _, name := range functionNames(functions) {
+		if names[name] {
+			prelude += functionPrelude(name)
+		}
+	}
+	for _, name := range []string{"input_filename", "stderr", DocIndexVariable} {
+		if names[name] {
+			prelude += hostCallPrelude[name]
 		}
-		return obj
 	}
-	panic("unreachable")
+	end := directivesEnd(program)
+	return program[:end] + prelude + program[end:]
 }
 
 func dumpJvToGoStr(jv C.jv) string {
@@ -165,12 +302,86 @@ func jvNumber(i interface{}) C.jv {
 	panic("unknown type for go number")
 }
 
-var (
-	// libjq uses callbacks for error handling.
-	// This map stores errors under a key for a particular call.
-	// See https://github.com/golang/go/wiki/cgo#function-variables
-	callbackErrors = make(map[uint64][]error)
-)
+// libjq uses callbacks for error handling.
+// This registry stores errors under a key for a particular call.
+// See https://github.com/golang/go/wiki/cgo#function-variables
+var callbackErrors = &errorRegistry{errors: make(map[uint64][]error)}
+
+// errorRegistry is a concurrency-safe map from callback IDs to the errors
+// reported for them.
+type errorRegistry struct {
+	sync.Mutex
+	nextID uint64
+	errors map[uint64][]error
+}
+
+// register returns a new ID that errors can be appended to.
+func (r *errorRegistry) register() uint64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.nextID++
+	r.errors[r.nextID] = nil
+	return r.nextID
+}
+
+// append records an error for a registered ID.
+func (r *errorRegistry) append(id uint64, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if errs, ok := r.errors[id]; ok {
+		r.errors[id] = append(errs, err)
+	}
+}
+
+// unregister removes an ID and returns the errors recorded for it.
+func (r *errorRegistry) unregister(id uint64) []error {
+	r.Lock()
+	defer r.Unlock()
+
+	errs := r.errors[id]
+	delete(r.errors, id)
+	return errs
+}
+
+// programCallbacks stores the callback state of each program under a key for
+// its jq_state's input and debug callbacks.
+var programCallbacks = &callbacksRegistry{callbacks: make(map[uint64]*libjqCallbacks)}
+
+// callbacksRegistry is a concurrency-safe map from callback IDs to the
+// callback state of programs.
+type callbacksRegistry struct {
+	sync.Mutex
+	nextID    uint64
+	callbacks map[uint64]*libjqCallbacks
+}
+
+// register returns a new ID for callbacks.
+func (r *callbacksRegistry) register(callbacks *libjqCallbacks) uint64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.nextID++
+	r.callbacks[r.nextID] = callbacks
+	return r.nextID
+}
+
+// get returns the callback state registered for an ID.
+func (r *callbacksRegistry) get(id uint64) *libjqCallbacks {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.callbacks[id]
+}
+
+// unregister removes an ID.
+func (r *callbacksRegistry) unregister(id uint64) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.callbacks, id)
+}
 
 func errorFromJv(jv C.jv) error {
 	jv = C.jq_format_error(jv)
@@ -182,63 +393,108 @@ func errorFromJv(jv C.jv) error {
 	return errors.New(C.GoString(C.jv_string_value(jv)))
 }
 
-// Exec compiles a JQ program with the provided args and executes it with the
-// provided input.
-//
-// The args and input parameters are expected to be JSON bytes.
-// If the args parameter is not null, an array, or an object, then ErrWrongType
-// is returned.
-func Exec(program string, args, input []byte, raw bool) ([]string, error) {
+var (
+	_ Engine           = libjqEngine{}
+	_ functionCompiler = libjqEngine{}
+	_ Program          = &libjqProgram{}
+)
+
+// libjqEngine is an Engine that compiles programs with libjq.
+type libjqEngine struct{}
+
+// libjqProgram is a jq program that has been compiled by libjq.
+type libjqProgram struct {
+	// mu guards state, which libjq does not allow to be used concurrently.
+	mu    sync.Mutex
+	state *C.struct_jq_state
+	// callbacksID is the ID of the program's callback state in
+	// programCallbacks.
+	callbacksID uint64
+}
+
+// Compile implements Engine.
+func (e libjqEngine) Compile(program string, args []byte, inputs Inputs) (Program, error) {
+	return e.compileWithFunctions(program, args, inputs, nameToFunction)
+}
+
+// compileWithFunctions implements functionCompiler.
+func (libjqEngine) compileWithFunctions(program string, args []byte, inputs Inputs, functions map[string]Function) (Program, error) {
 	state, err := C.jq_init()
 	if err != nil {
 		return nil, err
 	} else if state == nil {
 		panic("failed to initialize jq state")
 	}
-	defer C.jq_teardown(&state)
+	p := &libjqProgram{state: state}
+	p.callbacksID = programCallbacks.register(&libjqCallbacks{inputs: inputs, functions: functions})
+	C.gojq_set_callbacks(state, C.ulonglong(p.callbacksID))
+	// libjq aborts on imports unless it has paths to search for modules.
+	C.jq_set_attr(state, jvString("JQ_LIBRARY_PATH"), jvInterface(libraryPaths()))
 
 	argsPtr := C.CString(string(args))
 	defer C.free(unsafe.Pointer(argsPtr))
 	argsJv := C.jv_parse(argsPtr)
 	if C.jv_is_valid(argsJv) == 0 {
+		p.Close()
 		return nil, errorFromJv(argsJv)
 	}
 	defer C.jv_free(argsJv)
 
+	errs := compile(state, withHostCalls(program, functions), argsJv)
+	if len(errs) != 0 {
+		p.Close()
+		err := errs[0]
+		for i := 1; i < len(errs); i++ {
+			err = errors.New(err.Error() + "; " + errs[i].Error())
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Run implements Program.
+func (p *libjqProgram) Run(ctx context.Context, input []byte, raw bool, fn func(output string) error) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+
 	inputPtr := C.CString(string(input))
 	defer C.free(unsafe.Pointer(inputPtr))
 	inputJv := C.jv_parse(inputPtr)
 	if C.jv_is_valid(inputJv) == 0 {
-		return nil, errorFromJv(inputJv)
+		return errorFromJv(inputJv)
 	}
 	defer C.jv_free(inputJv)
 
-	return executeProgram(state, program, argsJv, inputJv, raw)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return execute(ctx, p.state, inputJv, raw, fn)
 }
 
-// executeProgram compiles and executes a jq program with the provided
-// arguments and input.
-func executeProgram(state *C.struct_jq_state, program string, args, input C.jv, raw bool) ([]string, error) {
-	errs := compile(state, program, args)
-	if len(errs) != 0 {
-		err := errs[0]
-		for i := 1; i < len(errs); i++ {
-			err = errors.New(err.Error() + "; " + errs[i].Error())
-		}
-		return nil, err
+// Close implements Program.
+func (p *libjqProgram) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != nil {
+		C.jq_teardown(&p.state)
+	}
+	if p.callbacksID != 0 {
+		programCallbacks.unregister(p.callbacksID)
+		p.callbacksID = 0
 	}
-
-	return execute(state, input, raw)
 }
 
-// execute performs an execution of the previous compiled program.
+// execute performs an execution of the previous compiled program, passing
+// each result to fn as soon as jq_next produces it.
 // compile() must be called before this function.
-func execute(state *C.struct_jq_state, input C.jv, raw bool) ([]string, error) {
+func execute(ctx context.Context, state *C.struct_jq_state, input C.jv, raw bool, fn func(output string) error) error {
 	// I can't figure out where, but it seems like jq_start frees input.
 	C.jq_start(state, C.jv_copy(input), C.int(0))
 
-	results := make([]string, 0)
+	stop := haltWhenDone(ctx, state)
 
+	var fnErr error
 	var result C.jv
 	for result = C.jq_next(state); C.jv_is_valid(result) == 1; result = C.jq_next(state) {
 		var str string
@@ -247,11 +503,96 @@ func execute(state *C.struct_jq_state, input C.jv, raw bool) ([]string, error) {
 		} else {
 			str = dumpJvToGoStr(result)
 		}
-		results = append(results, str)
 		C.jv_free(result)
+
+		if fnErr = fn(str); fnErr != nil {
+			// No more results are pulled, so the program ends here.
+			result = C.jv_invalid()
+			break
+		}
 	}
 
-	return results, invalidError(result)
+	halted := stop()
+	switch {
+	case fnErr == Stop:
+		C.jv_free(result)
+		return nil
+	case fnErr != nil:
+		C.jv_free(result)
+		return fnErr
+	case halted:
+		C.jv_free(result)
+		return contextError(ctx.Err())
+	case C.jq_halted(state) != 0:
+		C.jv_free(result)
+		return haltError(state)
+	}
+	return invalidError(result)
+}
+
+// haltError returns the *HaltError for a program that halted itself, reading
+// its exit code and message the way the jq command line tool does.
+func haltError(state *C.struct_jq_state) error {
+	err := &HaltError{}
+	code := C.jq_get_exit_code(state)
+	switch {
+	case C.jv_is_valid(code) == 0:
+	case C.jv_get_kind(code) == C.JV_KIND_NUMBER:
+		err.ExitCode = int(C.jv_number_value(code))
+	default:
+		err.ExitCode = 5
+	}
+	C.jv_free(code)
+
+	msg := C.jq_get_error_message(state)
+	switch {
+	case C.jv_get_kind(msg) == C.JV_KIND_STRING:
+		err.Message = C.GoStringN(C.jv_string_value(msg), C.jv_string_length_bytes(C.jv_copy(msg)))
+	case C.jv_get_kind(msg) == C.JV_KIND_NULL, C.jv_is_valid(msg) == 0:
+	default:
+		err.Message = dumpJvToGoStr(msg) + "\n"
+	}
+	C.jv_free(msg)
+	return err
+}
+
+// haltWhenDone halts state once ctx is done, which makes a pending or future
+// call to jq_next return. This stops programs that never produce a result,
+// such as `last(range(1e12))`, as well as those that never stop producing
+// them.
+//
+// The returned function must be called once jq_next has returned its last
+// value. It reports whether state was halted because ctx is done and
+// guarantees state isn't halted afterwards.
+func haltWhenDone(ctx context.Context, state *C.struct_jq_state) func() bool {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+
+	var mu sync.Mutex
+	running, halted := true, false
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			defer mu.Unlock()
+			// jq_halt asserts that the program hasn't halted itself.
+			if running && C.jq_halted(state) == 0 {
+				C.jq_halt(state, C.jv_invalid(), C.jv_invalid())
+				halted = true
+			}
+		case <-finished:
+		}
+	}()
+
+	return func() bool {
+		close(finished)
+		mu.Lock()
+		defer mu.Unlock()
+		running = false
+		return halted
+	}
 }
 
 func invalidError(jv C.jv) error {
@@ -272,26 +613,13 @@ func invalidError(jv C.jv) error {
 // collectErrors wraps a closure that calls jv functions that perform error
 // handling via callback.
 func collectErrors(state *C.struct_jq_state, fn func()) []error {
-	callbackID := rand.Uint64()
+	callbackID := callbackErrors.register()
 	C.gojq_set_error_cb(state, C.ulonglong(callbackID))
-	defer C.gojq_reset_error_cb(state)
-
-	callbackErrors[callbackID] = nil
-	defer delete(callbackErrors, callbackID)
 
 	fn()
 
-	return callbackErrors[callbackID]
-}
-
-// ErrWrongType is returned from functions when an assertion about the type of
-// a value fails.
-type ErrWrongType struct {
-	message string
-}
-
-func (e *ErrWrongType) Error() string {
-	return e.message
+	C.gojq_reset_error_cb(state)
+	return callbackErrors.unregister(callbackID)
 }
 
 // compile prepares a jq program for execution.
@@ -315,3 +643,7 @@ func compile(state *C.struct_jq_state, program string, args C.jv) []error {
 func jvKindName(kind C.jv_kind) string {
 	return C.GoString(C.jv_kind_name(kind))
 }
+
+func init() {
+	Register("libjq", libjqEngine{})
+}
@@ -0,0 +1,361 @@
+package jq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+var (
+	_ Engine           = gojqEngine{}
+	_ functionCompiler = gojqEngine{}
+	_ Program          = &gojqProgram{}
+)
+
+// gojqEngine is an Engine that compiles programs with gojq, a pure Go
+// implementation of jq.
+type gojqEngine struct{}
+
+// gojqProgram is a jq program that has been compiled by gojq.
+type gojqProgram struct {
+	code   *gojq.Code
+	values []interface{}
+	inputs Inputs
+}
+
+// Compile implements Engine.
+func (e gojqEngine) Compile(program string, args []byte, inputs Inputs) (Program, error) {
+	return e.compileWithFunctions(program, args, inputs, nameToFunction)
+}
+
+// compileWithFunctions implements functionCompiler.
+func (gojqEngine) compileWithFunctions(program string, args []byte, inputs Inputs, functions map[string]Function) (Program, error) {
+	var argsValue interface{}
+	if err := unmarshalGojqValue(args, &argsValue); err != nil {
+		return nil, err
+	}
+	names, values, err := gojqVariables(argsValue)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := gojq.Parse(program)
+	if err != nil {
+		return nil, err
+	}
+	options := []gojq.CompilerOption{
+		gojq.WithVariables(append(names, DocIndexVariable)),
+		gojq.WithEnvironLoader(os.Environ),
+		gojq.WithModuleLoader(gojq.NewModuleLoader(libraryPaths())),
+		gojq.WithInputIter(&gojqInputIter{inputs}),
+		gojq.WithFunction("input_filename", 0, 0, func(interface{}, []interface{}) interface{} {
+			if inputs == nil || inputs.Filename() == "" {
+				return nil
+			}
+			return inputs.Filename()
+		}),
+		// gojq leaves debug and stderr to its command line tool.
+		gojq.WithFunction("debug", 0, 0, func(v interface{}, _ []interface{}) interface{} {
+			msg, err := marshalGojqValue(v)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(stderr, "[\"DEBUG:\",%s]\n", msg)
+			return v
+		}),
+		gojq.WithFunction("stderr", 0, 0, func(v interface{}, _ []interface{}) interface{} {
+			msg, err := marshalGojqValue(v)
+			if err != nil {
+				return err
+			}
+			io.WriteString(stderr, msg)
+			return v
+		}),
+	}
+	for _, name := range functionNames(functions) {
+		options = append(options, gojq.WithIterFunction(name, 0, 0, gojqFunction(functions[name])))
+	}
+	code, err := gojq.Compile(query, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gojqProgram{code, values, inputs}, nil
+}
+
+// exactIntegers implements exactIntegerEngine: gojq holds integers that don't
+// fit in a float64 as *big.Int.
+func (gojqEngine) exactIntegers() bool {
+	return true
+}
+
+// gojqInputIter is a gojq.Iter over Inputs.
+type gojqInputIter struct {
+	inputs Inputs
+}
+
+func (i *gojqInputIter) Next() (interface{}, bool) {
+	if i.inputs == nil {
+		return nil, false
+	}
+
+	data, err := i.inputs.Next()
+	if err == io.EOF {
+		return nil, false
+	}
+	if err != nil {
+		return err, true
+	}
+
+	var value interface{}
+	if err := unmarshalGojqValue(data, &value); err != nil {
+		return err, true
+	}
+	return value, true
+}
+
+// gojqFunction adapts a Function to the iterator functions of gojq.
+func gojqFunction(fn Function) func(interface{}, []interface{}) gojq.Iter {
+	return func(v interface{}, _ []interface{}) gojq.Iter {
+		input, err := marshalGojqValue(v)
+		if err != nil {
+			return gojq.NewIter(err)
+		}
+		output, err := fn([]byte(input))
+		if err != nil {
+			return gojq.NewIter(err)
+		}
+		results, err := functionResults(output)
+		if err != nil {
+			return gojq.NewIter(err)
+		}
+
+		values := make([]interface{}, 0, len(results))
+		for _, result := range results {
+			var value interface{}
+			if err := unmarshalGojqValue(result, &value); err != nil {
+				return gojq.NewIter(err)
+			}
+			// Unlike inputs and variables, gojq doesn't normalize the results
+			// of functions.
+			values = append(values, normalizeGojqNumbers(value))
+		}
+		return gojq.NewIter(values...)
+	}
+}
+
+// normalizeGojqNumbers replaces the json.Numbers in v with the number types
+// that gojq functions are allowed to return.
+func normalizeGojqNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil && int64(int(i)) == i {
+			return int(i)
+		}
+		if i, ok := new(big.Int).SetString(v.String(), 10); ok {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i, element := range v {
+			v[i] = normalizeGojqNumbers(element)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = normalizeGojqNumbers(value)
+		}
+	}
+	return v
+}
+
+// gojqVariables converts program arguments into the variable names and values
+// expected by gojq.
+//
+// Like libjq, args are either an object mapping names to values or an array
+// of objects with "name" and "value" keys.
+func gojqVariables(args interface{}) ([]string, []interface{}, error) {
+	var names []string
+	var values []interface{}
+	switch args := args.(type) {
+	case map[string]interface{}:
+		for name, value := range args {
+			names = append(names, "$"+name)
+			values = append(values, value)
+		}
+	case []interface{}:
+		for _, arg := range args {
+			obj, ok := arg.(map[string]interface{})
+			if !ok {
+				return nil, nil, &ErrWrongType{fmt.Sprintf("arg was not the required type, got %s, expected: object", gojqKindName(arg))}
+			}
+			name, ok := obj["name"].(string)
+			if !ok {
+				return nil, nil, &ErrWrongType{fmt.Sprintf("arg name was not the required type, got %s, expected: string", gojqKindName(obj["name"]))}
+			}
+			names = append(names, "$"+name)
+			values = append(values, obj["value"])
+		}
+	default:
+		return nil, nil, &ErrWrongType{fmt.Sprintf("args was not the required type, got %s, expected: object or array", gojqKindName(args))}
+	}
+	return names, values, nil
+}
+
+// Run implements Program.
+func (p *gojqProgram) Run(ctx context.Context, input []byte, raw bool, fn func(output string) error) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+
+	var inputValue interface{}
+	if err := unmarshalGojqValue(input, &inputValue); err != nil {
+		return err
+	}
+
+	// gojq normalizes the variable values in place, so each run receives its
+	// own copy of the slice.
+	values := append([]interface{}(nil), p.values...)
+	var docIndex interface{}
+	if p.inputs != nil && p.inputs.Index() >= 0 {
+		docIndex = p.inputs.Index()
+	}
+	values = append(values, docIndex)
+	iter := p.code.RunWithContext(ctx, inputValue, values...)
+	for {
+		result, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := result.(error); ok {
+			if err, ok := err.(gojqHaltError); ok && err.IsHaltError() {
+				return newGojqHaltError(err)
+			}
+			if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+				return contextError(err)
+			}
+			return err
+		}
+
+		str, ok := result.(string)
+		if !ok || !raw {
+			var err error
+			if str, err = marshalGojqValue(result); err != nil {
+				return err
+			}
+		}
+		if err := fn(str); err == Stop {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close implements Program.
+func (p *gojqProgram) Close() {}
+
+// gojqHaltError is implemented by the errors of gojq's halt and halt_error.
+type gojqHaltError interface {
+	IsHaltError() bool
+	ExitCode() int
+	Value() interface{}
+}
+
+// newGojqHaltError returns the *HaltError for err, with the message that
+// libjq gives it.
+func newGojqHaltError(err gojqHaltError) error {
+	haltErr := &HaltError{ExitCode: err.ExitCode()}
+	switch value := err.Value().(type) {
+	case nil:
+	case string:
+		haltErr.Message = value
+	default:
+		msg, err := marshalGojqValue(value)
+		if err != nil {
+			return err
+		}
+		haltErr.Message = msg + "\n"
+	}
+	return haltErr
+}
+
+// unmarshalGojqValue decodes JSON bytes into the types gojq expects, keeping
+// large integers intact by decoding numbers as json.Number.
+func unmarshalGojqValue(data []byte, v *interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// marshalGojqValue encodes a value produced by gojq as compact JSON, the same
+// way libjq would dump it.
+func marshalGojqValue(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(finiteGojqValue(v)); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// finiteGojqValue replaces the floats that cannot be represented in JSON with
+// the values libjq prints in their place.
+func finiteGojqValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return nil
+		case math.IsInf(v, 1):
+			return math.MaxFloat64
+		case math.IsInf(v, -1):
+			return -math.MaxFloat64
+		}
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, elem := range v {
+			array[i] = finiteGojqValue(elem)
+		}
+		return array
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			obj[key] = finiteGojqValue(elem)
+		}
+		return obj
+	}
+	return v
+}
+
+func gojqKindName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+func init() {
+	Register("gojq", gojqEngine{})
+}
@@ -0,0 +1,284 @@
+// Package jq implements engines for compiling and executing jq programs.
+//
+// The libjq engine uses C bindings to libjq 1.6-rc2+ and is only available
+// when built with cgo. It can be excluded with the nolibjq build tag.
+// The gojq engine is implemented in pure Go and is always available.
+package jq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Engine compiles jq programs.
+type Engine interface {
+	// Compile compiles a jq program with the provided args.
+	//
+	// The args parameter is expected to be JSON bytes.
+	// If the args parameter is not an array or an object, then ErrWrongType
+	// is returned.
+	//
+	// The program's input and inputs builtins read from inputs, which may be
+	// nil if there are none.
+	//
+	// Close must be called to free the Program once it is no longer needed.
+	Compile(program string, args []byte, inputs Inputs) (Program, error)
+}
+
+// Inputs is a source of values for the input and inputs builtins.
+//
+// Inputs also describe where the value a program is run against came from:
+// the input_filename builtin returns Filename, and the $__doc_index variable
+// is bound to Index when a run starts. Both are null if there are no Inputs.
+//
+// Its methods are called from the goroutine running the Program, so they
+// must be safe for concurrent use only if the Program is run concurrently.
+type Inputs interface {
+	// Next returns the next value as JSON bytes, or io.EOF if there are no
+	// more values.
+	Next() ([]byte, error)
+
+	// Filename returns the name of the file that the value last returned by
+	// Next was read from, or "" if it wasn't read from a file.
+	Filename() string
+
+	// Index returns the index of the value last returned by Next among the
+	// values of its file, starting at 0, or -1 if Next hasn't returned one.
+	Index() int
+}
+
+// DocIndexVariable is the name of the variable bound to the index of the
+// value a program is run against within its file.
+const DocIndexVariable = "$__doc_index"
+
+// Program is a jq program that has been compiled with a set of arguments.
+//
+// A Program can be run against any number of inputs without being
+// recompiled, which is considerably cheaper than calling Exec for each input.
+//
+// Programs are safe for concurrent use by multiple goroutines, although an
+// engine may serialize concurrent runs of the same Program.
+type Program interface {
+	// Run executes the compiled program with the provided input, calling fn
+	// with each result as soon as the program produces it.
+	//
+	// The input parameter is expected to be JSON bytes.
+	// If fn returns an error, the program is stopped and Run returns that
+	// error, unless it is Stop, in which case Run returns nil. fn must not run
+	// the same Program.
+	// If ctx is done before the program has produced all of its results, Run
+	// stops the program and returns ErrTimeout if the deadline passed, or the
+	// context's error otherwise.
+	Run(ctx context.Context, input []byte, raw bool, fn func(output string) error) error
+
+	// Close frees any resources held by the Program.
+	Close()
+}
+
+var nameToEngine = map[string]Engine{}
+
+// Register maps an engine name to an Engine implementation.
+func Register(name string, engine Engine) {
+	nameToEngine[name] = engine
+}
+
+// ByName is a mapping from dynamically registered engine names to Engine
+// implementations.
+func ByName(name string) (Engine, bool) {
+	engine, ok := nameToEngine[strings.ToLower(name)]
+	return engine, ok
+}
+
+// Names returns the sorted names of every registered Engine.
+func Names() []string {
+	names := make([]string, 0, len(nameToEngine))
+	for name := range nameToEngine {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Function is a builtin implemented in Go. It's given the input of the builtin
+// as JSON bytes and returns its results as a sequence of JSON values, so it
+// may produce any number of them. An error is raised as a jq error.
+type Function func(input []byte) ([]byte, error)
+
+var nameToFunction = map[string]Function{}
+
+// RegisterFunction makes fn available to the programs of every Engine as a
+// builtin named name that takes no arguments. Functions are resolved after
+// the builtins of the engine, so the name must not be one of them.
+func RegisterFunction(name string, fn Function) {
+	nameToFunction[name] = fn
+}
+
+// WithFunctions returns an Engine that compiles programs with engine, making
+// functions available to them as RegisterFunction does, but only to the
+// programs of the returned Engine.
+func WithFunctions(engine Engine, functions map[string]Function) Engine {
+	return functionEngine{engine, functions}
+}
+
+// functionCompiler is implemented by engines that compile programs with a
+// given set of Functions.
+type functionCompiler interface {
+	compileWithFunctions(program string, args []byte, inputs Inputs, functions map[string]Function) (Program, error)
+}
+
+type functionEngine struct {
+	engine    Engine
+	functions map[string]Function
+}
+
+// Compile implements Engine.
+func (e functionEngine) Compile(program string, args []byte, inputs Inputs) (Program, error) {
+	compiler, ok := e.engine.(functionCompiler)
+	if !ok {
+		return nil, fmt.Errorf("engine %T cannot compile programs with functions", e.engine)
+	}
+	functions := make(map[string]Function, len(nameToFunction)+len(e.functions))
+	for name, fn := range nameToFunction {
+		functions[name] = fn
+	}
+	for name, fn := range e.functions {
+		functions[name] = fn
+	}
+	return compiler.compileWithFunctions(program, args, inputs, functions)
+}
+
+// exactIntegers implements exactIntegerEngine.
+func (e functionEngine) exactIntegers() bool {
+	exact, ok := e.engine.(exactIntegerEngine)
+	return ok && exact.exactIntegers()
+}
+
+// functionNames returns the sorted names of functions.
+func functionNames(functions map[string]Function) []string {
+	names := make([]string, 0, len(functions))
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// functionResults splits the output of a Function into its results.
+func functionResults(output []byte) ([][]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	var results [][]byte
+	for {
+		var result json.RawMessage
+		err := decoder.Decode(&result)
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+}
+
+// libraryPaths returns the paths that modules are searched for in, which are
+// those of the jq command line tool: ~/.jq, and lib/jq and lib next to the
+// directory of the executable.
+func libraryPaths() []string {
+	paths := []string{"~/.jq"}
+	if executable, err := os.Executable(); err == nil {
+		origin := filepath.Dir(executable)
+		paths = append(paths, filepath.Join(origin, "..", "lib", "jq"), filepath.Join(origin, "..", "lib"))
+	}
+	return paths
+}
+
+// DefaultEngine returns the name of the Engine that should be used when one
+// hasn't been explicitly chosen: libjq if it was compiled in, otherwise gojq.
+func DefaultEngine() string {
+	if _, ok := ByName("libjq"); ok {
+		return "libjq"
+	}
+	return "gojq"
+}
+
+// Exec compiles a JQ program with the provided args and executes it with the
+// provided input.
+//
+// The args and input parameters are expected to be JSON bytes.
+// If the args parameter is not an array or an object, then ErrWrongType is
+// returned.
+func Exec(ctx context.Context, engine Engine, program string, args, input []byte, raw bool) ([]string, error) {
+	p, err := engine.Compile(program, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	return collect(ctx, p, input, raw)
+}
+
+// collect runs p with the provided input and returns all of its results.
+func collect(ctx context.Context, p Program, input []byte, raw bool) ([]string, error) {
+	results := make([]string, 0)
+	err := p.Run(ctx, input, raw, func(output string) error {
+		results = append(results, output)
+		return nil
+	})
+	return results, err
+}
+
+// Stop is returned by the fn passed to Program.Run to stop the program
+// without producing any more results. It is not returned as an error by Run.
+var Stop = errors.New("stop running the jq program")
+
+// ErrTimeout is returned when a program is stopped because the deadline of
+// its context passed.
+var ErrTimeout = errors.New("jq program timed out")
+
+// contextError returns the error that Run returns when it is stopped by a
+// context that failed with err.
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
+
+// HaltError is returned by Run when the program stops itself with halt or
+// halt_error, for which jq prints Message to stderr and exits with ExitCode.
+type HaltError struct {
+	ExitCode int
+	// Message is the value given to halt_error as is if it's a string, or
+	// as JSON followed by a newline otherwise. It's empty for halt and for
+	// null.
+	Message string
+}
+
+func (e *HaltError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("the program halted with exit code %d", e.ExitCode)
+	}
+	return strings.TrimSuffix(e.Message, "\n")
+}
+
+// stderr is where the debug and stderr builtins write their messages, as the
+// jq command line tool does.
+var stderr io.Writer = os.Stderr
+
+// ErrWrongType is returned from functions when an assertion about the type of
+// a value fails.
+type ErrWrongType struct {
+	message string
+}
+
+func (e *ErrWrongType) Error() string {
+	return e.message
+}
@@ -0,0 +1,637 @@
+package jq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const benchProgram = `.items[] | select(.kind == "Deployment") | {name: .metadata.name, replicas: .spec.replicas}`
+
+var benchInput = []byte(`{"items":[{"kind":"Deployment","metadata":{"name":"web"},"spec":{"replicas":3}},{"kind":"Service","metadata":{"name":"web"}}]}`)
+
+func TestProgramRun(t *testing.T) {
+	var table = []struct {
+		input  string
+		output []string
+	}{
+		{`{"foo":1}`, []string{"1"}},
+		{`{"foo":"bar"}`, []string{`"bar"`}},
+		{`{}`, []string{"null"}},
+	}
+
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		prog, err := engine.Compile(".foo", []byte(`{}`), nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error compiling: %s", name, err)
+		}
+		defer prog.Close()
+
+		for _, tt := range table {
+			t.Run(name+"/"+tt.input, func(t *testing.T) {
+				output, err := collect(context.Background(), prog, []byte(tt.input), false)
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				if !reflect.DeepEqual(output, tt.output) {
+					t.Errorf("unexpected output: %q instead of %q", output, tt.output)
+				}
+			})
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		if _, err := engine.Compile(".foo |", []byte(`{}`), nil); err == nil {
+			t.Errorf("%s: expected an error compiling an invalid program", name)
+		}
+		if _, err := engine.Compile(".", []byte(`"args"`), nil); err == nil {
+			t.Errorf("%s: expected an error compiling with non-object args", name)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	// The first case never produces a result and the second never stops
+	// producing them.
+	const program = `if . == "last" then last(range(1e12)) elif . == "repeat" then repeat(.) else . end`
+
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		prog, err := engine.Compile(program, []byte(`{}`), nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error compiling: %s", name, err)
+		}
+		defer prog.Close()
+
+		for _, input := range []string{`"last"`, `"repeat"`} {
+			t.Run(name+"/"+input, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				if _, err := collect(ctx, prog, []byte(input), false); err != ErrTimeout {
+					t.Errorf("expected ErrTimeout, got %v", err)
+				}
+
+				ctx, cancel = context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				if _, err := collect(ctx, prog, []byte(input), false); err != context.Canceled {
+					t.Errorf("expected context.Canceled, got %v", err)
+				}
+
+				// The program must still be usable once it has been stopped.
+				output, err := collect(context.Background(), prog, []byte(`1`), false)
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				if expected := []string{"1"}; !reflect.DeepEqual(output, expected) {
+					t.Errorf("unexpected output: %q instead of %q", output, expected)
+				}
+			})
+		}
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		prog, err := engine.Compile(`range(1e12), error("unreachable")`, []byte(`{}`), nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error compiling: %s", name, err)
+		}
+		defer prog.Close()
+
+		t.Run(name, func(t *testing.T) {
+			for _, limit := range []int{1, 3} {
+				var output []string
+				err := prog.Run(context.Background(), []byte(`null`), false, func(result string) error {
+					output = append(output, result)
+					if len(output) == limit {
+						return Stop
+					}
+					return nil
+				})
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				if expected := []string{"0", "1", "2"}[:limit]; !reflect.DeepEqual(output, expected) {
+					t.Errorf("unexpected output: %q instead of %q", output, expected)
+				}
+			}
+
+			// Other errors stop the program and are returned as is.
+			fnErr := errors.New("write failed")
+			err := prog.Run(context.Background(), []byte(`null`), false, func(string) error {
+				return fnErr
+			})
+			if err != fnErr {
+				t.Errorf("expected %v, got %v", fnErr, err)
+			}
+		})
+	}
+}
+
+func TestInputs(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			inputs := &testInputs{values: []string{`1`, `{"two":2}`, `3`}}
+			prog, err := engine.Compile(`[., input, [inputs]]`, []byte(`{}`), inputs)
+			if err != nil {
+				t.Fatalf("unexpected error compiling: %s", err)
+			}
+			defer prog.Close()
+
+			output, err := collect(context.Background(), prog, []byte(`0`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if expected := []string{`[0,1,[{"two":2},3]]`}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+
+			// Once the inputs are exhausted, input is an error.
+			if _, err := collect(context.Background(), prog, []byte(`0`), false); err == nil {
+				t.Error("expected an error once there are no more inputs")
+			}
+
+			// Errors reading inputs are returned by the program.
+			inputs.err = errors.New("failed to read input")
+			prog, err = engine.Compile(`[inputs]`, []byte(`{}`), inputs)
+			if err != nil {
+				t.Fatalf("unexpected error compiling: %s", err)
+			}
+			defer prog.Close()
+			if _, err := collect(context.Background(), prog, []byte(`null`), false); err == nil || !strings.Contains(err.Error(), inputs.err.Error()) {
+				t.Errorf("expected %q, got %v", inputs.err, err)
+			}
+		})
+	}
+}
+
+func TestInputFilename(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			inputs := &testInputs{values: []string{`1`, `2`}, filename: "a.yaml"}
+			prog, err := engine.Compile(`[input_filename, `+DocIndexVariable+`, (input | [., input_filename, `+DocIndexVariable+`])]`, []byte(`{}`), inputs)
+			if err != nil {
+				t.Fatalf("unexpected error compiling: %s", err)
+			}
+			defer prog.Close()
+
+			// $__doc_index is bound when the run starts, so it is unchanged by
+			// input.
+			output, err := collect(context.Background(), prog, []byte(`0`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if expected := []string{`["a.yaml",null,[1,"a.yaml",null]]`}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+
+			output, err = collect(context.Background(), prog, []byte(`0`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if expected := []string{`["a.yaml",0,[2,"a.yaml",0]]`}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+
+			// Without Inputs, both are null.
+			output, err = Exec(context.Background(), engine, `[input_filename, `+DocIndexVariable+`]`, []byte(`{}`), []byte(`0`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if expected := []string{`[null,null]`}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+		})
+	}
+}
+
+func TestMessageBuiltins(t *testing.T) {
+	var table = []struct {
+		program string
+		input   string
+		output  []string
+		stderr  string
+		err     *HaltError
+	}{
+		{`debug`, `{"a":[1,"x"]}`, []string{`{"a":[1,"x"]}`}, "[\"DEBUG:\",{\"a\":[1,\"x\"]}]\n", nil},
+		{`stderr | .[0]`, `[1,"a"]`, []string{`1`}, `[1,"a"]`, nil},
+		{`1, ("bye\n" | halt_error), 2`, `null`, []string{`1`}, "", &HaltError{5, "bye\n"}},
+		{`halt_error(3)`, `{"a":1}`, []string{}, "", &HaltError{3, "{\"a\":1}\n"}},
+		{`1, halt, 2`, `null`, []string{`1`}, "", &HaltError{0, ""}},
+	}
+
+	defer func(w io.Writer) { stderr = w }(stderr)
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		for _, tt := range table {
+			t.Run(name+"/"+tt.program, func(t *testing.T) {
+				prog, err := engine.Compile(tt.program, []byte(`{}`), nil)
+				if err != nil {
+					t.Fatalf("unexpected error compiling: %s", err)
+				}
+				defer prog.Close()
+
+				// Programs run again as usual after they halt.
+				for i := 0; i < 2; i++ {
+					var buf strings.Builder
+					stderr = &buf
+					output, err := collect(context.Background(), prog, []byte(tt.input), false)
+					var haltErr *HaltError
+					if tt.err == nil && err != nil || tt.err != nil && (!errors.As(err, &haltErr) || *haltErr != *tt.err) {
+						t.Errorf("unexpected error: %v instead of %v", err, tt.err)
+					}
+					if !reflect.DeepEqual(output, tt.output) {
+						t.Errorf("unexpected output: %q instead of %q", output, tt.output)
+					}
+					if buf.String() != tt.stderr {
+						t.Errorf("unexpected messages: %q instead of %q", buf.String(), tt.stderr)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	RegisterFunction("test_describe", func(input []byte) ([]byte, error) {
+		if string(input) == "null" {
+			return nil, errors.New("test_describe failed")
+		}
+		return []byte(`{"input":` + strconv.Quote(string(input)) + `,"big":12345678901234567890}`), nil
+	})
+
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			output, err := Exec(context.Background(), engine, `test_describe | .input, .big`, []byte(`{}`), []byte(`{"a":[1,"b"]}`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			// libjq cannot represent integers this large exactly.
+			big := map[string]string{"gojq": "12345678901234567890", "libjq": "12345678901234567000"}[name]
+			if expected := []string{`"{\"a\":[1,\"b\"]}"`, big}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+
+			_, err = Exec(context.Background(), engine, `test_describe`, []byte(`{}`), []byte(`null`), false)
+			if err == nil || !strings.Contains(err.Error(), "test_describe failed") {
+				t.Errorf("expected the function's error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWithFunctions(t *testing.T) {
+	functions := map[string]Function{
+		"test_split": func(input []byte) ([]byte, error) {
+			var s string
+			if err := json.Unmarshal(input, &s); err != nil {
+				return nil, err
+			}
+			var output []byte
+			for _, field := range strings.Fields(s) {
+				output = append(output, strconv.Quote(field)+"\n"...)
+			}
+			return output, nil
+		},
+	}
+
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			output, err := Exec(context.Background(), WithFunctions(engine, functions), `[.[] | test_split]`, []byte(`{}`), []byte(`["a b", "", "c"]`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if expected := []string{`["a","b","c"]`}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+
+			// The functions are only available to the programs of the
+			// returned Engine.
+			if _, err := Exec(context.Background(), engine, `"a" | test_split`, []byte(`{}`), []byte(`null`), false); err == nil {
+				t.Error("expected an error from an engine without the functions")
+			}
+		})
+	}
+}
+
+func TestModuleDirectives(t *testing.T) {
+	home, err := ioutil.TempDir("", "faq-jq-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	if err := os.Mkdir(filepath.Join(home, ".jq"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, module := range map[string]string{"lib.jq": "def double: . * 2;\n", "inc.jq": "def triple: . * 3;\n"} {
+		if err := ioutil.WriteFile(filepath.Join(home, ".jq", name), []byte(module), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", home)
+
+	functions := map[string]Function{
+		"test_negate": func(input []byte) ([]byte, error) {
+			return append([]byte("-"), input...), nil
+		},
+	}
+	program := "# a comment; with a semicolon\n" +
+		`module {"name": "test;module"};` + "\n" +
+		`include "inc"; import "lib" as lib;` + "\n" +
+		`[lib::double, triple, test_negate, input_filename, $__doc_index]`
+
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			prog, err := WithFunctions(engine, functions).Compile(program, []byte(`{}`), &testInputs{filename: "a.json", index: 1})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer prog.Close()
+			output, err := collect(context.Background(), prog, []byte(`2`), false)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if expected := []string{`[4,6,-2,"a.json",0]`}; !reflect.DeepEqual(output, expected) {
+				t.Errorf("unexpected output: %q instead of %q", output, expected)
+			}
+		})
+	}
+}
+
+func TestDirectivesEnd(t *testing.T) {
+	var table = []struct {
+		program string
+		end     int
+	}{
+		{`.a`, 0},
+		{`import "a" as a; .`, 16},
+		{"# import\ninclude \"a;b\"; import \"c\" as $c; $c", 41},
+		{`module {"a": 1}; def f: .; f`, 16},
+		{`imported`, 0},
+		{`import "a" as a`, 0},
+	}
+
+	for _, tt := range table {
+		if end := directivesEnd(tt.program); end != tt.end {
+			t.Errorf("%q: directivesEnd returned %d instead of %d", tt.program, end, tt.end)
+		}
+	}
+}
+
+// testInputs are Inputs that return values, followed by err or io.EOF.
+type testInputs struct {
+	values   []string
+	err      error
+	filename string
+	index    int
+}
+
+func (i *testInputs) Next() ([]byte, error) {
+	if len(i.values) == 0 {
+		if i.err != nil {
+			return nil, i.err
+		}
+		return nil, io.EOF
+	}
+	value := i.values[0]
+	i.values = i.values[1:]
+	i.index++
+	return []byte(value), nil
+}
+
+func (i *testInputs) Filename() string {
+	return i.filename
+}
+
+func (i *testInputs) Index() int {
+	return i.index - 1
+}
+
+func TestLosslessNumbers(t *testing.T) {
+	var table = []struct {
+		program string
+		input   string
+		raw     bool
+		output  string
+	}{
+		{`.`, `[9007199254740993,1.50,-2.0e3,0.1]`, false, `[9007199254740993,1.50,-2.0e3,0.1]`},
+		{`[.[0] + 1, .[1] * 2]`, `[1.50,1.50]`, false, `[2.5,3]`},
+		{`[., $a, input]`, `1.50`, false, `[1.50,2.250,3.0e0]`},
+		{`tostring`, `1.50`, true, `1.5`},
+		{`"1.5"`, `1.50`, false, `"1.5"`},
+		// Equal numbers written differently are written as usual.
+		{`.`, `[1.0,1.00]`, false, `[1,1]`},
+		// Numbers with the value of a number of the input are written the
+		// way it was written, whether or not the program computed them.
+		{`[.a, .b - 1, .b / 2, .b, .b + 1]`, `{"a":1.0,"b":2.0}`, false, `[1.0,1.0,1.0,2.0,3]`},
+		{`limit(1; repeat(.))`, `1.50`, false, `1.50`},
+		// The program compares the numbers as the engine holds them.
+		{`select(. == 1.5)`, `1.50`, false, `1.50`},
+		{`if . > 1.5 then "big" else . end`, `1.50`, false, `1.50`},
+		{`[., (input | . == 3)]`, `1.50`, false, `[1.50,true]`},
+	}
+
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		for _, tt := range table {
+			t.Run(name+"/"+tt.program, func(t *testing.T) {
+				inputs := &testInputs{values: []string{`3.0e0`}}
+				prog, err := LosslessNumbers(engine).Compile(tt.program, []byte(`{"a":2.250}`), inputs)
+				if err != nil {
+					t.Fatalf("unexpected error compiling: %s", err)
+				}
+				defer prog.Close()
+
+				output, err := collect(context.Background(), prog, []byte(tt.input), tt.raw)
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				if expected := []string{tt.output}; !reflect.DeepEqual(output, expected) {
+					t.Errorf("unexpected output: %q instead of %q", output, expected)
+				}
+				// The program is run once, reading each value once.
+				if strings.Contains(tt.program, "input") && inputs.index != 1 {
+					t.Errorf("unexpected number of values read: %d", inputs.index)
+				}
+			})
+		}
+	}
+}
+
+func TestReadsInputs(t *testing.T) {
+	var table = []struct {
+		program string
+		reads   bool
+	}{
+		{`[., input]`, true},
+		{`reduce inputs as $x (0; . + $x)`, true},
+		{`include "lib"; f`, true},
+		{`.input, .inputs`, false},
+		{`"input \(.x) inputs" # input`, false},
+		{`"\(input)"`, true},
+		{`$input | @input`, false},
+	}
+
+	for _, tt := range table {
+		if reads := ReadsInputs(tt.program); reads != tt.reads {
+			t.Errorf("%s: ReadsInputs returned %t", tt.program, reads)
+		}
+	}
+}
+
+func TestConcurrentExec(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 4; j++ {
+						input := []byte(strconv.Itoa(i*100 + j))
+						output, err := Exec(context.Background(), engine, ". + $n", []byte(`{"n":1}`), input, false)
+						if err != nil {
+							t.Errorf("unexpected error: %s", err)
+							return
+						}
+						if expected := []string{strconv.Itoa(i*100 + j + 1)}; !reflect.DeepEqual(output, expected) {
+							t.Errorf("unexpected output: %q instead of %q", output, expected)
+						}
+
+						// Compilation errors are reported via callback and
+						// must not leak into other goroutines' calls.
+						if _, err := Exec(context.Background(), engine, ".foo |", []byte(`{}`), input, false); err == nil {
+							t.Error("expected an error compiling an invalid program")
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestConcurrentProgramRun(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			prog, err := engine.Compile("{value: ., doubled: (. * 2)}", []byte(`{}`), nil)
+			if err != nil {
+				t.Fatalf("unexpected error compiling: %s", err)
+			}
+			defer prog.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 32; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						n := i*100 + j
+						output, err := collect(context.Background(), prog, []byte(strconv.Itoa(n)), false)
+						if err != nil {
+							t.Errorf("unexpected error: %s", err)
+							return
+						}
+						if len(output) != 1 || !strings.Contains(output[0], `"doubled":`+strconv.Itoa(n*2)) {
+							t.Errorf("unexpected output for %d: %q", n, output)
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestConcurrentLosslessRun(t *testing.T) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		t.Run(name, func(t *testing.T) {
+			prog, err := LosslessNumbers(engine).Compile("[., . * 2]", []byte(`{}`), nil)
+			if err != nil {
+				t.Fatalf("unexpected error compiling: %s", err)
+			}
+			defer prog.Close()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 20; j++ {
+						n := i*100 + j
+						output, err := collect(context.Background(), prog, []byte(strconv.Itoa(n)+".50"), false)
+						if err != nil {
+							t.Errorf("unexpected error: %s", err)
+							return
+						}
+						if expected := []string{fmt.Sprintf(`[%d.50,%d]`, n, n*2+1)}; !reflect.DeepEqual(output, expected) {
+							t.Errorf("unexpected output: %q instead of %q", output, expected)
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+// BenchmarkExec measures compiling the program for every input.
+func BenchmarkExec(b *testing.B) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Exec(context.Background(), engine, benchProgram, []byte(`{}`), benchInput, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkProgramRun measures compiling the program once and running it
+// against every input.
+func BenchmarkProgramRun(b *testing.B) {
+	for _, name := range Names() {
+		engine, _ := ByName(name)
+		b.Run(name, func(b *testing.B) {
+			prog, err := engine.Compile(benchProgram, []byte(`{}`), nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer prog.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := collect(context.Background(), prog, benchInput, false); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package jq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LosslessNumbers returns an Engine that compiles programs with engine and
+// writes the numbers of their results the way they were written in their
+// inputs.
+//
+// Engines hold numbers as float64, except gojq which also holds integers of
+// any size exactly, so integers beyond 2^53 lose precision and decimals lose
+// their formatting, such as trailing zeros. Each run keeps a table of the
+// text of the numbers of the value it is run against, of the values read by
+// its input builtins and of its args, by the value the engine holds them as.
+// A number of a result with the value of one of them is written the way that
+// number was written, unless equal numbers were written differently, so the
+// program itself sees and compares the numbers as the engine holds them.
+//
+// Runs of programs that call the input builtins are serialized, so that the
+// values they read are added to the table of the run that read them.
+func LosslessNumbers(engine Engine) Engine {
+	return losslessEngine{engine}
+}
+
+// exactIntegerEngine is implemented by engines that hold integers of any size
+// exactly, whose results are never rewritten with integers.
+type exactIntegerEngine interface {
+	exactIntegers() bool
+}
+
+type losslessEngine struct {
+	engine Engine
+}
+
+// Compile implements Engine.
+func (e losslessEngine) Compile(program string, args []byte, inputs Inputs) (Program, error) {
+	exact, _ := e.engine.(exactIntegerEngine)
+	p := &losslessProgram{
+		exactIntegers: exact != nil && exact.exactIntegers(),
+		args:          numberLiterals{},
+		inputs:        inputs,
+		readsInputs:   inputs != nil && ReadsInputs(program),
+	}
+	p.args.add(args)
+
+	if inputs != nil {
+		inputs = &losslessInputs{p}
+	}
+	prog, err := e.engine.Compile(program, args, inputs)
+	if err != nil {
+		return nil, err
+	}
+	p.Program = prog
+	return p, nil
+}
+
+// losslessProgram restores the numbers of the results of a Program.
+type losslessProgram struct {
+	Program
+	exactIntegers bool
+	args          numberLiterals
+
+	// inputs are read through losslessInputs, which add the numbers of the
+	// values they read to current. If readsInputs is set, running
+	// serializes runs.
+	inputs      Inputs
+	readsInputs bool
+	running     sync.Mutex
+	current     *losslessRun
+}
+
+// losslessRun is the table of the numbers of a run of a losslessProgram.
+type losslessRun struct {
+	program *losslessProgram
+
+	// mu guards numbers, which the inputs of the run add to.
+	mu      sync.Mutex
+	numbers numberLiterals
+}
+
+// Run implements Program.
+func (p *losslessProgram) Run(ctx context.Context, input []byte, raw bool, fn func(output string) error) error {
+	if p.readsInputs {
+		p.running.Lock()
+		defer p.running.Unlock()
+	}
+
+	run := &losslessRun{program: p, numbers: numberLiterals{}}
+	for value, text := range p.args {
+		run.numbers[value] = text
+	}
+	run.numbers.add(input)
+	if p.readsInputs {
+		p.current = run
+		defer func() { p.current = nil }()
+	} else if !run.restorable() {
+		return p.Program.Run(ctx, input, raw, fn)
+	}
+
+	// Strings are written raw only once their numbers have been restored,
+	// since their contents aren't JSON.
+	return p.Program.Run(ctx, input, false, func(output string) error {
+		output = run.restore(output)
+		if raw {
+			var s string
+			if err := json.Unmarshal([]byte(output), &s); err == nil {
+				output = s
+			}
+		}
+		return fn(output)
+	})
+}
+
+// restorable reports whether the run has numbers that the engine would write
+// differently than they were written.
+func (r *losslessRun) restorable() bool {
+	for value, text := range r.numbers {
+		if r.program.rewrites(value, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrites reports whether text, the text of a number with value, is written
+// differently by the engine.
+func (p *losslessProgram) rewrites(value float64, text string) bool {
+	// Integers are already exact.
+	if text == "" || p.exactIntegers && !strings.ContainsAny(text, ".eE") {
+		return false
+	}
+	formatted, err := json.Marshal(value)
+	return err != nil || string(formatted) != text
+}
+
+// restore replaces the numbers of the JSON value output that have the value
+// of a number of the table of the run with the text that number was written
+// as.
+func (r *losslessRun) restore(output string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var buf bytes.Buffer
+	last := 0
+	scanJSONNumbers([]byte(output), func(start, end int) {
+		f, err := strconv.ParseFloat(output[start:end], 64)
+		if err != nil {
+			return
+		}
+		text, ok := r.numbers[f]
+		if !ok || !r.program.rewrites(f, text) || text == output[start:end] {
+			return
+		}
+		buf.WriteString(output[last:start])
+		buf.WriteString(text)
+		last = end
+	})
+	if last == 0 {
+		return output
+	}
+	buf.WriteString(output[last:])
+	return buf.String()
+}
+
+// losslessInputs are the inputs of a losslessProgram, which add the numbers
+// of the values they read to the table of the current run.
+type losslessInputs struct {
+	program *losslessProgram
+}
+
+func (i *losslessInputs) Next() ([]byte, error) {
+	data, err := i.program.inputs.Next()
+	if err != nil {
+		return nil, err
+	}
+	if run := i.program.current; run != nil {
+		run.mu.Lock()
+		run.numbers.add(data)
+		run.mu.Unlock()
+	}
+	return data, nil
+}
+
+func (i *losslessInputs) Filename() string {
+	return i.program.inputs.Filename()
+}
+
+func (i *losslessInputs) Index() int {
+	return i.program.inputs.Index()
+}
+
+// numberLiterals maps the value of the numbers of JSON values to their text.
+// Values that were written in more than one way map to "".
+type numberLiterals map[float64]string
+
+// add records the numbers of the JSON value data.
+func (l numberLiterals) add(data []byte) {
+	scanJSONNumbers(data, func(start, end int) {
+		text := string(data[start:end])
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return
+		}
+		if previous, ok := l[f]; ok && previous != text {
+			text = ""
+		}
+		l[f] = text
+	})
+}
+
+// scanJSONNumbers calls fn with the offsets of each number of the JSON value
+// data, skipping the contents of strings.
+func scanJSONNumbers(data []byte, fn func(start, end int)) {
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; {
+		case c == '"':
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+		case c == '-' || ('0' <= c && c <= '9'):
+			start := i
+			for i+1 < len(data) && isJSONNumberByte(data[i+1]) {
+				i++
+			}
+			fn(start, i+1)
+		}
+	}
+}
+
+func isJSONNumberByte(c byte) bool {
+	switch c {
+	case '+', '-', '.', 'e', 'E':
+		return true
+	}
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,142 @@
+package jq
+
+// programNames returns the names that program refers to outside of its
+// strings and comments: the identifiers of the functions and keywords it
+// uses, including object keys written without quotes, and its variables with
+// their $. The fields of .foo and the formats of @foo aren't included.
+func programNames(program string) map[string]bool {
+	names := map[string]bool{}
+	scanProgram(program, 0, false, names)
+	return names
+}
+
+// ReadsInputs reports whether program may call the input or inputs builtins.
+// Programs that import modules are assumed to, since their functions may.
+func ReadsInputs(program string) bool {
+	names := programNames(program)
+	return names["input"] || names["inputs"] || names["import"] || names["include"]
+}
+
+// directivesEnd returns the offset just after the module, import and include
+// directives that program starts with, which must come before anything else.
+func directivesEnd(program string) int {
+	end := 0
+	for i := 0; ; {
+		// Skip whitespace and comments.
+		for i < len(program) && (program[i] == ' ' || program[i] == '\t' || program[i] == '\r' || program[i] == '\n' || program[i] == '#') {
+			if program[i] == '#' {
+				for i < len(program) && program[i] != '\n' {
+					i++
+				}
+				continue
+			}
+			i++
+		}
+		start := i
+		for i < len(program) && isNameByte(program[i]) {
+			i++
+		}
+		switch program[start:i] {
+		case "module", "import", "include":
+		default:
+			return end
+		}
+
+		// A directive ends with a semicolon, which its paths and metadata may
+		// hold in strings.
+		for i < len(program) && program[i] != ';' {
+			if program[i] == '"' {
+				i = scanString(program, i+1, map[string]bool{})
+			} else {
+				i++
+			}
+		}
+		if i == len(program) {
+			return end
+		}
+		i++
+		end = i
+	}
+}
+
+// scanProgram adds the names of program from offset i to names, and returns
+// the offset it stopped at. Inside an interpolation, it stops at the
+// parenthesis closing it.
+func scanProgram(program string, i int, interpolation bool, names map[string]bool) int {
+	depth := 0
+	for i < len(program) {
+		switch c := program[i]; {
+		case c == '#':
+			for i < len(program) && program[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			i = scanString(program, i+1, names)
+		case c == '(':
+			depth++
+			i++
+		case c == ')':
+			if interpolation && depth == 0 {
+				return i + 1
+			}
+			depth--
+			i++
+		case c == '.' || c == '@':
+			// Fields and formats are skipped along with their name.
+			i++
+			for i < len(program) && isNameByte(program[i]) {
+				i++
+			}
+		case c == '$' || isNameStart(c):
+			start := i
+			for i++; i < len(program); i++ {
+				// The names of modules are part of the names of their
+				// functions, such as lib::f.
+				if program[i] == ':' && i+2 < len(program) && program[i+1] == ':' && isNameStart(program[i+2]) {
+					i++
+				} else if !isNameByte(program[i]) {
+					break
+				}
+			}
+			names[program[start:i]] = true
+		case '0' <= c && c <= '9':
+			// The exponent of a number isn't a name.
+			for i < len(program) && (isNameByte(program[i]) || program[i] == '.' ||
+				(program[i] == '+' || program[i] == '-') && (program[i-1] == 'e' || program[i-1] == 'E')) {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// scanString skips the string of program starting at offset i, just after its
+// opening quote, adding the names of its interpolations to names. It returns
+// the offset just after its closing quote.
+func scanString(program string, i int, names map[string]bool) int {
+	for i < len(program) {
+		switch program[i] {
+		case '"':
+			return i + 1
+		case '\\':
+			if i+1 < len(program) && program[i+1] == '(' {
+				i = scanProgram(program, i+2, true, names)
+				continue
+			}
+			i += 2
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isNameByte(c byte) bool {
+	return isNameStart(c) || '0' <= c && c <= '9'
+}
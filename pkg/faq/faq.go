@@ -0,0 +1,579 @@
+// Package faq runs jq programs against documents in any of the formats
+// supported by objconv.
+//
+// It is the library behind the faq command line tool and allows Go programs
+// to query structured documents without shelling out to the faq binary.
+package faq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	internalfaq "github.com/jzelinskie/faq/internal/faq"
+	"github.com/jzelinskie/faq/internal/jq"
+	"github.com/jzelinskie/faq/pkg/objconv"
+)
+
+// Input is a document, or a stream of documents, to run a program against.
+type Input struct {
+	// Name identifies the input in errors. When the input format is "auto",
+	// its extension is also used to detect the format, so it is typically
+	// the path of the file the Reader was opened from.
+	Name string
+
+	// Reader provides the contents of the input. It is not closed by the
+	// Runner.
+	Reader io.Reader
+}
+
+// Arguments are the variables made available to a program.
+type Arguments struct {
+	// Args are strings available as $ARGS.positional.
+	Args []string
+	// JSONArgs are JSON values appended to $ARGS.positional after Args.
+	JSONArgs []interface{}
+	// Kwargs are strings available as $name and as $ARGS.named.
+	Kwargs map[string]string
+	// JSONKwargs are JSON values available as $name and as $ARGS.named.
+	JSONKwargs map[string]interface{}
+}
+
+// Runner runs a jq program against inputs.
+//
+// A Runner holds only configuration, so it may be reused and shared by
+// multiple goroutines.
+type Runner struct {
+	// Program is the jq program to run. If empty, the identity program "." is
+	// used.
+	Program string
+
+	// Arguments are the variables made available to the program.
+	Arguments Arguments
+
+	// Engine is the name of the jq engine that executes the program. If
+	// empty, DefaultEngine is used. The libjq engine keeps the order of the
+	// keys of objects, while gojq sorts them.
+	Engine string
+
+	// LosslessNumbers writes the numbers of results that have the value of a
+	// number of the input they came from, of the values read by the input
+	// builtins or of Arguments exactly as that number was written, unless
+	// equal numbers were written differently. Otherwise numbers are written
+	// the way the engine holds them, which loses the precision of integers
+	// beyond 2^53 with libjq and the formatting of decimals, such as the
+	// trailing zero of 1.50, with either engine.
+	LosslessNumbers bool
+
+	// InputFormat is the name of the objconv encoding the inputs are in. If
+	// empty or "auto", the format of each input is detected.
+	InputFormat string
+
+	// OutputFormat is the name of the objconv encoding the results are
+	// written in. If empty or "auto", the input format is used, falling back
+	// to the format detected from the first input and then to JSON.
+	OutputFormat string
+
+	// CSV holds the options of the csv and tsv formats, which inputs are
+	// decoded and results encoded with. Its Delimiter only applies to csv,
+	// since tsv is always delimited by tabs.
+	CSV objconv.CSVEncoding
+
+	// CBORDiagnostic writes results in the diagnostic notation of CBOR
+	// rather than in its binary encoding when the output format is cbor.
+	// Results written in place are always binary.
+	CBORDiagnostic bool
+
+	// NullInput runs the program once with null as its input. The values of
+	// the inputs are only read by the program's input and inputs builtins.
+	NullInput bool
+
+	// Slurp runs the program once with an array of every input value.
+	Slurp bool
+
+	// RawInput reads the inputs as text rather than in InputFormat, like jq's
+	// --raw-input: each line is a string value, or with Slurp, the program
+	// is run once with all of the inputs as a single string.
+	RawInput bool
+
+	// Stream runs the program against the events of jq's --stream mode,
+	// [path, leaf] and [path], rather than against whole input values. JSON
+	// inputs are streamed without ever being held in memory whole.
+	Stream bool
+
+	// Raw writes string results directly rather than as encoded strings.
+	Raw bool
+
+	// Pretty pretty-prints results if the output format supports it.
+	Pretty bool
+
+	// Color colorizes results if the output format supports it.
+	Color bool
+
+	// Edit writes the results of each input that is in the output format as
+	// edits of the input if the format supports it, as YAML and TOML do.
+	// Comments and formatting are kept wherever values are unchanged. Inputs
+	// are then processed one at a time, so the program's input builtins only
+	// read the values of the same input. Edit has no effect with NullInput,
+	// Slurp or Raw.
+	Edit bool
+
+	// Reencode encodes the results of inputs that can't be written as edits
+	// of them as usual, losing their comments and formatting, rather than
+	// failing. Results can't be written as edits when they would change
+	// values shared through YAML anchors, for example.
+	Reencode bool
+
+	// InPlace writes the results of each input back to the file at its Name,
+	// in the format of the input, instead of to the writer given to Run.
+	// OutputFormat is ignored. Files are replaced atomically, and only if
+	// their contents changed, once the program has been run against all of
+	// their values. Inputs are processed one at a time, as with Edit.
+	// InPlace can't be used with NullInput, Slurp or Limit, and an input
+	// the program produces no results for is ErrEmptyFile rather than being
+	// emptied.
+	InPlace bool
+
+	// AllowEmpty empties the files written in place that the program
+	// produces no results for instead of failing with ErrEmptyFile.
+	AllowEmpty bool
+
+	// BackupSuffix is appended to the Name of each input written in place
+	// to keep a copy of its original contents, unless it's empty.
+	BackupSuffix string
+
+	// DryRun writes a unified diff of the changes InPlace would make to the
+	// writer given to Run instead of making them.
+	DryRun bool
+
+	// Jobs is the number of inputs that are decoded and evaluated
+	// concurrently. Values less than one are treated as one. Programs that
+	// may call input or inputs are evaluated one input at a time.
+	Jobs int
+
+	// Unordered allows the results of each input to be written as soon as
+	// they're available rather than in the order of the inputs when Jobs is
+	// greater than one.
+	Unordered bool
+
+	// Limit is the maximum number of results. Once it has been reached, no
+	// more inputs are decoded and the program is stopped. Values less than one
+	// mean there is no limit.
+	Limit int
+
+	// ExitStatus makes the results determine the error that is returned, like
+	// jq's --exit-status: ErrFalseResult if the last result was false or null
+	// and ErrNoResults if there were none. It's decided from the JSON value of
+	// the result, so with Raw a string that reads "false" or "null" isn't
+	// false or null.
+	ExitStatus bool
+}
+
+var (
+	// ErrTimeout is returned when a Runner is stopped because the deadline of
+	// its context passed.
+	ErrTimeout = jq.ErrTimeout
+
+	// ErrFalseResult is returned when ExitStatus is set and the last result
+	// was false or null.
+	ErrFalseResult = errors.New("the last result was false or null")
+
+	// ErrNoResults is returned when ExitStatus is set and the program
+	// produced no results.
+	ErrNoResults = errors.New("the program produced no results")
+
+	// ErrEmptyFile is returned when InPlace is set and the program produced
+	// no results for an input, unless AllowEmpty is set.
+	ErrEmptyFile = internalfaq.ErrEmptyFile
+)
+
+// CompileError is returned when the program fails to compile.
+type CompileError = internalfaq.CompileError
+
+// DecodeError is returned when an input cannot be decoded.
+type DecodeError = internalfaq.DecodeError
+
+// HaltError is returned when the program stops itself with halt or
+// halt_error. The jq command line tool prints its Message to stderr as is and
+// exits with its ExitCode.
+type HaltError = jq.HaltError
+
+// DefaultEngine returns the name of the jq engine used when Runner.Engine is
+// empty.
+func DefaultEngine() string {
+	return jq.DefaultEngine()
+}
+
+// Engines returns the names of the jq engines that faq was built with.
+func Engines() []string {
+	return jq.Names()
+}
+
+// DecodeInput decodes the values of input in format, which is detected as for
+// Runner.InputFormat if it is empty or "auto". If input has a single value, it
+// is returned as is, otherwise an array of its values is returned.
+//
+// Values are decoded by encoding/json with numbers decoded as json.Number,
+// so they can be used as Arguments.
+func DecodeInput(input Input, format string) (interface{}, error) {
+	return (&Runner{}).DecodeInput(input, format)
+}
+
+// DecodeInput decodes the values of input as the DecodeInput function does,
+// with the options of the formats of r, such as CSV.
+func (r *Runner) DecodeInput(input Input, format string) (interface{}, error) {
+	if format == "" {
+		format = "auto"
+	}
+	file := internalfaq.NewFile(input.Name, ioutil.NopCloser(input.Reader))
+	dataList, err := internalfaq.DecodeFile(format, file, false, r.encodingConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]interface{}, 0, len(dataList))
+	for _, data := range dataList {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 1 {
+		return values[0], nil
+	}
+	return values, nil
+}
+
+// Run runs the program against inputs and writes the encoded results to w.
+//
+// Each result is written as soon as the program produces it. If w has a
+// Flush() error method, such as a bufio.Writer, it is flushed after each one.
+//
+// If ctx is done, Run stops the program and returns ErrTimeout if the deadline
+// passed, or the context's error otherwise.
+func (r *Runner) Run(ctx context.Context, w io.Writer, inputs ...Input) error {
+	files := make([]internalfaq.File, 0, len(inputs))
+	for _, input := range inputs {
+		files = append(files, internalfaq.NewFile(input.Name, ioutil.NopCloser(input.Reader)))
+	}
+
+	encoding, err := r.outputEncoding(files)
+	if err != nil {
+		return err
+	}
+	return r.run(ctx, w, encoding, r.Raw, r.outputConfig(), files)
+}
+
+// Bytes runs the program against inputs and returns the encoded results.
+func (r *Runner) Bytes(ctx context.Context, inputs ...Input) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.Run(ctx, &buf, inputs...); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Values runs the program against inputs and returns each result as a Go
+// value, as decoded by encoding/json with numbers decoded as json.Number.
+//
+// OutputFormat, Raw, Pretty, Color, Edit and InPlace do not apply to Values.
+func (r *Runner) Values(ctx context.Context, inputs ...Input) ([]interface{}, error) {
+	files := make([]internalfaq.File, 0, len(inputs))
+	for _, input := range inputs {
+		files = append(files, internalfaq.NewFile(input.Name, ioutil.NopCloser(input.Reader)))
+	}
+
+	collector := &valueEncoding{}
+	if err := r.run(ctx, ioutil.Discard, collector, false, internalfaq.OutputConfig{}, files); err != nil {
+		return nil, err
+	}
+	return collector.values, nil
+}
+
+func (r *Runner) run(ctx context.Context, w io.Writer, encoding objconv.Encoding, raw bool, outputConf internalfaq.OutputConfig, files []internalfaq.File) error {
+	engineName := r.Engine
+	if engineName == "" {
+		engineName = jq.DefaultEngine()
+	}
+	engine, ok := jq.ByName(engineName)
+	if !ok {
+		return fmt.Errorf("invalid engine %s, must be one of: %s", engineName, strings.Join(jq.Names(), ", "))
+	}
+	engine = internalfaq.WithBuiltins(engine, r.encodingConfig())
+	if r.LosslessNumbers {
+		engine = jq.LosslessNumbers(engine)
+	}
+	if r.InPlace && (r.NullInput || r.Slurp) {
+		return errors.New("inputs cannot be written in place with NullInput or Slurp")
+	}
+	if r.InPlace && r.Limit > 0 {
+		return errors.New("inputs cannot be written in place with a Limit, which would cut them short")
+	}
+
+	// The status is kept of the JSON values of the results, so with raw, it's
+	// the statusEncoding that writes strings raw rather than the engine.
+	var status *statusEncoding
+	if r.ExitStatus {
+		status = &statusEncoding{encoding, &resultStatus{}, raw}
+		encoding = status
+		if raw {
+			outputConf.Color = false
+			outputConf.Pretty = false
+			outputConf.Edit = false
+			raw = false
+		}
+	}
+
+	// The deadline may also pass between documents rather than while a
+	// program is running, in which case the context's error is returned.
+	err := r.process(ctx, w, engine, encoding, raw, outputConf, files)
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	if err != nil || status == nil {
+		return err
+	}
+	return status.err()
+}
+
+func (r *Runner) process(ctx context.Context, w io.Writer, engine jq.Engine, encoding objconv.Encoding, raw bool, outputConf internalfaq.OutputConfig, files []internalfaq.File) error {
+	program := r.Program
+	if program == "" {
+		program = "."
+	}
+
+	programArgs := internalfaq.ProgramArguments{
+		Args:       r.Arguments.Args,
+		Jsonargs:   r.Arguments.JSONArgs,
+		Kwargs:     r.Arguments.Kwargs,
+		Jsonkwargs: r.Arguments.JSONKwargs,
+	}
+
+	processConf := internalfaq.ProcessConfig{
+		Jobs:      r.Jobs,
+		Unordered: r.Unordered,
+		Limit:     r.Limit,
+		Stream:    r.Stream,
+		NullInput: r.NullInput,
+		Encodings: r.encodingConfig(),
+	}
+	if processConf.Limit < 0 {
+		processConf.Limit = 0
+	}
+
+	inputFormat := r.inputFormat()
+	if r.RawInput && r.Slurp && !r.NullInput {
+		return internalfaq.ProcessEachFile(ctx, "raw", []internalfaq.File{concatFiles(files)}, engine, program, programArgs, w, encoding, outputConf, raw, processConf)
+	}
+	if r.Slurp && !r.NullInput {
+		return internalfaq.SlurpAllFiles(ctx, inputFormat, files, engine, program, programArgs, w, encoding, outputConf, raw, processConf)
+	}
+
+	return internalfaq.ProcessEachFile(ctx, inputFormat, files, engine, program, programArgs, w, encoding, outputConf, raw, processConf)
+}
+
+func (r *Runner) inputFormat() string {
+	if r.RawInput {
+		return "lines"
+	}
+	if r.InputFormat == "" {
+		return "auto"
+	}
+	return r.InputFormat
+}
+
+func (r *Runner) encodingConfig() internalfaq.EncodingConfig {
+	return internalfaq.EncodingConfig{CSV: r.CSV}
+}
+
+func (r *Runner) outputConfig() internalfaq.OutputConfig {
+	return internalfaq.OutputConfig{
+		Pretty:   !r.Raw && r.Pretty,
+		Color:    !r.Raw && r.Color,
+		Edit:     !r.Raw && r.Edit,
+		Reencode: r.Reencode,
+
+		InPlace:      r.InPlace,
+		AllowEmpty:   r.AllowEmpty,
+		BackupSuffix: r.BackupSuffix,
+		DryRun:       r.DryRun,
+	}
+}
+
+// outputEncoding resolves the Encoding results are written in. Since detecting
+// the format may consume the first file, it may be replaced in files.
+func (r *Runner) outputEncoding(files []internalfaq.File) (objconv.Encoding, error) {
+	format := r.OutputFormat
+	if format == "" {
+		format = "auto"
+	}
+	if format == "auto" {
+		switch {
+		case r.NullInput || r.RawInput || len(files) == 0:
+			format = "json"
+		case r.inputFormat() != "auto":
+			format = r.inputFormat()
+		}
+	}
+
+	var encoding objconv.Encoding
+	if format != "auto" {
+		var ok bool
+		encoding, ok = objconv.ByName(format)
+		if !ok {
+			return nil, fmt.Errorf("invalid output format %s", format)
+		}
+		encoding = r.encodingConfig().Configure(encoding)
+	} else {
+		var file internalfaq.File
+		var err error
+		encoding, file, err = internalfaq.DetermineEncoding(format, files[0], r.encodingConfig())
+		if err != nil {
+			return nil, fmt.Errorf("failed to detect output format: %w", err)
+		}
+		files[0] = file
+	}
+
+	if _, ok := encoding.(objconv.CBOREncoding); ok && r.CBORDiagnostic {
+		encoding = objconv.CBOREncoding{Diagnostic: true}
+	}
+	return encoding, nil
+}
+
+// concatFiles returns a File that reads each of files in turn.
+func concatFiles(files []internalfaq.File) internalfaq.File {
+	var name string
+	readers := make([]io.Reader, 0, len(files))
+	for _, file := range files {
+		if name == "" {
+			name = file.Path()
+		}
+		readers = append(readers, file.Reader())
+	}
+	return internalfaq.NewFile(name, ioutil.NopCloser(io.MultiReader(readers...)))
+}
+
+var (
+	_ objconv.Encoding = &valueEncoding{}
+	_ objconv.Encoder  = &valueEncoding{}
+)
+
+// valueEncoding is an objconv.Encoding whose encoders collect the JSON values
+// they're given as Go values.
+type valueEncoding struct {
+	values []interface{}
+}
+
+func (e *valueEncoding) NewDecoder(io.Reader) objconv.Decoder {
+	panic("valueEncoding cannot decode")
+}
+
+func (e *valueEncoding) NewEncoder(io.Writer) objconv.Encoder {
+	return e
+}
+
+func (e *valueEncoding) UnmarshalJSONBytes(input []byte, color, pretty bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(input))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	e.values = append(e.values, value)
+	return nil
+}
+
+var _ objconv.Encoder = &statusEncoder{}
+
+// statusEncoding wraps an objconv.Encoding to keep track of the last result
+// that its encoders are given. If raw is set, the results that are strings
+// are passed on as their raw contents rather than as JSON.
+type statusEncoding struct {
+	objconv.Encoding
+	*resultStatus
+	raw bool
+}
+
+// resultStatus is the number of results and the last of them, which is shared
+// by the statusEncodings that Wrap returns.
+type resultStatus struct {
+	results int
+	last    []byte
+}
+
+func (e *statusEncoding) NewEncoder(w io.Writer) objconv.Encoder {
+	return &statusEncoder{encoding: e, encoder: e.Encoding.NewEncoder(w)}
+}
+
+// NewEditEncoder implements objconv.Editor. Values are encoded as usual if
+// the wrapped Encoding isn't an objconv.Editor.
+func (e *statusEncoding) NewEditEncoder(w io.Writer, original []byte, reencode bool) objconv.EditEncoder {
+	editor, ok := e.Encoding.(objconv.Editor)
+	if !ok {
+		return &statusEncoder{encoding: e, encoder: e.Encoding.NewEncoder(w)}
+	}
+	return &statusEncoder{encoding: e, editor: editor.NewEditEncoder(w, original, reencode)}
+}
+
+// Unwrap returns the wrapped Encoding.
+func (e *statusEncoding) Unwrap() objconv.Encoding {
+	return e.Encoding
+}
+
+// Wrap returns a statusEncoding wrapping encoding that keeps track of results
+// along with e, such as for the encodings of inputs written in place.
+func (e *statusEncoding) Wrap(encoding objconv.Encoding) objconv.Encoding {
+	return &statusEncoding{encoding, e.resultStatus, e.raw}
+}
+
+// err returns the error for the results, as described by Runner.ExitStatus.
+func (e *statusEncoding) err() error {
+	if e.results == 0 {
+		return ErrNoResults
+	}
+	switch string(bytes.TrimSpace(e.last)) {
+	case "false", "null":
+		return ErrFalseResult
+	}
+	return nil
+}
+
+// statusEncoder passes results to encoder, or to editor if it writes them as
+// edits, keeping track of them for its statusEncoding.
+type statusEncoder struct {
+	encoding *statusEncoding
+	encoder  objconv.Encoder
+	editor   objconv.EditEncoder
+}
+
+func (e *statusEncoder) UnmarshalJSONBytes(input []byte, color, pretty bool) error {
+	e.encoding.results++
+	e.encoding.last = append(e.encoding.last[:0], input...)
+	if e.encoding.raw {
+		var str string
+		if bytes.HasPrefix(bytes.TrimSpace(input), []byte(`"`)) && json.Unmarshal(input, &str) == nil {
+			input = []byte(str)
+		}
+	}
+	return e.encoder.UnmarshalJSONBytes(input, color, pretty)
+}
+
+func (e *statusEncoder) EditJSONBytes(document int, input []byte, color, pretty bool) error {
+	if e.editor == nil {
+		return e.UnmarshalJSONBytes(input, color, pretty)
+	}
+	e.encoding.results++
+	e.encoding.last = append(e.encoding.last[:0], input...)
+	return e.editor.EditJSONBytes(document, input, color, pretty)
+}
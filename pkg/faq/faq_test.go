@@ -0,0 +1,486 @@
+package faq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jzelinskie/faq/pkg/objconv"
+)
+
+func TestRunnerBytes(t *testing.T) {
+	testCases := []struct {
+		name           string
+		runner         Runner
+		inputs         []testInput
+		expectedOutput string
+	}{
+		{
+			name:           "detected input and output format",
+			runner:         Runner{Program: ".foo"},
+			inputs:         []testInput{{"test.yaml", "foo:\n  bar: true\n"}},
+			expectedOutput: "bar: true\n",
+		},
+		{
+			name:           "explicit formats",
+			runner:         Runner{Program: ".[]", InputFormat: "json", OutputFormat: "json"},
+			inputs:         []testInput{{"", `[1, "two"]`}},
+			expectedOutput: "1\n\"two\"\n",
+		},
+		{
+			name:           "raw output",
+			runner:         Runner{Program: ".name", Raw: true},
+			inputs:         []testInput{{"test.json", `{"name":"faq"}`}},
+			expectedOutput: "faq\n",
+		},
+		{
+			name:           "multiple inputs",
+			runner:         Runner{Program: ".", OutputFormat: "json"},
+			inputs:         []testInput{{"a.json", `1`}, {"b.yaml", `2`}},
+			expectedOutput: "1\n2\n",
+		},
+		{
+			name:           "slurp",
+			runner:         Runner{Program: "map(. * 2)", Slurp: true, InputFormat: "json"},
+			inputs:         []testInput{{"", `1 2`}, {"", `3`}},
+			expectedOutput: "[2,4,6]\n",
+		},
+		{
+			name: "null input with arguments",
+			runner: Runner{
+				Program:   "[$foo, $ARGS.positional[0]]",
+				NullInput: true,
+				Arguments: Arguments{Args: []string{"bar"}, Kwargs: map[string]string{"foo": "baz"}},
+			},
+			expectedOutput: `["baz","bar"]` + "\n",
+		},
+		{
+			name:           "limit",
+			runner:         Runner{Program: ".[]", Limit: 2},
+			inputs:         []testInput{{"a.json", `[1, 2, 3]`}, {"b.json", `[4]`}},
+			expectedOutput: "1\n2\n",
+		},
+		{
+			name:           "limit with null input",
+			runner:         Runner{Program: "range(1e12)", NullInput: true, Limit: 1},
+			expectedOutput: "0\n",
+		},
+		{
+			name:           "null input with inputs",
+			runner:         Runner{Program: "reduce inputs as $n (0; . + $n)", NullInput: true},
+			inputs:         []testInput{{"a.json", `1 2`}, {"b.yaml", "3\n"}},
+			expectedOutput: "6\n",
+		},
+		{
+			name:           "raw input",
+			runner:         Runner{Program: "length", RawInput: true},
+			inputs:         []testInput{{"a.txt", "one\ntwo\n"}, {"b.json", "three"}},
+			expectedOutput: "3\n3\n5\n",
+		},
+		{
+			name:           "raw input slurp",
+			runner:         Runner{Program: ".", RawInput: true, Slurp: true},
+			inputs:         []testInput{{"a.txt", "one\n"}, {"b.json", "two"}},
+			expectedOutput: `"one\ntwo"` + "\n",
+		},
+		{
+			name:           "concurrent jobs",
+			runner:         Runner{Program: ".", InputFormat: "json", Jobs: 2},
+			inputs:         []testInput{{"", `1`}, {"", `2`}, {"", `3`}},
+			expectedOutput: "1\n2\n3\n",
+		},
+	}
+
+	for _, engine := range Engines() {
+		for _, testCase := range testCases {
+			testCase := testCase
+			testCase.runner.Engine = engine
+			t.Run(engine+"/"+testCase.name, func(t *testing.T) {
+				var inputs []Input
+				for _, input := range testCase.inputs {
+					inputs = append(inputs, Input{input.name, strings.NewReader(input.contents)})
+				}
+
+				output, err := testCase.runner.Bytes(context.Background(), inputs...)
+				if err != nil {
+					t.Fatalf("expected no err, got %s", err)
+				}
+				if string(output) != testCase.expectedOutput {
+					t.Errorf("incorrect output expected=%q, got=%q", testCase.expectedOutput, output)
+				}
+			})
+		}
+	}
+}
+
+type testInput struct {
+	name     string
+	contents string
+}
+
+func TestRunnerValues(t *testing.T) {
+	runner := Runner{Program: ".items[] | {name, replicas}"}
+	values, err := runner.Values(context.Background(), Input{"deploy.yaml", strings.NewReader(`
+items:
+- name: web
+  replicas: 3
+- name: worker
+  replicas: 5
+`)})
+	if err != nil {
+		t.Fatalf("expected no err, got %s", err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{"name": "web", "replicas": json.Number("3")},
+		map[string]interface{}{"name": "worker", "replicas": json.Number("5")},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("incorrect values expected=%#v, got=%#v", expected, values)
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	testCases := []struct {
+		input         testInput
+		format        string
+		expectedValue interface{}
+	}{
+		{testInput{"a.yaml", "a: 1\n"}, "", map[string]interface{}{"a": json.Number("1")}},
+		{testInput{"a.yaml", "a: 1\n---\nb: 2\n"}, "auto", []interface{}{
+			map[string]interface{}{"a": json.Number("1")},
+			map[string]interface{}{"b": json.Number("2")},
+		}},
+		{testInput{"config", "[s]\nx = \"y\"\n"}, "toml", map[string]interface{}{"s": map[string]interface{}{"x": "y"}}},
+		{testInput{"empty.json", ""}, "", []interface{}{}},
+	}
+
+	for _, testCase := range testCases {
+		value, err := DecodeInput(Input{testCase.input.name, strings.NewReader(testCase.input.contents)}, testCase.format)
+		if err != nil {
+			t.Errorf("%s: expected no err, got %s", testCase.input.name, err)
+		}
+		if !reflect.DeepEqual(value, testCase.expectedValue) {
+			t.Errorf("%s: incorrect value expected=%#v, got=%#v", testCase.input.name, testCase.expectedValue, value)
+		}
+	}
+
+	var decodeErr *DecodeError
+	if _, err := DecodeInput(Input{"a.json", strings.NewReader(`{`)}, ""); !errors.As(err, &decodeErr) {
+		t.Errorf("expected a DecodeError, got %v", err)
+	}
+}
+
+func TestRunnerErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	runner := Runner{Program: "."}
+	if _, err := runner.Bytes(ctx, Input{"test.json", strings.NewReader(`{}`)}); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	for _, engine := range Engines() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		runner = Runner{Program: "last(range(1e12))", Engine: engine, NullInput: true}
+		if _, err := runner.Bytes(ctx); err != ErrTimeout {
+			t.Errorf("%s: expected ErrTimeout, got %v", engine, err)
+		}
+		cancel()
+	}
+
+	runner = Runner{Program: ".", Engine: "missing"}
+	if _, err := runner.Bytes(context.Background(), Input{"test.json", strings.NewReader(`{}`)}); err == nil {
+		t.Error("expected an error for an invalid engine")
+	}
+
+	runner = Runner{Program: ".", OutputFormat: "missing"}
+	if _, err := runner.Bytes(context.Background(), Input{"test.json", strings.NewReader(`{}`)}); err == nil {
+		t.Error("expected an error for an invalid output format")
+	}
+
+	var compileErr *CompileError
+	runner = Runner{Program: ".a |"}
+	if _, err := runner.Bytes(context.Background(), Input{"test.json", strings.NewReader(`{}`)}); !errors.As(err, &compileErr) {
+		t.Errorf("expected a CompileError, got %v", err)
+	}
+
+	var decodeErr *DecodeError
+	runner = Runner{Program: "."}
+	if _, err := runner.Bytes(context.Background(), Input{"test.json", strings.NewReader(`{"a":`)}); !errors.As(err, &decodeErr) {
+		t.Errorf("expected a DecodeError, got %v", err)
+	}
+}
+
+func TestRunnerExitStatus(t *testing.T) {
+	testCases := []struct {
+		program        string
+		raw            bool
+		expectedOutput string
+		expectedErr    error
+	}{
+		{".a", false, "1\n", nil},
+		{".a > 1", false, "false\n", ErrFalseResult},
+		{".b", false, "null\n", ErrFalseResult},
+		{"false, true", false, "false\ntrue\n", nil},
+		{"true, null", false, "true\nnull\n", ErrFalseResult},
+		{"empty", false, "", ErrNoResults},
+		{`"x"`, true, "x\n", nil},
+		{`"false"`, true, "false\n", nil},
+		{`"null"`, true, "null\n", nil},
+		{`.b`, true, "null\n", ErrFalseResult},
+		{`{a: "x"}`, true, "{\"a\":\"x\"}\n", nil},
+	}
+
+	for _, testCase := range testCases {
+		runner := Runner{Program: testCase.program, Raw: testCase.raw, ExitStatus: true}
+		output, err := runner.Bytes(context.Background(), Input{"test.json", strings.NewReader(`{"a":1}`)})
+		if err != testCase.expectedErr {
+			t.Errorf("%s: expected %v, got %v", testCase.program, testCase.expectedErr, err)
+		}
+		if err == nil && string(output) != testCase.expectedOutput {
+			t.Errorf("%s: incorrect output expected=%q, got=%q", testCase.program, testCase.expectedOutput, output)
+		}
+	}
+}
+
+func TestRunnerKeyOrder(t *testing.T) {
+	// libjq keeps the order of keys, while gojq sorts them.
+	expected := map[string]string{
+		"libjq": "z: 1\na:\n  x: 2\n  b: 3\n",
+		"gojq":  "a:\n  b: 3\n  x: 2\nz: 1\n",
+	}
+	for _, engine := range Engines() {
+		runner := Runner{Program: `.`, Engine: engine}
+		output, err := runner.Bytes(context.Background(), Input{"test.yaml", strings.NewReader("z: 1\na: {x: 2, b: 3}\n")})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", engine, err)
+		}
+		if string(output) != expected[engine] {
+			t.Errorf("%s: incorrect output expected=%q, got=%q", engine, expected[engine], output)
+		}
+	}
+}
+
+func TestRunnerLosslessNumbers(t *testing.T) {
+	input := "id: 1234567890123456789012\nprice: 1.50\ncount: 1.0\n"
+	for _, engine := range Engines() {
+		runner := Runner{Program: `[.id, .price, .price * 2, .price - 0.5, .count]`, Engine: engine, OutputFormat: "json", LosslessNumbers: true}
+		output, err := runner.Bytes(context.Background(), Input{"test.yaml", strings.NewReader(input)})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", engine, err)
+		}
+		if expected := "[1234567890123456789012,1.50,3,1.0,1.0]\n"; string(output) != expected {
+			t.Errorf("%s: incorrect output expected=%q, got=%q", engine, expected, output)
+		}
+	}
+}
+
+func TestRunnerCBORBignums(t *testing.T) {
+	// Bignums beyond 2^53 are written back as the same bytes, though jq holds
+	// numbers as floats.
+	input := "\xc2\x49\x01\x00\x00\x00\x00\x00\x00\x00\x00\xc3\x42\x01\x00"
+	for _, engine := range Engines() {
+		runner := Runner{Program: `.`, Engine: engine, InputFormat: "cbor", OutputFormat: "cbor"}
+		output, err := runner.Bytes(context.Background(), Input{"test.cbor", strings.NewReader(input)})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", engine, err)
+		}
+		if expected := input[:11] + "\x39\x01\x00"; string(output) != expected {
+			t.Errorf("%s: incorrect output expected=%x, got=%x", engine, expected, output)
+		}
+	}
+}
+
+func TestRunnerEdit(t *testing.T) {
+	yamlInput := "# config\nname: web # the name\nreplicas: 2\n"
+	tomlInput := "# config\nname = \"web\" # the name\nreplicas = 2\n"
+	expected := "# config\nname: web # the name\nreplicas: 3\n" +
+		"name: web\nreplicas: 3\n" +
+		"# config\nname: web # the name\nreplicas: 3\n"
+	for _, jobs := range []int{1, 2} {
+		runner := Runner{Program: `.replicas = 3`, OutputFormat: "yaml", Edit: true, Jobs: jobs, ExitStatus: true}
+		output, err := runner.Bytes(context.Background(),
+			Input{"a.yaml", strings.NewReader(yamlInput)},
+			Input{"b.toml", strings.NewReader(tomlInput)},
+			Input{"c.yml", strings.NewReader(yamlInput)},
+		)
+		if err != nil {
+			t.Fatalf("jobs=%d: unexpected error: %s", jobs, err)
+		}
+		if string(output) != expected {
+			t.Errorf("jobs=%d: incorrect output expected=%q, got=%q", jobs, expected, output)
+		}
+	}
+}
+
+func TestRunnerEditDocuments(t *testing.T) {
+	input := "# first doc\nkind: A # kind of A\n---\nkind: B # kind of B\n"
+	testCases := []struct {
+		program  string
+		reencode bool
+		expected string
+	}{
+		{`select(.kind == "B")`, false, "---\nkind: B # kind of B\n"},
+		{`select(.kind == "A") | .x = 1`, false, "# first doc\nkind: A # kind of A\nx: 1\n"},
+		{`empty`, false, ""},
+		{`if .kind == "A" then empty else .kind = "C" end`, false, "---\nkind: C # kind of B\n"},
+		{`., .`, true, "# first doc\nkind: A # kind of A\n---\nkind: A\n---\nkind: B # kind of B\n---\nkind: B\n"},
+	}
+	for _, tc := range testCases {
+		runner := Runner{Program: tc.program, Edit: true, Reencode: tc.reencode}
+		output, err := runner.Bytes(context.Background(), Input{"a.yaml", strings.NewReader(input)})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.program, err)
+			continue
+		}
+		if string(output) != tc.expected {
+			t.Errorf("%s: incorrect output expected=%q, got=%q", tc.program, tc.expected, output)
+		}
+	}
+
+	// Results that aren't the only result of exactly one document can't be
+	// edits of it.
+	for _, program := range []string{`., .`, `[., input]`} {
+		runner := Runner{Program: program, Edit: true}
+		_, err := runner.Bytes(context.Background(), Input{"a.yaml", strings.NewReader(input)})
+		var editErr *objconv.EditError
+		if !errors.As(err, &editErr) {
+			t.Errorf("%s: expected an EditError, got %v", program, err)
+		}
+	}
+}
+
+func TestRunnerInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faq-in-place")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte("enabled: true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The results written in place count for ExitStatus.
+	runner := Runner{Program: `.enabled = false`, InPlace: true, ExitStatus: true}
+	output, err := runner.Bytes(context.Background(), Input{path, strings.NewReader("enabled: true\n")})
+	if err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if len(output) != 0 {
+		t.Errorf("expected no output, got %q", output)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "enabled: false\n" {
+		t.Errorf("incorrect contents of the file, got %q", data)
+	}
+}
+
+func TestRunnerInPlaceCutShort(t *testing.T) {
+	dir, err := ioutil.TempDir("", "faq-in-place")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	contents := "a: 1\n---\na: 2\n"
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A Limit would write back only the first document, and a program that
+	// produces no results would empty the file.
+	for _, runner := range []Runner{
+		{Program: `.`, InPlace: true, Limit: 1},
+		{Program: `select(.a == 3)`, InPlace: true},
+	} {
+		if _, err := runner.Bytes(context.Background(), Input{path, strings.NewReader(contents)}); err == nil {
+			t.Errorf("expected an error running %s with Limit %d", runner.Program, runner.Limit)
+		}
+		if data, _ := ioutil.ReadFile(path); string(data) != contents {
+			t.Errorf("expected the file to be unchanged, got %q", data)
+		}
+	}
+}
+
+func TestRunnerReencode(t *testing.T) {
+	input := "base: &base\n  x: 1 # one\nd:\n  <<: *base\n"
+
+	runner := Runner{Program: `.base.x = 5`, Edit: true}
+	_, err := runner.Bytes(context.Background(), Input{"a.yaml", strings.NewReader(input)})
+	var editErr *objconv.EditError
+	if !errors.As(err, &editErr) {
+		t.Fatalf("expected an EditError, got %v", err)
+	}
+
+	runner.Reencode = true
+	output, err := runner.Bytes(context.Background(), Input{"a.yaml", strings.NewReader(input)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if expected := "base:\n  x: 5\nd:\n  x: 1\n"; string(output) != expected {
+		t.Errorf("incorrect output expected=%q, got=%q", expected, output)
+	}
+}
+
+func TestRunnerEncodingOptions(t *testing.T) {
+	testCases := []struct {
+		name     string
+		runner   Runner
+		input    Input
+		expected string
+	}{
+		{
+			"csv options",
+			Runner{Program: `.[0] |= ascii_upcase`, CSV: objconv.CSVEncoding{Delimiter: ';', NoHeader: true}},
+			Input{"a.csv", strings.NewReader("a;b\nc;d\n")},
+			"A;b\nC;d\n",
+		},
+		{
+			"tsv keeps tabs",
+			Runner{Program: `.a`, OutputFormat: "json", CSV: objconv.CSVEncoding{Delimiter: ';'}},
+			Input{"a.tsv", strings.NewReader("a\tb\n1;2\t3\n")},
+			"\"1;2\"\n",
+		},
+		{
+			"csv builtins",
+			Runner{Program: `[{a: 1, b: "x"}] | tocsv`, NullInput: true, Raw: true, CSV: objconv.CSVEncoding{Delimiter: ';', NoHeader: true}},
+			Input{},
+			"1;x\n",
+		},
+		{
+			"cbor diagnostic",
+			Runner{Program: `{a: [1, "x"]}`, NullInput: true, OutputFormat: "cbor", Pretty: true, CBORDiagnostic: true},
+			Input{},
+			"{\n  \"a\": [\n    1,\n    \"x\"\n  ]\n}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		var inputs []Input
+		if tc.input.Reader != nil {
+			inputs = append(inputs, tc.input)
+		}
+		output, err := tc.runner.Bytes(context.Background(), inputs...)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.name, err)
+		}
+		if string(output) != tc.expected {
+			t.Errorf("%s: incorrect output expected=%q, got=%q", tc.name, tc.expected, output)
+		}
+	}
+
+	// The options of a Runner don't change the registered encodings.
+	if csv, _ := objconv.ByName("csv"); csv != (objconv.CSVEncoding{}) {
+		t.Errorf("expected the csv encoding to be unchanged, got %#v", csv)
+	}
+}
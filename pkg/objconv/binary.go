@@ -0,0 +1,43 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+)
+
+// The lengths read by the decoders of binary formats come from their input,
+// which may claim more data than it has, so these helpers don't allocate
+// memory for a length up front.
+
+// readBytes reads n bytes from r. The buffer grows as they're read.
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// elementCapacity limits the capacity allocated for n elements.
+func elementCapacity(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
+
+// floatNumber returns a float as a JSON number that isn't an integer, or
+// as a string if it isn't finite.
+func floatNumber(f float64, bitSize int) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, bitSize)
+	}
+	s := strconv.FormatFloat(f, 'g', -1, bitSize)
+	if isJSONInteger(json.Number(s)) {
+		s += ".0"
+	}
+	return json.Number(s)
+}
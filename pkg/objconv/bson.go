@@ -1,6 +1,7 @@
 package objconv
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -45,7 +46,28 @@ func (d *bsonDecoder) MarshalJSONBytes() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	return json.Marshal(obj)
+	return json.Marshal(bsonDecimals(obj))
+}
+
+// bsonDecimals converts the Decimal128 values in a decoded document to
+// json.Numbers, or to strings if they aren't finite.
+func bsonDecimals(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.M:
+		for key, value := range v {
+			v[key] = bsonDecimals(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = bsonDecimals(value)
+		}
+	case bson.Decimal128:
+		if s := v.String(); isJSONNumber(s) {
+			return json.Number(s)
+		}
+		return v.String()
+	}
+	return v
 }
 
 type bsonEncoder struct {
@@ -62,8 +84,12 @@ func (e bsonEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) er
 }
 
 func (bsonEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
+	// The bson package writes json.Numbers as int64 when they're integers.
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+
 	var obj interface{}
-	err := json.Unmarshal(jsonBytes, &obj)
+	err := decoder.Decode(&obj)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,725 @@
+package objconv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+var (
+	_ Encoding = CBOREncoding{}
+	_ Decoder  = &cborDecoder{}
+	_ Encoder  = &cborEncoder{}
+)
+
+// The keys of the objects that represent CBOR values that JSON doesn't have:
+//
+//	{"$cbor_bytes": "AQID"}                          a byte string, in base64
+//	{"$cbor_tag": 0, "value": "2013-03-21T20:04:00Z"} a tagged value
+//	{"$cbor_simple": 23}                             a simple value, here undefined
+//
+// The encoder writes objects of these shapes, with no other keys, as the
+// values they represent.
+const (
+	cborBytesKey  = "$cbor_bytes"
+	cborTagKey    = "$cbor_tag"
+	cborSimpleKey = "$cbor_simple"
+)
+
+// The tags of bignums, whose content is the bytes of an unsigned integer n,
+// for the integers n and -1-n.
+const (
+	cborPositiveBignum = 2
+	cborNegativeBignum = 3
+)
+
+// cborMaxExactInteger is 2^53, beyond which not every integer is a float64,
+// as jq holds numbers.
+var cborMaxExactInteger = new(big.Int).Lsh(big.NewInt(1), 53)
+
+// CBOREncoding is CBOR. A sequence of concatenated items is decoded as one
+// value each.
+//
+// Integers, including bignums within 2^53, are decoded as JSON integers, and
+// floats as JSON numbers with a fraction or exponent, such as 1.0, so that the
+// encoder writes them back as the same type. Integers that don't fit in 64
+// bits are encoded as bignums, and floats in the shortest of the half, single
+// and double precision formats that holds them exactly. Floats that aren't
+// finite are decoded as the strings "NaN", "+Inf" and "-Inf". Byte strings,
+// tags, such as dates and bignums beyond 2^53, and simple values other than
+// false, true and null are decoded as objects that are encoded back to the
+// same value.
+// Map keys that aren't strings are decoded as their JSON text, such as "1".
+type CBOREncoding struct {
+	// Diagnostic writes pretty-printed items in CBOR's diagnostic notation,
+	// such as {"a": h'0102', "b": 0("2013-03-21T20:04:00Z")}, rather than
+	// in binary. It's meant for output to a terminal.
+	Diagnostic bool
+}
+
+// NewDecoder implements Encoding.
+func (CBOREncoding) NewDecoder(r io.Reader) Decoder {
+	return &cborDecoder{cborReader{bufio.NewReader(r)}}
+}
+
+// NewEncoder implements Encoding.
+func (e CBOREncoding) NewEncoder(w io.Writer) Encoder {
+	return &cborEncoder{w, e.Diagnostic}
+}
+
+// CBOR's major types.
+const (
+	cborUint = iota
+	cborNegint
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// cborIndefinite is the additional information of a byte string, text
+// string, array or map of indefinite length, whose items are followed by
+// cborBreak.
+const (
+	cborIndefinite = 31
+	cborBreak      = 0xff
+)
+
+// cborHead is the head of a data item: its major type, additional
+// information and the argument that follows.
+type cborHead struct {
+	major, info byte
+	arg         uint64
+}
+
+// cborReader reads the data items of CBOR.
+type cborReader struct {
+	r *bufio.Reader
+}
+
+func (r cborReader) readHead() (cborHead, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return cborHead{}, err
+	}
+	head := cborHead{major: b >> 5, info: b & 0x1f}
+	switch {
+	case head.info < 24:
+		head.arg = uint64(head.info)
+	case head.info <= 27:
+		var buf [8]byte
+		size := 1 << (head.info - 24)
+		if _, err := io.ReadFull(r.r, buf[8-size:]); err != nil {
+			return cborHead{}, err
+		}
+		head.arg = binary.BigEndian.Uint64(buf[:])
+	case head.info == cborIndefinite:
+		if head.major == cborUint || head.major == cborNegint || head.major == cborTag {
+			return cborHead{}, fmt.Errorf("cbor: invalid indefinite length of major type %d", head.major)
+		}
+		if b == cborBreak {
+			return cborHead{}, errors.New("cbor: unexpected break")
+		}
+	default:
+		return cborHead{}, fmt.Errorf("cbor: invalid additional information %d", head.info)
+	}
+	return head, nil
+}
+
+// length returns the argument of a head as a length.
+func (head cborHead) length() (int, error) {
+	if head.arg > math.MaxInt32 {
+		return 0, fmt.Errorf("cbor: length %d is too large", head.arg)
+	}
+	return int(head.arg), nil
+}
+
+// capacity returns the capacity to allocate for the items of an array or
+// map.
+func (head cborHead) capacity() (int, error) {
+	if head.info == cborIndefinite {
+		return 0, nil
+	}
+	n, err := head.length()
+	return elementCapacity(n), err
+}
+
+// more reports whether the items of an indefinite length string, array or
+// map continue, consuming the break that ends them.
+func (r cborReader) more(head cborHead, i int) (bool, error) {
+	if head.info != cborIndefinite {
+		return i < int(head.arg), nil
+	}
+	b, err := r.r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	if b[0] == cborBreak {
+		r.r.ReadByte()
+		return false, nil
+	}
+	return true, nil
+}
+
+// readString reads the contents of a byte or text string, which may be
+// split in chunks if its length is indefinite.
+func (r cborReader) readString(head cborHead) ([]byte, error) {
+	if head.info != cborIndefinite {
+		n, err := head.length()
+		if err != nil {
+			return nil, err
+		}
+		return readBytes(r.r, n)
+	}
+
+	var buf bytes.Buffer
+	for i := 0; ; i++ {
+		more, err := r.more(head, i)
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			return buf.Bytes(), nil
+		}
+		chunk, err := r.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if chunk.major != head.major || chunk.info == cborIndefinite {
+			return nil, errors.New("cbor: invalid chunk of an indefinite length string")
+		}
+		data, err := r.readString(chunk)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+}
+
+// cborFloat returns the float of a head of major type 7 and its size in
+// bits, and whether it is one. Half precision floats are exact single
+// precision floats, and have their size.
+func cborFloat(head cborHead) (float64, int, bool) {
+	switch head.info {
+	case 25:
+		return cborHalfToFloat(uint16(head.arg)), 32, true
+	case 26:
+		return float64(math.Float32frombits(uint32(head.arg))), 32, true
+	case 27:
+		return math.Float64frombits(head.arg), 64, true
+	}
+	return 0, 0, false
+}
+
+// cborNegative returns the integer -1-n.
+func cborNegative(n uint64) string {
+	if n < math.MaxInt64 {
+		return strconv.FormatInt(-1-int64(n), 10)
+	}
+	i := new(big.Int).SetUint64(n)
+	return i.Neg(i.Add(i, big.NewInt(1))).String()
+}
+
+type cborDecoder struct {
+	cborReader
+}
+
+func (d *cborDecoder) MarshalJSONBytes() ([]byte, error) {
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+	value, err := d.decodeValue()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSONValue(value)
+}
+
+func (d *cborDecoder) decodeValue() (interface{}, error) {
+	head, err := d.readHead()
+	if err != nil {
+		return nil, err
+	}
+
+	switch head.major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(head.arg, 10)), nil
+	case cborNegint:
+		return json.Number(cborNegative(head.arg)), nil
+	case cborBytes:
+		data, err := d.readString(head)
+		if err != nil {
+			return nil, err
+		}
+		return orderedObject{{cborBytesKey, base64.StdEncoding.EncodeToString(data)}}, nil
+	case cborText:
+		data, err := d.readString(head)
+		return string(data), err
+	case cborArray:
+		n, err := head.capacity()
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, 0, n)
+		for i := 0; ; i++ {
+			more, err := d.more(head, i)
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				return array, nil
+			}
+			value, err := d.decodeValue()
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+	case cborMap:
+		n, err := head.capacity()
+		if err != nil {
+			return nil, err
+		}
+		obj := make(orderedObject, 0, n)
+		for i := 0; ; i++ {
+			more, err := d.more(head, i)
+			if err != nil {
+				return nil, err
+			}
+			if !more {
+				return obj, nil
+			}
+			key, err := d.decodeValue()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decodeValue()
+			if err != nil {
+				return nil, err
+			}
+
+			name, ok := key.(string)
+			if !ok {
+				keyBytes, err := marshalJSONValue(key)
+				if err != nil {
+					return nil, err
+				}
+				name = string(keyBytes)
+			}
+			obj = obj.set(name, value)
+		}
+	case cborTag:
+		value, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		// Bignums jq can't hold exactly are kept as tags, so that they're
+		// encoded back to the same bytes rather than rounded.
+		if data, ok := cborByteString(value); ok && (head.arg == cborPositiveBignum || head.arg == cborNegativeBignum) {
+			n := new(big.Int).SetBytes(data)
+			if head.arg == cborNegativeBignum {
+				n.Neg(n.Add(n, big.NewInt(1)))
+			}
+			if n.CmpAbs(cborMaxExactInteger) <= 0 {
+				return json.Number(n.String()), nil
+			}
+		}
+		return orderedObject{
+			{cborTagKey, json.Number(strconv.FormatUint(head.arg, 10))},
+			{"value", value},
+		}, nil
+	}
+
+	if f, bitSize, ok := cborFloat(head); ok {
+		return floatNumber(f, bitSize), nil
+	}
+	switch head.arg {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22:
+		return nil, nil
+	}
+	return orderedObject{{cborSimpleKey, json.Number(strconv.FormatUint(head.arg, 10))}}, nil
+}
+
+// cborByteString returns the bytes of an object representing a byte string.
+func cborByteString(value interface{}) ([]byte, bool) {
+	obj, ok := value.(orderedObject)
+	if !ok || len(obj) != 1 || obj[0].key != cborBytesKey {
+		return nil, false
+	}
+	s, ok := obj[0].value.(string)
+	if !ok {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	return data, err == nil
+}
+
+type cborEncoder struct {
+	w          io.Writer
+	diagnostic bool
+}
+
+// UnmarshalJSONBytes writes the CBOR encoding of a value, without a
+// separator, so that the items written form a sequence. Pretty-printed items
+// are written in diagnostic notation if the encoding's Diagnostic is set.
+func (e *cborEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
+	out, err := e.unmarshalJSONBytes(jsonBytes)
+	if err != nil {
+		return fmt.Errorf("failed to encode as: %s", err)
+	}
+	if !pretty || !e.diagnostic {
+		_, err = e.w.Write(out)
+		return err
+	}
+
+	out, err = e.prettyPrint(out)
+	if err != nil {
+		return fmt.Errorf("failed to encode as pretty: %s", err)
+	}
+	fmt.Fprintln(e.w, string(out))
+	return nil
+}
+
+func (e *cborEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
+	value, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := encodeCBOR(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// prettyPrint writes CBOR in diagnostic notation, with the items of arrays
+// and maps on their own lines.
+func (e *cborEncoder) prettyPrint(cborBytes []byte) ([]byte, error) {
+	r := cborReader{bufio.NewReader(bytes.NewReader(cborBytes))}
+	var buf bytes.Buffer
+	for i := 0; ; i++ {
+		if _, err := r.r.Peek(1); err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if i > 0 {
+			buf.WriteString(",\n")
+		}
+		if err := r.writeDiagnostic(&buf, ""); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// writeDiagnostic writes the next data item in diagnostic notation, indenting
+// its lines after the first by indent.
+func (r cborReader) writeDiagnostic(buf *bytes.Buffer, indent string) error {
+	head, err := r.readHead()
+	if err != nil {
+		return err
+	}
+
+	switch head.major {
+	case cborUint:
+		buf.WriteString(strconv.FormatUint(head.arg, 10))
+	case cborNegint:
+		buf.WriteString(cborNegative(head.arg))
+	case cborBytes, cborText:
+		data, err := r.readString(head)
+		if err != nil {
+			return err
+		}
+		if head.major == cborBytes {
+			buf.WriteString("h'" + hex.EncodeToString(data) + "'")
+		} else {
+			text, err := marshalJSONValue(string(data))
+			if err != nil {
+				return err
+			}
+			buf.Write(text)
+		}
+	case cborArray, cborMap:
+		open, close := "[", "]"
+		if head.major == cborMap {
+			open, close = "{", "}"
+		}
+		buf.WriteString(open)
+		if head.info == cborIndefinite {
+			buf.WriteString("_ ")
+		}
+		var i int
+		for ; ; i++ {
+			more, err := r.more(head, i)
+			if err != nil {
+				return err
+			}
+			if !more {
+				break
+			}
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n" + indent + "  ")
+			if err := r.writeDiagnostic(buf, indent+"  "); err != nil {
+				return err
+			}
+			if head.major == cborMap {
+				buf.WriteString(": ")
+				if err := r.writeDiagnostic(buf, indent+"  "); err != nil {
+					return err
+				}
+			}
+		}
+		if i > 0 {
+			buf.WriteString("\n" + indent)
+		}
+		buf.WriteString(close)
+	case cborTag:
+		buf.WriteString(strconv.FormatUint(head.arg, 10) + "(")
+		if err := r.writeDiagnostic(buf, indent); err != nil {
+			return err
+		}
+		buf.WriteString(")")
+	default:
+		if f, bitSize, ok := cborFloat(head); ok {
+			switch {
+			case math.IsNaN(f):
+				buf.WriteString("NaN")
+			case math.IsInf(f, 1):
+				buf.WriteString("Infinity")
+			case math.IsInf(f, -1):
+				buf.WriteString("-Infinity")
+			default:
+				buf.WriteString(string(floatNumber(f, bitSize).(json.Number)))
+			}
+			return nil
+		}
+		switch head.arg {
+		case 20:
+			buf.WriteString("false")
+		case 21:
+			buf.WriteString("true")
+		case 22:
+			buf.WriteString("null")
+		case 23:
+			buf.WriteString("undefined")
+		default:
+			buf.WriteString("simple(" + strconv.FormatUint(head.arg, 10) + ")")
+		}
+	}
+	return nil
+}
+
+func encodeCBOR(buf *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		writeCBORHead(buf, cborSimple, 22)
+	case bool:
+		if value {
+			writeCBORHead(buf, cborSimple, 21)
+		} else {
+			writeCBORHead(buf, cborSimple, 20)
+		}
+	case json.Number:
+		encodeCBORNumber(buf, value)
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(value)))
+		buf.WriteString(value)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(value)))
+		for _, elem := range value {
+			if err := encodeCBOR(buf, elem); err != nil {
+				return err
+			}
+		}
+	case orderedObject:
+		if ok, err := encodeCBORObject(buf, value); ok || err != nil {
+			return err
+		}
+		writeCBORHead(buf, cborMap, uint64(len(value)))
+		for _, field := range value {
+			if err := encodeCBOR(buf, field.key); err != nil {
+				return err
+			}
+			if err := encodeCBOR(buf, field.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: unsupported type %T", value)
+	}
+	return nil
+}
+
+// encodeCBORObject writes an object representing a byte string, tag or
+// simple value. ok is false if the object doesn't represent one.
+func encodeCBORObject(buf *bytes.Buffer, obj orderedObject) (ok bool, err error) {
+	switch {
+	case len(obj) == 1 && obj[0].key == cborBytesKey:
+		data, ok := cborByteString(obj)
+		if !ok {
+			return false, fmt.Errorf("cbor: %s must be a string in base64", cborBytesKey)
+		}
+		writeCBORHead(buf, cborBytes, uint64(len(data)))
+		buf.Write(data)
+	case len(obj) == 2 && obj[0].key == cborTagKey && obj[1].key == "value":
+		tag, err := cborArgument(obj[0].value)
+		if err != nil {
+			return false, fmt.Errorf("cbor: invalid %s: %s", cborTagKey, err)
+		}
+		writeCBORHead(buf, cborTag, tag)
+		return true, encodeCBOR(buf, obj[1].value)
+	case len(obj) == 1 && obj[0].key == cborSimpleKey:
+		simple, err := cborArgument(obj[0].value)
+		// Simple values 24 to 31 are reserved, and the others are written
+		// in a single byte or in the byte after it.
+		if err != nil || simple > math.MaxUint8 || 24 <= simple && simple < 32 {
+			return false, fmt.Errorf("cbor: invalid %s %v", cborSimpleKey, obj[0].value)
+		}
+		if simple < 24 {
+			buf.WriteByte(cborSimple<<5 | byte(simple))
+		} else {
+			buf.Write([]byte{cborSimple<<5 | 24, byte(simple)})
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// cborArgument returns the unsigned integer of a JSON value.
+func cborArgument(value interface{}) (uint64, error) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%v isn't an unsigned integer", value)
+	}
+	return strconv.ParseUint(string(n), 10, 64)
+}
+
+// encodeCBORNumber writes integers in the shortest form that holds them,
+// as bignums if they don't fit in 64 bits, and other numbers as floats.
+func encodeCBORNumber(buf *bytes.Buffer, n json.Number) {
+	if isJSONInteger(n) {
+		if i, ok := new(big.Int).SetString(string(n), 10); ok {
+			major, tag := byte(cborUint), uint64(cborPositiveBignum)
+			if i.Sign() < 0 {
+				// Negative integers are written as -1-n.
+				major, tag = cborNegint, cborNegativeBignum
+				i.Neg(i.Add(i, big.NewInt(1)))
+			}
+			if i.IsUint64() {
+				writeCBORHead(buf, major, i.Uint64())
+			} else {
+				writeCBORHead(buf, cborTag, tag)
+				writeCBORHead(buf, cborBytes, uint64(len(i.Bytes())))
+				buf.Write(i.Bytes())
+			}
+			return
+		}
+	}
+
+	f, _ := strconv.ParseFloat(string(n), 64)
+	if f32 := float32(f); float64(f32) == f {
+		if half, ok := cborFloatToHalf(f32); ok {
+			buf.WriteByte(cborSimple<<5 | 25)
+			binary.Write(buf, binary.BigEndian, half)
+			return
+		}
+		buf.WriteByte(cborSimple<<5 | 26)
+		binary.Write(buf, binary.BigEndian, math.Float32bits(f32))
+		return
+	}
+	buf.WriteByte(cborSimple<<5 | 27)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+}
+
+// writeCBORHead writes the head of a data item in the shortest form that
+// holds its argument.
+func writeCBORHead(buf *bytes.Buffer, major byte, arg uint64) {
+	major <<= 5
+	switch {
+	case arg < 24:
+		buf.WriteByte(major | byte(arg))
+	case arg <= math.MaxUint8:
+		buf.Write([]byte{major | 24, byte(arg)})
+	case arg <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		binary.Write(buf, binary.BigEndian, uint16(arg))
+	case arg <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		binary.Write(buf, binary.BigEndian, uint32(arg))
+	default:
+		buf.WriteByte(major | 27)
+		binary.Write(buf, binary.BigEndian, arg)
+	}
+}
+
+// cborHalfToFloat returns the value of a half precision float.
+func cborHalfToFloat(half uint16) float64 {
+	exp, mant := int(half>>10&0x1f), float64(half&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 0x1f:
+		f = math.Inf(1)
+		if mant != 0 {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if half&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
+
+// cborFloatToHalf returns the half precision float of a finite float, if it
+// holds it exactly.
+func cborFloatToHalf(f float32) (uint16, bool) {
+	bits := math.Float32bits(f)
+	sign := uint16(bits >> 16 & 0x8000)
+	exp, mant := int(bits>>23&0xff)-127, bits&0x7fffff
+	switch {
+	case bits&0x7fffffff == 0:
+		return sign, true
+	case exp == 128 || exp < -24 || exp > 15:
+		return 0, false
+	case exp >= -14:
+		if mant&0x1fff != 0 {
+			return 0, false
+		}
+		return sign | uint16(exp+15)<<10 | uint16(mant>>13), true
+	}
+	// Subnormal halves are multiples of 2^-24.
+	significand, shift := mant|1<<23, uint(-exp-1)
+	if significand&(1<<shift-1) != 0 {
+		return 0, false
+	}
+	return sign | uint16(significand>>shift), true
+}
+
+func init() {
+	Register("cbor", CBOREncoding{})
+}
@@ -0,0 +1,175 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCBORMarshal(t *testing.T) {
+	var table = []struct {
+		input  string
+		output []string
+	}{
+		{"00", []string{`0`}},
+		{"0102", []string{`1`, `2`}},
+		{"", nil},
+		{"1bffffffffffffffff3903e73bffffffffffffffff", []string{`18446744073709551615`, `-1000`, `-18446744073709551616`}},
+		{"c249010000000000000000c349010000000000000000", []string{`{"$cbor_tag":2,"value":{"$cbor_bytes":"AQAAAAAAAAAA"}}`, `{"$cbor_tag":3,"value":{"$cbor_bytes":"AQAAAAAAAAAA"}}`}},
+		{"c243010000c3471fffffffffffffc34720000000000001", []string{`65536`, `-9007199254740992`, `{"$cbor_tag":3,"value":{"$cbor_bytes":"IAAAAAAAAQ=="}}`}},
+		{"f93c00fb3ff199999999999afa47c35000f90001", []string{`1.0`, `1.1`, `100000.0`, `5.9604645e-08`}},
+		{"f97c00f97e00f9fc00", []string{`"+Inf"`, `"NaN"`, `"-Inf"`}},
+		{"f4f5f6f7f0f8ff", []string{`false`, `true`, `null`, `{"$cbor_simple":23}`, `{"$cbor_simple":16}`, `{"$cbor_simple":255}`}},
+		{"c074323031332d30332d32315432303a30343a30305a", []string{`{"$cbor_tag":0,"value":"2013-03-21T20:04:00Z"}`}},
+		{"c11a514b67b0d74401020304", []string{`{"$cbor_tag":1,"value":1363896240}`, `{"$cbor_tag":23,"value":{"$cbor_bytes":"AQIDBA=="}}`}},
+		{"406449455446", []string{`{"$cbor_bytes":""}`, `"IETF"`}},
+		{"83010203a201020304", []string{`[1,2,3]`, `{"1":2,"3":4}`}},
+		{"5f42010243030405ff7f657374726561646d696e67ff", []string{`{"$cbor_bytes":"AQIDBAU="}`, `"streaming"`}},
+		{"9f018202039f0405ffffbf61610161629f0203ffff", []string{`[1,[2,3],[4,5]]`, `{"a":1,"b":[2,3]}`}},
+	}
+
+	for _, tt := range table {
+		input, _ := hex.DecodeString(tt.input)
+		decoder := CBOREncoding{}.NewDecoder(bytes.NewReader(input))
+
+		var output []string
+		for {
+			outputBytes, err := decoder.MarshalJSONBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error decoding %s: %s", tt.input, err)
+			}
+			output = append(output, string(outputBytes))
+		}
+		if !reflect.DeepEqual(output, tt.output) {
+			t.Errorf("unexpected output decoding %s: %q instead of %q", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestCBORMarshalError(t *testing.T) {
+	var table = []struct {
+		input string
+		err   string
+	}{
+		{"8201", "unexpected EOF"},
+		{"ff", "cbor: unexpected break"},
+		{"1c", "cbor: invalid additional information 28"},
+		{"5f01ff", "cbor: invalid chunk of an indefinite length string"},
+	}
+
+	for _, tt := range table {
+		input, _ := hex.DecodeString(tt.input)
+		_, err := CBOREncoding{}.NewDecoder(bytes.NewReader(input)).MarshalJSONBytes()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error decoding %s: %v instead of %s", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestCBORUnmarshal(t *testing.T) {
+	var table = []struct {
+		input  []string
+		output string
+	}{
+		{[]string{`[1,[2,3],{"a":-1000}]`}, "8301820203a161613903e7"},
+		{[]string{`1`, `2`}, "0102"},
+		{[]string{`["IETF",null,true,false]`}, "846449455446f6f5f4"},
+		{[]string{`18446744073709551616`, `-18446744073709551617`, `-18446744073709551616`}, "c249010000000000000000c3490100000000000000003bffffffffffffffff"},
+		{[]string{`[1.0,1.1,100000.0,5.960464477539063e-8,65504.0,-4.1]`}, "86f93c00fb3ff199999999999afa47c35000f90001f97bfffbc010666666666666"},
+		{[]string{`{"$cbor_tag":0,"value":"x"}`, `{"$cbor_bytes":"AQID"}`, `{"$cbor_simple":23}`, `{"$cbor_simple":255}`}, "c0617843010203f7f8ff"},
+		{[]string{`{"$cbor_bytes":"AQID","other":1}`}, "a26b2463626f725f62797465736441514944656f7468657201"},
+	}
+
+	for _, tt := range table {
+		var buf bytes.Buffer
+		encoder := CBOREncoding{}.NewEncoder(&buf)
+		for _, input := range tt.input {
+			if err := encoder.UnmarshalJSONBytes([]byte(input), false, true); err != nil {
+				t.Fatalf("unexpected error encoding %s: %s", input, err)
+			}
+		}
+		if output := hex.EncodeToString(buf.Bytes()); output != tt.output {
+			t.Errorf("unexpected output encoding %s: %s instead of %s", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestCBORUnmarshalInvalidObject(t *testing.T) {
+	for _, input := range []string{`{"$cbor_bytes":1}`, `{"$cbor_tag":-1,"value":1}`, `{"$cbor_simple":25}`} {
+		err := CBOREncoding{}.NewEncoder(&bytes.Buffer{}).UnmarshalJSONBytes([]byte(input), false, false)
+		if err == nil {
+			t.Errorf("expected an error encoding %s", input)
+		}
+	}
+}
+
+func TestCBORRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		"1bffffffffffffffff",
+		"c249010000000000000000",
+		"c34720000000000001",
+		"f97bfffa47c35000fb3ff199999999999a",
+		"c074323031332d30332d32315432303a30343a30305a",
+		"d74401020304",
+		"f7f0f8ff",
+	} {
+		data, _ := hex.DecodeString(input)
+		decoder := CBOREncoding{}.NewDecoder(bytes.NewReader(data))
+		var buf bytes.Buffer
+		encoder := CBOREncoding{}.NewEncoder(&buf)
+		for {
+			value, err := decoder.MarshalJSONBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error decoding %s: %s", input, err)
+			}
+			if err := encoder.UnmarshalJSONBytes(value, false, false); err != nil {
+				t.Fatalf("unexpected error encoding %s: %s", value, err)
+			}
+		}
+		if output := hex.EncodeToString(buf.Bytes()); output != input {
+			t.Errorf("unexpected round trip of %s: %s", input, output)
+		}
+	}
+}
+
+func TestCBORDiagnostic(t *testing.T) {
+	var table = []struct {
+		input  string
+		output string
+	}{
+		{
+			`{"a":[1,{"$cbor_bytes":"AQI="}],"b":{"$cbor_tag":0,"value":"2013-03-21T20:04:00Z"},"c":[],"d":{"$cbor_simple":23},"e":1.5}`,
+			"{\n  \"a\": [\n    1,\n    h'0102'\n  ],\n  \"b\": 0(\"2013-03-21T20:04:00Z\"),\n  \"c\": [],\n  \"d\": undefined,\n  \"e\": 1.5\n}\n",
+		},
+		{`-18446744073709551617`, "3(h'010000000000000000')\n"},
+		{`[null,true,{"$cbor_simple":16}]`, "[\n  null,\n  true,\n  simple(16)\n]\n"},
+	}
+
+	for _, tt := range table {
+		var buf bytes.Buffer
+		encoder := CBOREncoding{Diagnostic: true}.NewEncoder(&buf)
+		if err := encoder.UnmarshalJSONBytes([]byte(tt.input), false, true); err != nil {
+			t.Fatalf("unexpected error encoding %s: %s", tt.input, err)
+		}
+		if output := buf.String(); output != tt.output {
+			t.Errorf("unexpected output encoding %s:\n%s\ninstead of:\n%s", tt.input, output, tt.output)
+		}
+	}
+
+	// Items that aren't pretty-printed are written in binary.
+	var buf bytes.Buffer
+	if err := (CBOREncoding{Diagnostic: true}).NewEncoder(&buf).UnmarshalJSONBytes([]byte(`[1]`), false, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if output := hex.EncodeToString(buf.Bytes()); output != "8101" {
+		t.Errorf("unexpected output encoding [1] without pretty printing: %s", output)
+	}
+}
@@ -0,0 +1,311 @@
+package objconv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	_ Encoding = CSVEncoding{}
+	_ Decoder  = &csvDecoder{}
+	_ Encoder  = &csvEncoder{}
+)
+
+// CSVQuoting is how the fields of a CSVEncoding are quoted.
+type CSVQuoting int
+
+const (
+	// CSVQuoteMinimal quotes the fields that contain the delimiter, quotes,
+	// newlines or leading whitespace.
+	CSVQuoteMinimal CSVQuoting = iota
+	// CSVQuoteAll quotes every field.
+	CSVQuoteAll
+	// CSVQuoteNone doesn't quote fields, which then can't contain the
+	// delimiter or newlines. Quotes are read as ordinary characters.
+	CSVQuoteNone
+)
+
+// CSVEncoding is a table of values separated by commas, or by another
+// delimiter, such as tab-separated values.
+//
+// Its decoder returns each row as an object keyed by the names of the header
+// row, which is the first row, or as an array of its fields if NoHeader is
+// set. Fields are always strings. When a header has the same name more than
+// once, the later columns are named with a suffix, such as "name_2".
+//
+// Its encoder writes arrays of objects as rows, with a column for every key
+// of the objects in the order they first appear, and objects on their own as
+// single rows. A single header is written before the first row, and the
+// fields of the columns a row doesn't have are empty. Since the columns are
+// fixed once the header is written, the objects of later values can't have
+// keys that aren't columns. Arrays of scalars are written as rows as they
+// are. Null is written as an empty field, and objects and arrays within rows
+// as compact JSON.
+type CSVEncoding struct {
+	// Delimiter separates the fields of a row. If it's zero, ',' is used.
+	Delimiter rune
+	// NoHeader reads rows as arrays rather than as objects keyed by a header
+	// row, and writes no header row.
+	NoHeader bool
+	// Quote is how fields are quoted.
+	Quote CSVQuoting
+}
+
+func (e CSVEncoding) delimiter() rune {
+	if e.Delimiter == 0 {
+		return ','
+	}
+	return e.Delimiter
+}
+
+// NewDecoder implements Encoding.
+func (e CSVEncoding) NewDecoder(r io.Reader) Decoder {
+	d := &csvDecoder{encoding: e, r: bufio.NewReader(r)}
+	if e.Quote != CSVQuoteNone {
+		d.csv = csv.NewReader(d.r)
+		d.csv.Comma = e.delimiter()
+	}
+	return d
+}
+
+// NewEncoder implements Encoding.
+func (e CSVEncoding) NewEncoder(w io.Writer) Encoder {
+	return &csvEncoder{encoding: e, w: w}
+}
+
+type csvDecoder struct {
+	encoding CSVEncoding
+	r        *bufio.Reader
+	csv      *csv.Reader
+	started  bool
+	header   []string
+}
+
+func (d *csvDecoder) MarshalJSONBytes() ([]byte, error) {
+	if !d.started {
+		d.started = true
+		// Spreadsheets often start exports with a byte order mark.
+		if bom, err := d.r.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+			d.r.Discard(3)
+		}
+		if !d.encoding.NoHeader {
+			header, err := d.readRecord()
+			if err != nil {
+				return nil, err
+			}
+			d.header = csvColumnNames(header)
+		}
+	}
+
+	record, err := d.readRecord()
+	if err != nil {
+		return nil, err
+	}
+	if d.encoding.NoHeader {
+		return marshalJSONValue(record)
+	}
+	if len(record) != len(d.header) {
+		return nil, fmt.Errorf("csv: row has %d fields but the header has %d", len(record), len(d.header))
+	}
+	row := make(orderedObject, len(record))
+	for i, field := range record {
+		row[i] = orderedField{d.header[i], field}
+	}
+	return marshalJSONValue(row)
+}
+
+// readRecord reads the fields of a row.
+func (d *csvDecoder) readRecord() ([]string, error) {
+	if d.csv != nil {
+		return d.csv.Read()
+	}
+
+	for {
+		line, err := d.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		// Empty lines are skipped, as they are with quoting.
+		if line != "" {
+			return strings.Split(line, string(d.encoding.delimiter())), nil
+		}
+	}
+}
+
+// csvColumnNames returns the names of the columns of a header, adding a
+// suffix to the names that repeat an earlier one.
+func csvColumnNames(header []string) []string {
+	names := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		unique := name
+		for n := 2; seen[unique]; n++ {
+			unique = name + "_" + strconv.Itoa(n)
+		}
+		seen[unique] = true
+		names[i] = unique
+	}
+	return names
+}
+
+type csvEncoder struct {
+	encoding CSVEncoding
+	w        io.Writer
+	// columns are the columns of the rows written so far, and header is set
+	// once they've been written as the header.
+	columns []string
+	header  bool
+}
+
+func (e *csvEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
+	value, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return err
+	}
+
+	var rows []interface{}
+	switch value := value.(type) {
+	case []interface{}:
+		if isCSVRow(value) {
+			rows = []interface{}{value}
+		} else {
+			rows = value
+		}
+	default:
+		rows = []interface{}{value}
+	}
+
+	if err := e.addColumns(rows); err != nil {
+		return fmt.Errorf("failed to encode as: %s", err)
+	}
+	var buf bytes.Buffer
+	if len(e.columns) > 0 && !e.header && !e.encoding.NoHeader {
+		e.header = true
+		header := make([]interface{}, len(e.columns))
+		for i, column := range e.columns {
+			header[i] = column
+		}
+		if err := e.writeFields(&buf, header); err != nil {
+			return fmt.Errorf("failed to encode as: %s", err)
+		}
+	}
+	for _, row := range rows {
+		if err := e.writeRow(&buf, row); err != nil {
+			return fmt.Errorf("failed to encode as: %s", err)
+		}
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// addColumns adds the keys of the objects of rows that aren't columns to the
+// columns, unless the header has already been written.
+func (e *csvEncoder) addColumns(rows []interface{}) error {
+	for _, row := range rows {
+		obj, ok := row.(orderedObject)
+		if !ok {
+			continue
+		}
+		for _, field := range obj {
+			if containsString(e.columns, field.key) {
+				continue
+			}
+			if e.header {
+				return fmt.Errorf("csv: %q isn't a column of the header, which was written before it; write the rows as one array", field.key)
+			}
+			e.columns = append(e.columns, field.key)
+		}
+	}
+	return nil
+}
+
+// isCSVRow reports whether an array is a row rather than an array of rows.
+func isCSVRow(array []interface{}) bool {
+	for _, elem := range array {
+		switch elem.(type) {
+		case orderedObject, []interface{}:
+			return false
+		}
+	}
+	return true
+}
+
+// writeRow writes a row. The fields of an object are written in the order of
+// the columns.
+func (e *csvEncoder) writeRow(buf *bytes.Buffer, row interface{}) error {
+	var fields []interface{}
+	switch row := row.(type) {
+	case orderedObject:
+		fields = make([]interface{}, len(e.columns))
+		for i, column := range e.columns {
+			fields[i], _ = row.get(column)
+		}
+	case []interface{}:
+		fields = row
+	default:
+		fields = []interface{}{row}
+	}
+	return e.writeFields(buf, fields)
+}
+
+var errCSVUnquotable = errors.New("csv: fields cannot contain the delimiter or newlines without quoting")
+
+// writeFields writes the fields of a row.
+func (e *csvEncoder) writeFields(buf *bytes.Buffer, fields []interface{}) error {
+	delimiter := string(e.encoding.delimiter())
+	for i, value := range fields {
+		if i > 0 {
+			buf.WriteString(delimiter)
+		}
+
+		var field string
+		switch value := value.(type) {
+		case nil:
+		case string:
+			field = value
+		case json.Number:
+			field = string(value)
+		case bool:
+			field = strconv.FormatBool(value)
+		default:
+			data, err := marshalJSONValue(value)
+			if err != nil {
+				return err
+			}
+			field = string(data)
+		}
+
+		switch e.encoding.Quote {
+		case CSVQuoteNone:
+			if strings.ContainsAny(field, delimiter+"\r\n") {
+				return errCSVUnquotable
+			}
+		case CSVQuoteMinimal:
+			if !strings.ContainsAny(field, delimiter+"\"\r\n") && !strings.HasPrefix(field, " ") && !strings.HasPrefix(field, "\t") {
+				break
+			}
+			fallthrough
+		case CSVQuoteAll:
+			field = `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+		}
+		buf.WriteString(field)
+	}
+	buf.WriteByte('\n')
+	return nil
+}
+
+func init() {
+	Register("csv", CSVEncoding{})
+	Register("tsv", CSVEncoding{Delimiter: '\t'})
+}
@@ -0,0 +1,138 @@
+package objconv
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVMarshal(t *testing.T) {
+	var table = []struct {
+		encoding CSVEncoding
+		input    string
+		output   []string
+	}{
+		{CSVEncoding{}, "name,age\nann,30\n\"b, \"\"c\"\"\",\n", []string{`{"name":"ann","age":"30"}`, `{"name":"b, \"c\"","age":""}`}},
+		{CSVEncoding{}, "\xef\xbb\xbfa,a,b,a\r\n1,2,3,4\r\n", []string{`{"a":"1","a_2":"2","b":"3","a_3":"4"}`}},
+		{CSVEncoding{}, "a,b\n", nil},
+		{CSVEncoding{}, "", nil},
+		{CSVEncoding{NoHeader: true}, "a,b\n\n1,2\n", []string{`["a","b"]`, `["1","2"]`}},
+		{CSVEncoding{Delimiter: '\t'}, "a\tb\nx y\t\"z\"\n", []string{`{"a":"x y","b":"z"}`}},
+		{CSVEncoding{Delimiter: '\t', Quote: CSVQuoteNone}, "a\tb\n\"x\t\"y\n", []string{`{"a":"\"x","b":"\"y"}`}},
+	}
+
+	for _, tt := range table {
+		decoder := tt.encoding.NewDecoder(strings.NewReader(tt.input))
+
+		var output []string
+		for {
+			outputBytes, err := decoder.MarshalJSONBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error decoding %q: %s", tt.input, err)
+			}
+			output = append(output, string(outputBytes))
+		}
+		if !reflect.DeepEqual(output, tt.output) {
+			t.Errorf("unexpected output decoding %q: %q instead of %q", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestCSVMarshalError(t *testing.T) {
+	for _, input := range []string{"a,b\n1\n", "a\n\"1\n", "a,b\n1,2,3\n"} {
+		decoder := CSVEncoding{}.NewDecoder(strings.NewReader(input))
+		var err error
+		for err == nil {
+			_, err = decoder.MarshalJSONBytes()
+		}
+		if err == io.EOF {
+			t.Errorf("expected an error decoding %q", input)
+		}
+	}
+}
+
+func TestCSVUnmarshal(t *testing.T) {
+	var table = []struct {
+		encoding CSVEncoding
+		input    []string
+		output   string
+	}{
+		{CSVEncoding{}, []string{`[{"a":1,"b":"x, y"},{"b":null,"a":true}]`}, "a,b\n1,\"x, y\"\ntrue,\n"},
+		{CSVEncoding{}, []string{`[{"a":1},{"a":2,"b":[1,{"c":"d"}]}]`}, "a,b\n1,\n2,\"[1,{\"\"c\"\":\"\"d\"\"}]\"\n"},
+		{CSVEncoding{}, []string{`{"a":1,"b":2}`, `{"b":3}`}, "a,b\n1,2\n,3\n"},
+		{CSVEncoding{}, []string{`[[1,"a"],[" b","c\nd"]]`, `["e",1.50]`, `"f"`}, "1,a\n\" b\",\"c\nd\"\ne,1.50\nf\n"},
+		{CSVEncoding{NoHeader: true}, []string{`[{"a":1},{"b":2}]`}, "1,\n,2\n"},
+		{CSVEncoding{Delimiter: '\t'}, []string{`[{"a":"x,y","b":"1\t2"}]`}, "a\tb\nx,y\t\"1\t2\"\n"},
+		{CSVEncoding{Quote: CSVQuoteAll}, []string{`[{"a":1,"b":"\"q\""}]`}, "\"a\",\"b\"\n\"1\",\"\"\"q\"\"\"\n"},
+		{CSVEncoding{Quote: CSVQuoteNone}, []string{`[{"a":"\"q\""," b":"c"}]`}, "a, b\n\"q\",c\n"},
+	}
+
+	for _, tt := range table {
+		var buf bytes.Buffer
+		encoder := tt.encoding.NewEncoder(&buf)
+		for _, input := range tt.input {
+			if err := encoder.UnmarshalJSONBytes([]byte(input), false, false); err != nil {
+				t.Fatalf("unexpected error encoding %s: %s", input, err)
+			}
+		}
+		if output := buf.String(); output != tt.output {
+			t.Errorf("unexpected output encoding %s: %q instead of %q", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestCSVUnmarshalUnquotable(t *testing.T) {
+	encoder := CSVEncoding{Quote: CSVQuoteNone}.NewEncoder(&bytes.Buffer{})
+	if err := encoder.UnmarshalJSONBytes([]byte(`{"a":"b,c"}`), false, false); err == nil {
+		t.Error("expected an error encoding a field containing the delimiter without quoting")
+	}
+}
+
+func TestCSVUnmarshalNewColumn(t *testing.T) {
+	var buf bytes.Buffer
+	encoder := CSVEncoding{}.NewEncoder(&buf)
+	if err := encoder.UnmarshalJSONBytes([]byte(`{"a":1}`), false, false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := encoder.UnmarshalJSONBytes([]byte(`{"a":2,"b":3}`), false, false); err == nil {
+		t.Error("expected an error encoding a key that isn't a column after the header")
+	}
+	if output := buf.String(); output != "a\n1\n" {
+		t.Errorf("unexpected output: %q", output)
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	for _, name := range []string{"csv", "tsv"} {
+		encoding, ok := ByName(name)
+		if !ok {
+			t.Fatalf("%s isn't registered", name)
+		}
+		input := `[{"id":"1","note":"multi\nline, \"quoted\""},{"id":"2","note":" padded"}]`
+
+		var buf bytes.Buffer
+		if err := encoding.NewEncoder(&buf).UnmarshalJSONBytes([]byte(input), false, false); err != nil {
+			t.Fatalf("unexpected error encoding %s: %s", name, err)
+		}
+		decoder := encoding.NewDecoder(&buf)
+		var rows []string
+		for {
+			row, err := decoder.MarshalJSONBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error decoding %s: %s", name, err)
+			}
+			rows = append(rows, string(row))
+		}
+		if output := "[" + strings.Join(rows, ",") + "]"; output != input {
+			t.Errorf("unexpected %s round trip: %s instead of %s", name, output, input)
+		}
+	}
+}
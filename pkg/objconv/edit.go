@@ -0,0 +1,160 @@
+package objconv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+)
+
+// Editor is implemented by Encodings that can write values as edits of
+// documents in the encoding.
+//
+// An edit keeps the comments and formatting of the parts of a document whose
+// values are unchanged and only rewrites the values that changed.
+type Editor interface {
+	// NewEditEncoder returns an EditEncoder that writes values as edits of
+	// the documents of original, the contents of a file in the encoding.
+	//
+	// A value that cannot be written as an edit of its document is an
+	// *EditError, unless reencode is set, in which case it's encoded as
+	// usual, losing the comments and formatting of the document.
+	NewEditEncoder(w io.Writer, original []byte, reencode bool) EditEncoder
+}
+
+// EditEncoder writes values as edits of the documents they came from.
+type EditEncoder interface {
+	// EditJSONBytes writes jsonBytes as an edit of the document of the
+	// original contents at index document, which is the one it came from.
+	//
+	// Documents must be edited in order and at most once, so a value that
+	// follows a value of the same or a later document can't be an edit, and
+	// neither can a value that didn't come from exactly one document, which
+	// is given a negative index. Values of documents beyond those of the
+	// original contents are encoded as usual.
+	EditJSONBytes(document int, jsonBytes []byte, color, pretty bool) error
+}
+
+// EditError is returned by the Encoders of Editors for a value that cannot be
+// written as an edit of its document.
+type EditError struct {
+	// Document is the index of the document in the original contents, or
+	// -1 if the value didn't come from exactly one of them.
+	Document int
+	Err      error
+}
+
+func (e *EditError) Error() string {
+	if e.Document < 0 {
+		return fmt.Sprintf("result can't be written as an edit: %s", e.Err)
+	}
+	return fmt.Sprintf("document %d can't be edited: %s", e.Document+1, e.Err)
+}
+
+func (e *EditError) Unwrap() error {
+	return e.Err
+}
+
+// checkEditable returns the *EditError for a value of document, given the
+// index of the last document that was edited, or nil if the value can be
+// written as an edit of document.
+func checkEditable(document, last int) error {
+	switch {
+	case document < 0:
+		return &EditError{Document: -1, Err: errNoDocument}
+	case document <= last:
+		return &EditError{Document: document, Err: errEditedTwice}
+	}
+	return nil
+}
+
+// errNoDocument is the reason a value can't be an edit when it didn't come
+// from exactly one document, such as when the program read others with input.
+var errNoDocument = errors.New("it didn't come from exactly one document")
+
+// errEditedTwice is the reason a document can't be edited when a value was
+// already written as an edit of it or of a document that follows it.
+var errEditedTwice = errors.New("the program produced more than one result for it, or results out of order")
+
+// errEditMismatch is the reason a document can't be edited when no edits
+// that give it its new value were found.
+var errEditMismatch = errors.New("its new value can't be written as edits of it")
+
+// textEdit replaces the bytes of a document from start to end with text.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+// applyEdits returns src with edits applied. Edits must not overlap, and
+// insertions at the same offset are applied in the order they were made.
+func applyEdits(src []byte, edits []textEdit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		return edits[i].end == edits[i].start && edits[j].end != edits[j].start
+	})
+
+	out := make([]byte, 0, len(src))
+	last := 0
+	for _, edit := range edits {
+		out = append(out, src[last:edit.start]...)
+		out = append(out, edit.text...)
+		last = edit.end
+	}
+	return append(out, src[last:]...)
+}
+
+// valuesEqual reports whether two values decoded by decodeOrderedJSON are
+// equal, regardless of the order of the keys of their objects.
+func valuesEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case orderedObject:
+		b, ok := b.(orderedObject)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for _, field := range a {
+			value, ok := b.get(field.key)
+			if !ok || !valuesEqual(field.value, value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valuesEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		b, ok := b.(json.Number)
+		return ok && numbersEqual(a, b)
+	}
+	return a == b
+}
+
+// numbersEqual reports whether two numbers have the same value, or the same
+// value as a float64, which is how jq engines hold most numbers: a number a
+// program passes through unchanged may come back as 1.5 rather than 1.50.
+func numbersEqual(a, b json.Number) bool {
+	if a == b {
+		return true
+	}
+	x, _, errX := big.ParseFloat(string(a), 10, 1024, big.ToNearestEven)
+	y, _, errY := big.ParseFloat(string(b), 10, 1024, big.ToNearestEven)
+	if errX == nil && errY == nil && x.Cmp(y) == 0 {
+		return true
+	}
+	fa, errA := a.Float64()
+	fb, errB := b.Float64()
+	return errA == nil && errB == nil && fa == fb
+}
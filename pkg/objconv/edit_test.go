@@ -0,0 +1,228 @@
+package objconv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEdit(t *testing.T) {
+	var table = []struct {
+		name     string
+		original string
+		values   []string
+		expected string
+	}{
+		{
+			"yaml",
+			"# config\nname: web # the name\nreplicas: 2\n",
+			[]string{`{"name":"web","replicas":3}`},
+			"# config\nname: web # the name\nreplicas: 3\n",
+		},
+		{
+			"yaml",
+			"a: 'single' # kept\nb: [1, 2]\nc: {x: 1}\n",
+			[]string{`{"a":"changed","b":[1,2,3],"c":{"x":2}}`},
+			"a: 'changed' # kept\nb: [1, 2, 3]\nc: {x: 2}\n",
+		},
+		{
+			"yaml",
+			"ports:\n  - 80 # http\n  # legacy\n  - 8080\n  - 443 # https\nenv:\n  # debugging\n  DEBUG: \"false\"\n  LEVEL: warn\n",
+			[]string{`{"ports":[22,80,443],"env":{"LEVEL":"info","NEW":{"on":"yes"}}}`},
+			"ports:\n  - 22\n  - 80 # http\n  - 443 # https\nenv:\n  LEVEL: info\n  NEW:\n    \"on\": \"yes\"\n",
+		},
+		{
+			"yaml",
+			"base: &base\n  x: 1\nd:\n  <<: *base\n  z: 1.50 # exact\n",
+			[]string{`{"base":{"x":1},"d":{"x":1,"z":1.5,"w":2}}`},
+			"base: &base\n  x: 1\nd:\n  <<: *base\n  z: 1.50 # exact\n  w: 2\n",
+		},
+		{
+			"yaml",
+			"# first\na: 1\n---\n# second\nb: 2 # two\n",
+			[]string{`{"a":1}`, `{"b":3}`, `{"c":4}`},
+			"# first\na: 1\n---\n# second\nb: 3 # two\n---\nc: 4\n",
+		},
+		{
+			"toml",
+			"# app\ntitle = \"x\" # the title\nname = 'literal'\n\n# database\n[db]\nport = 5432 # pg\nhosts = [\n  \"a\", # first\n  \"b\",\n]\n\n[old]\ngone = true\n",
+			[]string{`{"title":"x","name":"other","db":{"port":5433,"hosts":["a","b"],"user":"me"}}`},
+			"# app\ntitle = \"x\" # the title\nname = 'other'\n\n# database\n[db]\nport = 5433 # pg\nhosts = [\n  \"a\", # first\n  \"b\",\n]\nuser = \"me\"\n",
+		},
+		{
+			"toml",
+			"[[plugin]]\nname = \"x\" # first\n\n[[plugin]]\nname = \"y\"\n[plugin.opts]\nv = 1\n",
+			[]string{`{"plugin":[{"name":"x"},{"name":"y","opts":{"v":2}},{"name":"z"}],"extra":{"k":"v"}}`},
+			"[[plugin]]\nname = \"x\" # first\n\n[[plugin]]\nname = \"y\"\n[plugin.opts]\nv = 2\n\n[[plugin]]\nname = \"z\"\n\n[extra]\nk = \"v\"\n",
+		},
+		{
+			// Values that can't be edits are encoded as usual when
+			// reencode is set.
+			"toml",
+			"[a]\nx = 1\n",
+			[]string{`{"a":1}`},
+			"a = 1\n\n",
+		},
+		{
+			"yaml",
+			"on: push # trigger\nname: ci\nyes: 1\n",
+			[]string{`{"true":1,"name":"ci2"}`},
+			"\"true\": 1\nname: ci2\n",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			var buf bytes.Buffer
+			encoder := encoding.(Editor).NewEditEncoder(&buf, []byte(tt.original), true)
+			for i, value := range tt.values {
+				if err := encoder.EditJSONBytes(i, []byte(value), false, false); err != nil {
+					t.Fatalf("unexpected error encoding: %s", err)
+				}
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("unexpected output:\nexpected: %q\ngot:      %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestEditError(t *testing.T) {
+	var table = []struct {
+		name     string
+		original string
+		values   []string
+		err      string
+	}{
+		{
+			"yaml",
+			"on: push # trigger\nname: ci\nyes: 1\n",
+			[]string{`{"true":1,"name":"ci2"}`},
+			"document 1 can't be edited: line 3: the keys on and yes are both decoded as \"true\"",
+		},
+		{
+			"yaml",
+			"derived:\n  y: 2 # kept\n",
+			[]string{`{"derived":{"true":2,"y":3}}`},
+			"document 1 can't be edited: line 2: the key \"y\" would be a duplicate of y, which is decoded as \"true\"",
+		},
+		{
+			"yaml",
+			"base: &base\n  x: 1\nd:\n  <<: *base\n",
+			[]string{`{"base":{"x":5},"d":{"x":1}}`},
+			"document 1 can't be edited: line 1: the value is shared through an anchor and its aliases",
+		},
+		{
+			"yaml",
+			"a: 1\n---\nb: &b {x: 1}\nc: *b\n",
+			[]string{`{"a":1}`, `{"b":{"x":1},"c":{"x":2}}`},
+			"document 2 can't be edited: line 4: the value is shared through an anchor and its aliases",
+		},
+		{
+			"toml",
+			"[a]\nx = 1\n",
+			[]string{`{"a":1}`},
+			"document 1 can't be edited: its new value can't be written as edits of it",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			var buf bytes.Buffer
+			encoder := encoding.(Editor).NewEditEncoder(&buf, []byte(tt.original), false)
+			last := len(tt.values) - 1
+			for i, value := range tt.values[:last] {
+				if err := encoder.EditJSONBytes(i, []byte(value), false, false); err != nil {
+					t.Fatalf("unexpected error encoding: %s", err)
+				}
+			}
+			written := buf.Len()
+			err := encoder.EditJSONBytes(last, []byte(tt.values[last]), false, false)
+			var editErr *EditError
+			if !errors.As(err, &editErr) || err.Error() != tt.err {
+				t.Fatalf("unexpected error: %v instead of %s", err, tt.err)
+			}
+			if buf.Len() != written {
+				t.Errorf("unexpected output after the error: %q", buf.Bytes()[written:])
+			}
+		})
+	}
+}
+
+func TestEditDocuments(t *testing.T) {
+	var table = []struct {
+		name      string
+		original  string
+		documents []int
+		values    []string
+		expected  string
+		err       string
+	}{
+		{
+			"yaml",
+			"# first\na: 1\n---\n# second\nb: 2 # two\n",
+			[]int{1},
+			[]string{`{"b":3}`},
+			"---\n# second\nb: 3 # two\n",
+			"",
+		},
+		{
+			"yaml",
+			"a: 1 # one\n---\nb: 2\n",
+			[]int{0, 0},
+			[]string{`{"a":1}`, `{"a":1}`},
+			"a: 1 # one\n",
+			"document 1 can't be edited: the program produced more than one result for it, or results out of order",
+		},
+		{
+			"yaml",
+			"a: 1 # one\n---\nb: 2\n",
+			[]int{1, 0},
+			[]string{`{"b":2}`, `{"a":1}`},
+			"---\nb: 2\n",
+			"document 1 can't be edited: the program produced more than one result for it, or results out of order",
+		},
+		{
+			"yaml",
+			"a: 1 # one\n",
+			[]int{-1},
+			[]string{`{"a":1}`},
+			"",
+			"result can't be written as an edit: it didn't come from exactly one document",
+		},
+		{
+			"toml",
+			"a = 1 # one\n",
+			[]int{0, 0},
+			[]string{`{"a":1}`, `{"a":2}`},
+			"a = 1 # one\n",
+			"document 1 can't be edited: the program produced more than one result for it, or results out of order",
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			var buf bytes.Buffer
+			encoder := encoding.(Editor).NewEditEncoder(&buf, []byte(tt.original), false)
+			var err error
+			for i, value := range tt.values {
+				if err = encoder.EditJSONBytes(tt.documents[i], []byte(value), false, false); err != nil {
+					break
+				}
+			}
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error encoding: %s", err)
+			}
+			var editErr *EditError
+			if tt.err != "" && (!errors.As(err, &editErr) || err.Error() != tt.err) {
+				t.Fatalf("unexpected error: %v instead of %s", err, tt.err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("unexpected output:\nexpected: %q\ngot:      %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
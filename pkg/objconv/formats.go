@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 )
 
@@ -39,6 +40,16 @@ func ByName(name string) (Encoding, bool) {
 	return format, ok
 }
 
+// Names returns the sorted names of every registered Encoding.
+func Names() []string {
+	names := make([]string, 0, len(nameToFormat))
+	for name := range nameToFormat {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // ToName maps an encoder to a registered encoding name.
 func ToName(format Encoding) string {
 	for name, f := range nameToFormat {
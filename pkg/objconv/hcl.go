@@ -0,0 +1,662 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var (
+	_ Encoding = hclEncoding{}
+	_ Decoder  = &hclDecoder{}
+	_ Encoder  = &hclEncoder{}
+)
+
+// hclEncoding is HCL2's native syntax, as used by Terraform and Nomad. Files
+// are parsed with hclsyntax and written with hclwrite, in the style of
+// terraform fmt.
+//
+// Its decoder returns a file's body as an object in Terraform's JSON syntax.
+// Attributes are keys of the object. Blocks are keys too, whose values are
+// their bodies nested in an object for each of their labels, so that
+//
+//	resource "aws_instance" "web" {
+//	  ami = "ami-123"
+//	}
+//
+// is decoded as {"resource": {"aws_instance": {"web": {"ami": "ami-123"}}}}.
+// Blocks of the same type and labels are decoded as an array of their bodies,
+// and so are the blocks that providers define within resources, data sources
+// and providers, such as timeouts, even if there's only one of them, which
+// tells them apart from attributes whose values are objects, such as tags.
+// Expressions that are literals, such as strings, numbers, tuples and objects,
+// are decoded as their JSON values, with numbers kept as they're written, and
+// other expressions as strings of templates interpolating them, such as
+// "${var.name}". Strings are templates too, so their interpolations and
+// escapes such as "$${" are kept as written.
+//
+// Its encoder writes the attributes of an object, and writes the keys that
+// are block types Terraform or Nomad define, such as resource or job, as
+// blocks, with the number of labels the block type takes. Within resources,
+// data sources and providers, arrays of objects are written as blocks too.
+// Strings that are a single interpolation are written as the expression they
+// interpolate. When blocks is false, such as for .tfvars files, every key is
+// an attribute.
+type hclEncoding struct {
+	blocks bool
+}
+
+func (hclEncoding) NewDecoder(r io.Reader) Decoder {
+	return &hclDecoder{r: r}
+}
+
+func (e hclEncoding) NewEncoder(w io.Writer) Encoder {
+	return &hclEncoder{w, e.blocks}
+}
+
+type hclDecoder struct {
+	r    io.Reader
+	read bool
+}
+
+func (d *hclDecoder) MarshalJSONBytes() ([]byte, error) {
+	if d.read {
+		return nil, io.EOF
+	}
+	src, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return nil, err
+	}
+	d.read = true
+
+	file, diags := hclsyntax.ParseConfig(src, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, hclError(diags[0])
+	}
+	body, err := hclBody(src, file.Body.(*hclsyntax.Body), "")
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSONValue(body)
+}
+
+// hclError returns the error of a diagnostic.
+func hclError(diag *hcl.Diagnostic) error {
+	if diag.Subject == nil {
+		return fmt.Errorf("hcl: %s", diag.Summary)
+	}
+	return fmt.Errorf("hcl: line %d: %s", diag.Subject.Start.Line, diag.Summary)
+}
+
+// hclBlock is a block of a body.
+type hclBlock struct {
+	labels []string
+	body   orderedObject
+}
+
+// hclBlocks are the blocks of a type in a body being decoded, which are
+// nested by their labels once the body has been decoded.
+type hclBlocks []hclBlock
+
+// hclBody returns the attributes and blocks of body, in the order they're
+// written in src. blockType is the type of the block it's the body of, as
+// writeBody takes it.
+func hclBody(src []byte, body *hclsyntax.Body, blockType string) (orderedObject, error) {
+	attributes := make([]*hclsyntax.Attribute, 0, len(body.Attributes))
+	for _, attribute := range body.Attributes {
+		attributes = append(attributes, attribute)
+	}
+	sort.Slice(attributes, func(i, j int) bool {
+		return attributes[i].SrcRange.Start.Byte < attributes[j].SrcRange.Start.Byte
+	})
+
+	obj := orderedObject{}
+	blocks := body.Blocks
+	for len(attributes) > 0 || len(blocks) > 0 {
+		if len(blocks) == 0 || len(attributes) > 0 && attributes[0].SrcRange.Start.Byte < blocks[0].TypeRange.Start.Byte {
+			attribute := attributes[0]
+			attributes = attributes[1:]
+			if _, ok := obj.get(attribute.Name); ok {
+				return nil, fmt.Errorf("hcl: line %d: %s is both an attribute and a block", attribute.NameRange.Start.Line, attribute.Name)
+			}
+			obj = append(obj, orderedField{attribute.Name, hclValue(src, attribute.Expr)})
+			continue
+		}
+
+		block := blocks[0]
+		blocks = blocks[1:]
+		nestedType := block.Type
+		if _, ok := hclBlockTypes[blockType][block.Type]; !ok && hclSchemaBlockTypes[blockType] {
+			nestedType = blockType
+		}
+		nested, err := hclBody(src, block.Body, nestedType)
+		if err != nil {
+			return nil, err
+		}
+		existing, ok := obj.get(block.Type)
+		if !ok {
+			obj = append(obj, orderedField{block.Type, hclBlocks{{block.Labels, nested}}})
+			continue
+		}
+		line := block.TypeRange.Start.Line
+		typeBlocks, isBlocks := existing.(hclBlocks)
+		switch {
+		case !isBlocks:
+			return nil, fmt.Errorf("hcl: line %d: %s is both an attribute and a block", line, block.Type)
+		case len(typeBlocks[0].labels) != len(block.Labels):
+			return nil, fmt.Errorf("hcl: line %d: blocks of type %s have different numbers of labels", line, block.Type)
+		}
+		obj = obj.set(block.Type, append(typeBlocks, hclBlock{block.Labels, nested}))
+	}
+
+	for i, field := range obj {
+		blocks, ok := field.value.(hclBlocks)
+		if !ok {
+			continue
+		}
+		obj[i].value = blocks.nest(0)
+		// Blocks that providers define are only written as blocks when
+		// they're arrays.
+		if body, ok := obj[i].value.(orderedObject); ok && len(blocks[0].labels) == 0 && hclSchemaBlockTypes[blockType] {
+			if _, ok := hclBlockTypes[blockType][field.key]; !ok {
+				obj[i].value = []interface{}{body}
+			}
+		}
+	}
+	return obj, nil
+}
+
+// nest returns the bodies of blocks nested in an object for each of their
+// labels from depth, or as an array if there are several with the same labels.
+func (blocks hclBlocks) nest(depth int) interface{} {
+	if depth == len(blocks[0].labels) {
+		if len(blocks) == 1 {
+			return blocks[0].body
+		}
+		bodies := make([]interface{}, len(blocks))
+		for i, block := range blocks {
+			bodies[i] = block.body
+		}
+		return bodies
+	}
+
+	var labels []string
+	byLabel := map[string]hclBlocks{}
+	for _, block := range blocks {
+		label := block.labels[depth]
+		if _, ok := byLabel[label]; !ok {
+			labels = append(labels, label)
+		}
+		byLabel[label] = append(byLabel[label], block)
+	}
+	obj := make(orderedObject, len(labels))
+	for i, label := range labels {
+		obj[i] = orderedField{label, byLabel[label].nest(depth + 1)}
+	}
+	return obj
+}
+
+// hclValue returns the JSON value of an expression written in src if it's a
+// literal, or otherwise a template interpolating it. Numbers are kept as
+// they're written.
+func hclValue(src []byte, expr hclsyntax.Expression) interface{} {
+	switch expr := expr.(type) {
+	case *hclsyntax.LiteralValueExpr:
+		switch {
+		case expr.Val.IsNull():
+			return nil
+		case expr.Val.Type() == cty.Bool:
+			return expr.Val.True()
+		case expr.Val.Type() == cty.Number:
+			return hclNumber(string(hclSource(src, expr)), expr.Val)
+		}
+	case *hclsyntax.UnaryOpExpr:
+		if literal, ok := expr.Val.(*hclsyntax.LiteralValueExpr); ok && expr.Op == hclsyntax.OpNegate && literal.Val.Type() == cty.Number && !literal.Val.IsNull() {
+			return hclNumber("-"+string(hclSource(src, literal)), literal.Val.Negate())
+		}
+	case *hclsyntax.TemplateExpr:
+		return hclTemplate(src, expr)
+	case *hclsyntax.TemplateWrapExpr:
+		if wrapped := hclSource(src, expr); len(wrapped) >= 2 && wrapped[0] == '"' {
+			return string(wrapped[1 : len(wrapped)-1])
+		}
+	case *hclsyntax.TupleConsExpr:
+		array := make([]interface{}, len(expr.Exprs))
+		for i, elem := range expr.Exprs {
+			array[i] = hclValue(src, elem)
+		}
+		return array
+	case *hclsyntax.ObjectConsExpr:
+		if obj, ok := hclObject(src, expr); ok {
+			return obj
+		}
+	}
+	return "${" + string(hclSource(src, expr)) + "}"
+}
+
+// hclSource returns the source of an expression.
+func hclSource(src []byte, expr hclsyntax.Expression) []byte {
+	r := expr.Range()
+	return src[r.Start.Byte:r.End.Byte]
+}
+
+// hclNumber returns a number as it's written, or as its value if that isn't
+// valid JSON, such as 007.
+func hclNumber(text string, value cty.Value) json.Number {
+	if isJSONNumber(text) {
+		return json.Number(text)
+	}
+	return json.Number(value.AsBigFloat().Text('g', -1))
+}
+
+// hclObject returns the JSON value of an object expression, if none of its
+// keys are computed.
+func hclObject(src []byte, expr *hclsyntax.ObjectConsExpr) (orderedObject, bool) {
+	obj := orderedObject{}
+	for _, item := range expr.Items {
+		keyExpr, ok := item.KeyExpr.(*hclsyntax.ObjectConsKeyExpr)
+		if !ok || keyExpr.ForceNonLiteral {
+			return nil, false
+		}
+		key := hcl.ExprAsKeyword(keyExpr.Wrapped)
+		if template, ok := keyExpr.Wrapped.(*hclsyntax.TemplateExpr); ok && template.IsStringLiteral() {
+			value, diags := template.Value(nil)
+			if diags.HasErrors() {
+				return nil, false
+			}
+			key = value.AsString()
+		} else if key == "" {
+			return nil, false
+		}
+		obj = obj.set(key, hclValue(src, item.ValueExpr))
+	}
+	return obj, true
+}
+
+// hclTemplate returns a quoted string or heredoc as a template, with its
+// escapes replaced other than those of templates, such as $${, and its
+// interpolations and directives kept as they're written.
+func hclTemplate(src []byte, expr *hclsyntax.TemplateExpr) string {
+	var b strings.Builder
+	for _, part := range expr.Parts {
+		if literal, ok := part.(*hclsyntax.LiteralValueExpr); ok && literal.Val.Type() == cty.String {
+			s := strings.ReplaceAll(literal.Val.AsString(), "${", "$${")
+			b.WriteString(strings.ReplaceAll(s, "%{", "%%{"))
+			continue
+		}
+
+		// Directives span their %{ and }, while interpolations are only
+		// the expression within their ${ and }.
+		r := part.Range()
+		start, end := r.Start.Byte, r.End.Byte
+		if !bytes.HasPrefix(src[start:], []byte("%{")) {
+			for start > 0 && src[start-1] != '{' {
+				start--
+			}
+			start -= 2
+			for end < len(src) && src[end] != '}' {
+				end++
+			}
+			end++
+		}
+		b.Write(src[start:end])
+	}
+	return b.String()
+}
+
+// hclBlockTypes are the block types Terraform and Nomad define, by the type
+// of the block they're nested in, or "" for those at the top level, with the
+// number of labels they take. Keys of these names are written as blocks, and
+// other keys as attributes.
+var hclBlockTypes = map[string]map[string]int{
+	"": {
+		// Terraform
+		"check": 1, "data": 2, "import": 0, "locals": 0, "module": 1, "moved": 0,
+		"output": 1, "provider": 1, "removed": 0, "resource": 2, "terraform": 0,
+		"variable": 1,
+		// Nomad
+		"job": 1,
+	},
+	"check":       {"assert": 0, "data": 2},
+	"data":        {"lifecycle": 0},
+	"dynamic":     {"content": 0},
+	"lifecycle":   {"postcondition": 0, "precondition": 0},
+	"module":      {"providers": 0},
+	"output":      {"precondition": 0},
+	"provisioner": {"connection": 0},
+	"removed":     {"lifecycle": 0},
+	"resource":    {"connection": 0, "dynamic": 1, "lifecycle": 0, "provisioner": 1},
+	"terraform":   {"backend": 1, "cloud": 0, "required_providers": 0},
+	"cloud":       {"workspaces": 0},
+	"variable":    {"validation": 0},
+
+	"job":     {"constraint": 0, "group": 1, "meta": 0, "periodic": 0, "update": 0},
+	"group":   {"constraint": 0, "ephemeral_disk": 0, "network": 0, "restart": 0, "service": 0, "task": 1, "update": 0, "volume": 1},
+	"network": {"port": 1},
+	"service": {"check": 0},
+	"task":    {"artifact": 0, "config": 0, "constraint": 0, "env": 0, "logs": 0, "resources": 0, "restart": 0, "service": 0, "template": 0, "vault": 0, "volume_mount": 0},
+}
+
+// hclSchemaBlockTypes are the block types whose nested blocks are defined by
+// providers. Arrays of objects in their bodies are written as blocks, and so
+// are those in the bodies of these blocks.
+var hclSchemaBlockTypes = map[string]bool{"data": true, "provider": true, "resource": true}
+
+type hclEncoder struct {
+	w      io.Writer
+	blocks bool
+}
+
+func (e *hclEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
+	value, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return err
+	}
+	body, ok := value.(orderedObject)
+	if !ok {
+		return fmt.Errorf("failed to encode as: hcl: %s isn't an object of attributes and blocks", jsonBytes)
+	}
+
+	file := hclwrite.NewEmptyFile()
+	if err := e.writeBody(file.Body(), body, "", ""); err != nil {
+		return fmt.Errorf("failed to encode as: %s", err)
+	}
+	_, err = e.w.Write(hclwrite.Format(file.Bytes()))
+	return err
+}
+
+// writeBody writes the attributes and blocks of a body, whose lines are
+// indented by indent. blockType is the type of the block it's the body of.
+func (e *hclEncoder) writeBody(body *hclwrite.Body, obj orderedObject, blockType, indent string) error {
+	// Blocks are separated from what's around them by blank lines.
+	written, afterBlock := false, false
+	for _, field := range obj {
+		labels, isBlock := hclBlockTypes[blockType][field.key]
+		nestedType := field.key
+		if _, isArray := field.value.([]interface{}); !isBlock && isArray && hclSchemaBlockTypes[blockType] {
+			isBlock, nestedType = true, blockType
+		}
+		if !e.blocks || !isBlock || !isHCLBlocks(field.value, labels) {
+			if !hclsyntax.ValidIdentifier(field.key) {
+				return fmt.Errorf("hcl: %q isn't a valid attribute name", field.key)
+			}
+			tokens, err := hclTokens(field.value, indent)
+			if err != nil {
+				return err
+			}
+			if afterBlock {
+				body.AppendNewline()
+			}
+			body.SetAttributeRaw(field.key, tokens)
+			written, afterBlock = true, false
+			continue
+		}
+
+		for _, block := range hclBlockList(field.value, labels, nil) {
+			if written {
+				body.AppendNewline()
+			}
+			nested := body.AppendNewBlock(field.key, block.labels)
+			if err := e.writeBody(nested.Body(), block.body, nestedType, indent+"  "); err != nil {
+				return err
+			}
+			written, afterBlock = true, true
+		}
+	}
+	return nil
+}
+
+// hclTokens returns the tokens of the HCL expression of a value.
+func hclTokens(value interface{}, indent string) (hclwrite.Tokens, error) {
+	expr, err := hclExpression(value, indent)
+	if err != nil {
+		return nil, err
+	}
+	lexed, diags := hclsyntax.LexExpression([]byte(expr), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, hclError(diags[0])
+	}
+	tokens := make(hclwrite.Tokens, 0, len(lexed))
+	for _, token := range lexed {
+		if token.Type != hclsyntax.TokenEOF {
+			tokens = append(tokens, &hclwrite.Token{Type: token.Type, Bytes: token.Bytes})
+		}
+	}
+	return tokens, nil
+}
+
+// isHCLBlocks reports whether a value can be written as blocks taking the
+// given number of labels: objects nested that many times, whose innermost
+// values are bodies or arrays of bodies.
+func isHCLBlocks(value interface{}, labels int) bool {
+	switch value := value.(type) {
+	case orderedObject:
+		if labels == 0 {
+			return true
+		}
+		for _, field := range value {
+			if !isHCLBlocks(field.value, labels-1) {
+				return false
+			}
+		}
+		return len(value) > 0
+	case []interface{}:
+		for _, elem := range value {
+			if _, ok := elem.(orderedObject); !ok || labels > 0 {
+				return false
+			}
+		}
+		return len(value) > 0
+	}
+	return false
+}
+
+// hclBlockList returns the blocks of a value for which isHCLBlocks is true,
+// following the labels already found.
+func hclBlockList(value interface{}, labels int, found []string) []hclBlock {
+	if labels > 0 {
+		var blocks []hclBlock
+		for _, field := range value.(orderedObject) {
+			nested := append(append([]string(nil), found...), field.key)
+			blocks = append(blocks, hclBlockList(field.value, labels-1, nested)...)
+		}
+		return blocks
+	}
+
+	if body, ok := value.(orderedObject); ok {
+		return []hclBlock{{found, body}}
+	}
+	var blocks []hclBlock
+	for _, body := range value.([]interface{}) {
+		blocks = append(blocks, hclBlock{found, body.(orderedObject)})
+	}
+	return blocks
+}
+
+// hclObjectItems writes the items of an object, quoting the keys that aren't
+// identifiers.
+func hclObjectItems(b *strings.Builder, obj orderedObject, indent string) error {
+	for _, field := range obj {
+		key := field.key
+		if !hclsyntax.ValidIdentifier(key) || key == "true" || key == "false" || key == "null" {
+			key = quoteHCL(key)
+		}
+		value, err := hclExpression(field.value, indent)
+		if err != nil {
+			return err
+		}
+		b.WriteString(indent + key + " = " + value + "\n")
+	}
+	return nil
+}
+
+// hclExpression returns the HCL expression of a value, whose lines after the
+// first are indented by indent.
+func hclExpression(value interface{}, indent string) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case json.Number:
+		return string(value), nil
+	case string:
+		if expr, ok := hclInterpolation(value); ok {
+			return expr, nil
+		}
+		if heredoc, ok := hclHeredoc(value, indent); ok {
+			return heredoc, nil
+		}
+		return quoteHCL(value), nil
+	case []interface{}:
+		if len(value) == 0 {
+			return "[]", nil
+		}
+		elems := make([]string, len(value))
+		multiline := false
+		length := 0
+		for i, elem := range value {
+			s, err := hclExpression(elem, indent+"  ")
+			if err != nil {
+				return "", err
+			}
+			switch elem.(type) {
+			case orderedObject, []interface{}:
+				multiline = true
+			}
+			elems[i] = s
+			length += len(s) + 2
+		}
+		if !multiline && length <= 80 {
+			return "[" + strings.Join(elems, ", ") + "]", nil
+		}
+		var b strings.Builder
+		b.WriteString("[\n")
+		for _, elem := range elems {
+			b.WriteString(indent + "  " + elem + ",\n")
+		}
+		b.WriteString(indent + "]")
+		return b.String(), nil
+	case orderedObject:
+		if len(value) == 0 {
+			return "{}", nil
+		}
+		var b strings.Builder
+		b.WriteString("{\n")
+		if err := hclObjectItems(&b, value, indent+"  "); err != nil {
+			return "", err
+		}
+		b.WriteString(indent + "}")
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("hcl: unsupported type %T", value)
+}
+
+// hclInterpolation returns the expression of a template that is a single
+// interpolation, such as "${var.name}".
+func hclInterpolation(s string) (string, bool) {
+	expr, diags := hclsyntax.ParseTemplate([]byte(s), "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return "", false
+	}
+	wrap, ok := expr.(*hclsyntax.TemplateWrapExpr)
+	if !ok || !strings.HasPrefix(s, "${") || !strings.HasSuffix(s, "}") {
+		return "", false
+	}
+	return string(hclSource([]byte(s), wrap.Wrapped)), true
+}
+
+// hclHeredoc returns a string of several lines as an indented heredoc, if it
+// ends with a newline and has no characters that heredocs can't contain.
+func hclHeredoc(s, indent string) (string, bool) {
+	if strings.Count(s, "\n") < 2 || !strings.HasSuffix(s, "\n") || strings.ContainsAny(s, "\r") {
+		return "", false
+	}
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	for _, marker := range []string{"EOT", "EOF", "END"} {
+		clash := false
+		for _, line := range lines {
+			if strings.TrimSpace(line) == marker {
+				clash = true
+			}
+		}
+		if clash {
+			continue
+		}
+
+		// The lines are indented with the attribute, and the <<- heredoc
+		// removes that indentation, unless every line starts with spaces of
+		// its own that it would remove too.
+		var b strings.Builder
+		if hclCommonIndent(lines) {
+			b.WriteString("<<" + marker + "\n" + s + marker)
+			return b.String(), true
+		}
+		b.WriteString("<<-" + marker + "\n")
+		for _, line := range lines {
+			if line != "" {
+				b.WriteString(indent + "  " + line)
+			}
+			b.WriteByte('\n')
+		}
+		b.WriteString(indent + marker)
+		return b.String(), true
+	}
+	return "", false
+}
+
+// hclCommonIndent reports whether every line that isn't blank starts with a
+// space or tab.
+func hclCommonIndent(lines []string) bool {
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" && line[0] != ' ' && line[0] != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteHCL returns a string quoted as an HCL template, escaping the
+// characters that need to be.
+func quoteHCL(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func init() {
+	Register("hcl", hclEncoding{blocks: true})
+	Register("tf", hclEncoding{blocks: true})
+	Register("tfvars", hclEncoding{})
+}
@@ -0,0 +1,134 @@
+package objconv
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHCLMarshal(t *testing.T) {
+	var table = []struct {
+		input  string
+		output string
+	}{
+		{"", `{}`},
+		{"a = 1\nb = \"x\" # comment\n// comment\nc = [true, null, 1.5]\n", `{"a":1,"b":"x","c":[true,null,1.5]}`},
+		{"a = { b = 1, \"c d\" = [] }\n", `{"a":{"b":1,"c d":[]}}`},
+		{"a = \"tab\\there $${x} ${var.y}\"\n", `{"a":"tab\there $${x} ${var.y}"}`},
+		{"a = var.x\nb = upper(\"}\")\nc = [for s in var.l : s]\n", `{"a":"${var.x}","b":"${upper(\"}\")}","c":"${[for s in var.l : s]}"}`},
+		{"a = <<-EOT\n    x\n      y\n    EOT\nb = <<EOT\n  z\nEOT\n", `{"a":"x\n  y\n","b":"  z\n"}`},
+		{"resource \"aws_instance\" \"web\" {\n  ami = \"ami-123\"\n}\n", `{"resource":{"aws_instance":{"web":{"ami":"ami-123"}}}}`},
+		{"resource \"a\" \"b\" {\n  tags = { x = 1 }\n  timeouts { create = \"10m\" }\n  lifecycle {}\n}\n", `{"resource":{"a":{"b":{"tags":{"x":1},"timeouts":[{"create":"10m"}],"lifecycle":{}}}}}`},
+		{"locals {\n  a = 1\n}\n/* comment */\nlocals { b = 2 }\n", `{"locals":[{"a":1},{"b":2}]}`},
+		{"job \"x\" {\n  group \"y\" {\n    count = 2\n  }\n  group \"z\" {}\n}\n", `{"job":{"x":{"group":{"y":{"count":2},"z":{}}}}}`},
+		{"a = 1.5e3\nb = -2.50\nc = [1E+2, - 0.0]\nd = 007\n", `{"a":1.5e3,"b":-2.50,"c":[1E+2,-0.0],"d":7}`},
+		{"a = \"x %{ if true }y%{ endif } ${~ var.z } \\u00e9\"\nb = <<-EOT\n  ${var.x}\n    $${y}\n  EOT\n", `{"a":"x %{ if true }y%{ endif }${~ var.z } é","b":"${var.x}\n  $${y}\n"}`},
+		{"a = { (var.k) = 1 }\nb = { \"k\" = var.v, c: 2 }\n", `{"a":"${{ (var.k) = 1 }}","b":{"k":"${var.v}","c":2}}`},
+	}
+
+	for _, tt := range table {
+		outputBytes, err := hclEncoding{blocks: true}.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+		if err != nil {
+			t.Fatalf("unexpected error decoding %q: %s", tt.input, err)
+		}
+		if output := string(outputBytes); output != tt.output {
+			t.Errorf("unexpected output decoding %q: %s instead of %s", tt.input, output, tt.output)
+		}
+	}
+
+	// A file is a single body.
+	decoder := hclEncoding{blocks: true}.NewDecoder(strings.NewReader("a = 1\n"))
+	decoder.MarshalJSONBytes()
+	if _, err := decoder.MarshalJSONBytes(); err != io.EOF {
+		t.Errorf("expected io.EOF after the body, got %v", err)
+	}
+}
+
+func TestHCLMarshalError(t *testing.T) {
+	var table = []struct {
+		input string
+		err   string
+	}{
+		{"a = 1\na = 2", "hcl: line 2: Attribute redefined"},
+		{"a = \"x", "hcl: line 1: Unterminated template string"},
+		{"a {\n b = 1", "hcl: line 1: Unclosed configuration block"},
+		{"a = 1\na {}", "hcl: line 2: a is both an attribute and a block"},
+		{"b \"x\" {}\nb {}", "hcl: line 2: blocks of type b have different numbers of labels"},
+		{"a = (1", "hcl: line 1: Unbalanced parentheses"},
+	}
+
+	for _, tt := range table {
+		_, err := hclEncoding{blocks: true}.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error decoding %q: %v instead of %s", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestHCLUnmarshal(t *testing.T) {
+	var table = []struct {
+		blocks bool
+		input  string
+		output string
+	}{
+		{true, `{"a":1,"bb":"x","c":[1,2]}`, "a  = 1\nbb = \"x\"\nc  = [1, 2]\n"},
+		{true, `{"a":"${var.x}","b":"x ${var.y}","c":"$${z}"}`, "a = var.x\nb = \"x ${var.y}\"\nc = \"$${z}\"\n"},
+		{true, `{"a":"x\ny\n"}`, "a = <<-EOT\n  x\n  y\nEOT\n"},
+		{
+			true,
+			`{"variable":{"region":{"default":"us-east-1"}},"resource":{"aws_instance":{"web":{"ami":"ami-123","tags":{"Name":"web"}}}}}`,
+			"variable \"region\" {\n  default = \"us-east-1\"\n}\n\nresource \"aws_instance\" \"web\" {\n  ami = \"ami-123\"\n  tags = {\n    Name = \"web\"\n  }\n}\n",
+		},
+		{
+			true,
+			`{"resource":{"aws_security_group":{"sg":{"ingress":[{"from_port":80},{"from_port":443}]}}}}`,
+			"resource \"aws_security_group\" \"sg\" {\n  ingress {\n    from_port = 80\n  }\n\n  ingress {\n    from_port = 443\n  }\n}\n",
+		},
+		{true, `{"locals":[{"a":1},{"b":2}]}`, "locals {\n  a = 1\n}\n\nlocals {\n  b = 2\n}\n"},
+		{false, `{"locals":{"a":1}}`, "locals = {\n  a = 1\n}\n"},
+		{false, `{"a":1.5e3,"b":-2.50,"c":{"true":1,"d-e":[1E+2]}}`, "a = 1.5e3\nb = -2.50\nc = {\n  \"true\" = 1\n  d-e    = [1E+2]\n}\n"},
+	}
+
+	for _, tt := range table {
+		var buf bytes.Buffer
+		encoder := hclEncoding{blocks: tt.blocks}.NewEncoder(&buf)
+		if err := encoder.UnmarshalJSONBytes([]byte(tt.input), false, true); err != nil {
+			t.Fatalf("unexpected error encoding %s: %s", tt.input, err)
+		}
+		if output := buf.String(); output != tt.output {
+			t.Errorf("unexpected output encoding %s:\n%s\ninstead of:\n%s", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestHCLUnmarshalError(t *testing.T) {
+	for _, input := range []string{`[1]`, `"x"`, `{"a b":1}`} {
+		err := hclEncoding{blocks: true}.NewEncoder(&bytes.Buffer{}).UnmarshalJSONBytes([]byte(input), false, true)
+		if err == nil {
+			t.Errorf("expected an error encoding %s", input)
+		}
+	}
+}
+
+func TestHCLRoundTrip(t *testing.T) {
+	for _, input := range []string{
+		"a  = 1\nbb = [\"x\", true, null]\n",
+		"terraform {\n  required_version = \">= 1.0\"\n}\n\nprovider \"aws\" {\n  region = var.region\n}\n",
+		"output \"ip\" {\n  value = aws_instance.web.public_ip\n}\n",
+		"resource \"aws_instance\" \"web\" {\n  tags = {\n    Name = \"web\"\n  }\n\n  timeouts {\n    create = \"10m\"\n  }\n\n  root_block_device {\n    volume_size = 20\n\n    nested {\n      a = 1\n    }\n  }\n\n  lifecycle {\n    create_before_destroy = true\n  }\n}\n",
+		"data \"aws_ami\" \"ubuntu\" {\n  filter {\n    name   = \"name\"\n    values = [\"ubuntu-*\"]\n  }\n\n  filter {\n    name   = \"state\"\n    values = [\"available\"]\n  }\n}\n",
+	} {
+		value, err := hclEncoding{blocks: true}.NewDecoder(strings.NewReader(input)).MarshalJSONBytes()
+		if err != nil {
+			t.Fatalf("unexpected error decoding %q: %s", input, err)
+		}
+		var buf bytes.Buffer
+		if err := (hclEncoding{blocks: true}).NewEncoder(&buf).UnmarshalJSONBytes(value, false, true); err != nil {
+			t.Fatalf("unexpected error encoding %s: %s", value, err)
+		}
+		if output := buf.String(); output != input {
+			t.Errorf("unexpected round trip of:\n%s\nas:\n%s", input, output)
+		}
+	}
+}
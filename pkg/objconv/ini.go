@@ -0,0 +1,592 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var (
+	_ Encoding = iniEncoding{}
+	_ Decoder  = &iniDecoder{}
+	_ Encoder  = &iniEncoder{}
+)
+
+// iniDialect is one of the INI-style formats of iniEncoding.
+type iniDialect int
+
+const (
+	// iniDialectINI is the format of .ini files, where keys before the first
+	// section are at the top level, keys are separated from their values by
+	// = or :, and a key without a value is null.
+	iniDialectINI iniDialect = iota
+	// iniDialectGitconfig is the format of git's config files, where
+	// subsections such as [remote "origin"] are nested in their sections,
+	// values may be quoted and have trailing comments, and a key without a
+	// value is true.
+	iniDialectGitconfig
+	// iniDialectSystemd is the format of systemd's unit files, where lines
+	// ending in a backslash are continued on the next line.
+	iniDialectSystemd
+)
+
+func (d iniDialect) String() string {
+	switch d {
+	case iniDialectGitconfig:
+		return "gitconfig"
+	case iniDialectSystemd:
+		return "systemd"
+	}
+	return "ini"
+}
+
+// iniEncoding is the family of INI-style formats, with dialects for .ini
+// files, git's config files and systemd's unit files.
+//
+// Its decoder returns a file as an object of its sections, each of which is
+// an object of its keys, so that
+//
+//	[remote "origin"]
+//		url = https://github.com/jzelinskie/faq
+//		fetch = +refs/heads/*:refs/remotes/origin/*
+//
+// is decoded as {"remote": {"origin": {"url": "...", "fetch": "..."}}}.
+// Values are strings, and a key that's repeated is decoded as an array of
+// its values. Sections that are repeated are merged. Comments are skipped.
+// Names are kept as written, even though git's are case-insensitive.
+//
+// Its encoder writes an object of sections back in the same dialect, writing
+// arrays as repeated keys, numbers and booleans as their JSON text, and null
+// as a key without a value, or as an empty value in systemd's dialect.
+type iniEncoding struct {
+	dialect iniDialect
+}
+
+func (e iniEncoding) NewDecoder(r io.Reader) Decoder {
+	return &iniDecoder{r: r, dialect: e.dialect}
+}
+
+func (e iniEncoding) NewEncoder(w io.Writer) Encoder {
+	return &iniEncoder{w, e.dialect}
+}
+
+type iniDecoder struct {
+	r       io.Reader
+	dialect iniDialect
+	read    bool
+}
+
+func (d *iniDecoder) MarshalJSONBytes() ([]byte, error) {
+	if d.read {
+		return nil, io.EOF
+	}
+	src, err := ioutil.ReadAll(d.r)
+	if err != nil {
+		return nil, err
+	}
+	d.read = true
+
+	src = bytes.TrimPrefix(src, []byte("\xef\xbb\xbf"))
+	p := &iniParser{
+		dialect: d.dialect,
+		lines:   strings.Split(strings.Replace(string(src), "\r\n", "\n", -1), "\n"),
+		file:    orderedObject{},
+	}
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("%s: line %d: %s", d.dialect, p.line+1, err)
+	}
+	return marshalJSONValue(p.file)
+}
+
+// iniParser parses the lines of a file in an INI dialect.
+type iniParser struct {
+	dialect iniDialect
+	lines   []string
+	line    int
+	file    orderedObject
+	// section and subsection are the names of the section keys are added to.
+	section, subsection     string
+	inSection, inSubsection bool
+}
+
+func (p *iniParser) parse() error {
+	for ; p.line < len(p.lines); p.line++ {
+		line := strings.TrimSpace(p.lines[p.line])
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		var err error
+		switch {
+		case line[0] == '[':
+			err = p.parseSection(line)
+		case p.dialect == iniDialectGitconfig:
+			err = p.parseGitconfigKey(line)
+		case p.dialect == iniDialectSystemd:
+			err = p.parseSystemdKey(line)
+		default:
+			err = p.parseINIKey(line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseSection parses a section header, and makes it the section that the
+// keys that follow are added to.
+func (p *iniParser) parseSection(line string) error {
+	var section, subsection string
+	inSubsection := false
+	if p.dialect == iniDialectGitconfig {
+		var err error
+		section, subsection, inSubsection, err = parseGitconfigSection(line)
+		if err != nil {
+			return err
+		}
+	} else {
+		end := strings.IndexByte(line, ']')
+		if end == -1 {
+			return errors.New("expected ] after the section name")
+		}
+		if rest := strings.TrimSpace(line[end+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+			return fmt.Errorf("unexpected %q after the section name", rest)
+		}
+		section = strings.TrimSpace(line[1:end])
+	}
+
+	value, ok := p.file.get(section)
+	object, isObject := value.(orderedObject)
+	if ok && !isObject {
+		return fmt.Errorf("%s is both a key and a section", section)
+	}
+	if !ok {
+		object = orderedObject{}
+	}
+	if inSubsection {
+		value, ok := object.get(subsection)
+		if ok {
+			if _, isObject := value.(orderedObject); !isObject {
+				return fmt.Errorf("%s.%s is both a key and a subsection", section, subsection)
+			}
+		} else {
+			object = object.set(subsection, orderedObject{})
+		}
+	}
+	p.file = p.file.set(section, object)
+
+	p.section, p.subsection = section, subsection
+	p.inSection, p.inSubsection = true, inSubsection
+	return nil
+}
+
+// parseGitconfigSection parses a section header of git's config, which may
+// name a subsection either as [section "subsection"] or as the older
+// [section.subsection].
+func parseGitconfigSection(line string) (section, subsection string, inSubsection bool, err error) {
+	i := 1
+	for i < len(line) && (isGitconfigNameByte(line[i]) || line[i] == '.') {
+		i++
+	}
+	section = line[1:i]
+	if section == "" {
+		return "", "", false, errors.New("expected a section name")
+	}
+
+	if i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) || line[i] != '"' {
+			return "", "", false, errors.New("expected a quoted subsection name")
+		}
+		var buf strings.Builder
+		for i++; i < len(line) && line[i] != '"'; i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			buf.WriteByte(line[i])
+		}
+		if i == len(line) {
+			return "", "", false, errors.New("unterminated subsection name")
+		}
+		i++
+		subsection, inSubsection = buf.String(), true
+	} else if dot := strings.IndexByte(section, '.'); dot != -1 {
+		section, subsection, inSubsection = section[:dot], section[dot+1:], true
+	}
+
+	if i == len(line) || line[i] != ']' {
+		return "", "", false, errors.New("expected ] after the section name")
+	}
+	if rest := strings.TrimSpace(line[i+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return "", "", false, fmt.Errorf("unexpected %q after the section name", rest)
+	}
+	return section, subsection, inSubsection, nil
+}
+
+func isGitconfigNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-'
+}
+
+// parseINIKey parses a key of an .ini file, which is separated from its value
+// by the first = or :.
+func (p *iniParser) parseINIKey(line string) error {
+	end := strings.IndexAny(line, "=:")
+	if end == -1 {
+		return p.add(line, nil)
+	}
+	key := strings.TrimSpace(line[:end])
+	if key == "" {
+		return fmt.Errorf("expected a key before %c", line[end])
+	}
+	return p.add(key, strings.TrimSpace(line[end+1:]))
+}
+
+// parseGitconfigKey parses a key of git's config, whose value may contain
+// quoted strings, escapes and a trailing comment, and may be continued on
+// the next line after a backslash.
+func (p *iniParser) parseGitconfigKey(line string) error {
+	if !p.inSection {
+		return errors.New("expected a section before its keys")
+	}
+	i := 0
+	for i < len(line) && isGitconfigNameByte(line[i]) {
+		i++
+	}
+	key := line[:i]
+	if key == "" || !(key[0] >= 'a' && key[0] <= 'z' || key[0] >= 'A' && key[0] <= 'Z') {
+		return fmt.Errorf("invalid key %q", line)
+	}
+	rest := strings.TrimLeft(line[i:], " \t")
+	if rest == "" || rest[0] == '#' || rest[0] == ';' {
+		return p.add(key, true)
+	}
+	if rest[0] != '=' {
+		return fmt.Errorf("expected = after %s", key)
+	}
+
+	var buf strings.Builder
+	quoted := false
+	spaces := 0
+	value := rest[1:]
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !quoted {
+			if c == ' ' || c == '\t' {
+				// Whitespace is kept between the parts of a value, but not
+				// before or after it.
+				if buf.Len() > 0 {
+					spaces++
+				}
+				continue
+			}
+			if c == '#' || c == ';' {
+				break
+			}
+		}
+		for ; spaces > 0; spaces-- {
+			buf.WriteByte(' ')
+		}
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if i+1 == len(value) {
+				if p.line+1 == len(p.lines) {
+					return errors.New("unexpected end of file after \\")
+				}
+				p.line++
+				value, i = p.lines[p.line], -1
+				continue
+			}
+			i++
+			switch value[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'b':
+				buf.WriteByte('\b')
+			case '"', '\\':
+				buf.WriteByte(value[i])
+			default:
+				return fmt.Errorf("invalid escape \\%c", value[i])
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if quoted {
+		return errors.New("unterminated quoted value")
+	}
+	return p.add(key, buf.String())
+}
+
+// parseSystemdKey parses a Key=Value line of a systemd unit file, joining it
+// with the lines that follow it while it ends in a backslash.
+func (p *iniParser) parseSystemdKey(line string) error {
+	if !p.inSection {
+		return errors.New("expected a section before its keys")
+	}
+	for strings.HasSuffix(line, `\`) && p.line+1 < len(p.lines) {
+		p.line++
+		next := strings.TrimSpace(p.lines[p.line])
+		// Comments within a continued line are skipped.
+		if next != "" && (next[0] == '#' || next[0] == ';') {
+			continue
+		}
+		line = strings.TrimRight(line[:len(line)-1], " \t") + " " + next
+	}
+	line = strings.TrimRight(strings.TrimSuffix(line, `\`), " \t")
+
+	end := strings.IndexByte(line, '=')
+	if end == -1 {
+		return fmt.Errorf("expected Key=Value, not %q", line)
+	}
+	key := strings.TrimSpace(line[:end])
+	if key == "" {
+		return errors.New("expected a key before =")
+	}
+	return p.add(key, strings.TrimSpace(line[end+1:]))
+}
+
+// add adds the value of a key to the current section, making the key's value
+// an array if it's repeated.
+func (p *iniParser) add(key string, value interface{}) error {
+	if !p.inSection {
+		var err error
+		p.file, err = addINIKey(p.file, key, value)
+		return err
+	}
+
+	sectionValue, _ := p.file.get(p.section)
+	section := sectionValue.(orderedObject)
+	var err error
+	if p.inSubsection {
+		subsectionValue, _ := section.get(p.subsection)
+		subsection, err := addINIKey(subsectionValue.(orderedObject), key, value)
+		if err != nil {
+			return err
+		}
+		section = section.set(p.subsection, subsection)
+	} else if section, err = addINIKey(section, key, value); err != nil {
+		return err
+	}
+	p.file = p.file.set(p.section, section)
+	return nil
+}
+
+func addINIKey(object orderedObject, key string, value interface{}) (orderedObject, error) {
+	existing, ok := object.get(key)
+	if !ok {
+		return object.set(key, value), nil
+	}
+	switch existing := existing.(type) {
+	case orderedObject:
+		return nil, fmt.Errorf("%s is both a key and a section", key)
+	case []interface{}:
+		return object.set(key, append(existing, value)), nil
+	default:
+		return object.set(key, []interface{}{existing, value}), nil
+	}
+}
+
+type iniEncoder struct {
+	w       io.Writer
+	dialect iniDialect
+}
+
+func (e *iniEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
+	value, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return err
+	}
+	file, ok := value.(orderedObject)
+	if !ok {
+		return fmt.Errorf("failed to encode as: %s: %s isn't an object of sections", e.dialect, jsonBytes)
+	}
+
+	var buf bytes.Buffer
+	if err := e.writeFile(&buf, file); err != nil {
+		return fmt.Errorf("failed to encode as: %s: %s", e.dialect, err)
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// writeFile writes the sections of a file. In the .ini dialect, keys that
+// aren't sections are written before the first section.
+func (e *iniEncoder) writeFile(buf *bytes.Buffer, file orderedObject) error {
+	var sections orderedObject
+	for _, field := range file {
+		if _, ok := field.value.(orderedObject); ok {
+			sections = append(sections, field)
+			continue
+		}
+		if e.dialect != iniDialectINI {
+			return fmt.Errorf("%s isn't a section", field.key)
+		}
+		if err := e.writeKey(buf, field.key, field.value); err != nil {
+			return err
+		}
+	}
+
+	for _, section := range sections {
+		// Git writes its sections without blank lines between them.
+		if buf.Len() > 0 && e.dialect != iniDialectGitconfig {
+			buf.WriteByte('\n')
+		}
+		if err := e.writeSection(buf, section.key, "", false, section.value.(orderedObject)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSection writes a section header and its keys. In git's dialect, the
+// keys that are objects are written as subsections after it, and like git,
+// the header of a section that only has subsections is left out.
+func (e *iniEncoder) writeSection(buf *bytes.Buffer, name, subsection string, inSubsection bool, section orderedObject) error {
+	var keys, subsections orderedObject
+	for _, field := range section {
+		if _, ok := field.value.(orderedObject); !ok {
+			keys = append(keys, field)
+			continue
+		}
+		if e.dialect != iniDialectGitconfig || inSubsection {
+			path := name
+			if inSubsection {
+				path += "." + subsection
+			}
+			return fmt.Errorf("%s.%s can't be written as a key of a section", path, field.key)
+		}
+		subsections = append(subsections, field)
+	}
+
+	switch e.dialect {
+	case iniDialectGitconfig:
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return r > 0x7f || !isGitconfigNameByte(byte(r)) }) != -1 {
+			return fmt.Errorf("%q isn't a valid section name", name)
+		}
+		if inSubsection {
+			if strings.ContainsAny(subsection, "\n\x00") {
+				return fmt.Errorf("%q isn't a valid subsection name", subsection)
+			}
+			subsection = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+			fmt.Fprintf(buf, "[%s \"%s\"]\n", name, subsection)
+		} else if len(keys) > 0 || len(subsections) == 0 {
+			fmt.Fprintf(buf, "[%s]\n", name)
+		}
+	default:
+		if strings.ContainsAny(name, "]\n") {
+			return fmt.Errorf("%q isn't a valid section name", name)
+		}
+		fmt.Fprintf(buf, "[%s]\n", name)
+	}
+
+	for _, field := range keys {
+		if err := e.writeKey(buf, field.key, field.value); err != nil {
+			return err
+		}
+	}
+	for _, field := range subsections {
+		if err := e.writeSection(buf, name, field.key, true, field.value.(orderedObject)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeKey writes a key with a value, or with each of the values of an array.
+func (e *iniEncoder) writeKey(buf *bytes.Buffer, key string, value interface{}) error {
+	if err := e.validateKey(key); err != nil {
+		return err
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+
+	for _, value := range values {
+		var s string
+		switch value := value.(type) {
+		case nil:
+		case string:
+			s = value
+		case json.Number:
+			s = value.String()
+		case bool:
+			s = strconv.FormatBool(value)
+		default:
+			return fmt.Errorf("the value of %s isn't a string, number, boolean, null or array of them", key)
+		}
+
+		switch e.dialect {
+		case iniDialectGitconfig:
+			if value == nil {
+				fmt.Fprintf(buf, "\t%s\n", key)
+			} else {
+				fmt.Fprintf(buf, "\t%s = %s\n", key, quoteGitconfigValue(s))
+			}
+		case iniDialectSystemd:
+			if strings.ContainsAny(s, "\r\n") {
+				return fmt.Errorf("the value of %s can't contain a newline", key)
+			}
+			fmt.Fprintf(buf, "%s=%s\n", key, s)
+		default:
+			if strings.ContainsAny(s, "\r\n") {
+				return fmt.Errorf("the value of %s can't contain a newline", key)
+			}
+			if value == nil {
+				fmt.Fprintf(buf, "%s\n", key)
+			} else {
+				fmt.Fprintf(buf, "%s = %s\n", key, s)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *iniEncoder) validateKey(key string) error {
+	valid := key != "" && key == strings.TrimSpace(key)
+	switch e.dialect {
+	case iniDialectGitconfig:
+		valid = valid && (key[0] >= 'a' && key[0] <= 'z' || key[0] >= 'A' && key[0] <= 'Z') &&
+			strings.IndexFunc(key, func(r rune) bool { return r > 0x7f || !isGitconfigNameByte(byte(r)) }) == -1
+	case iniDialectSystemd:
+		valid = valid && !strings.ContainsAny(key, "=\r\n") && key[0] != '[' && key[0] != '#' && key[0] != ';'
+	default:
+		valid = valid && !strings.ContainsAny(key, "=:\r\n") && key[0] != '[' && key[0] != '#' && key[0] != ';'
+	}
+	if !valid {
+		return fmt.Errorf("%q isn't a valid key", key)
+	}
+	return nil
+}
+
+// quoteGitconfigValue escapes a value of git's config, quoting it if it has
+// whitespace that would otherwise be trimmed or a comment character.
+func quoteGitconfigValue(s string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\b", `\b`).Replace(s)
+	if s != strings.TrimSpace(s) || strings.ContainsAny(s, "#;") {
+		return `"` + escaped + `"`
+	}
+	return escaped
+}
+
+func init() {
+	Register("ini", iniEncoding{iniDialectINI})
+	Register("gitconfig", iniEncoding{iniDialectGitconfig})
+	Register("gitmodules", iniEncoding{iniDialectGitconfig})
+	Register("systemd", iniEncoding{iniDialectSystemd})
+	for _, unitType := range []string{"automount", "mount", "netdev", "network", "service", "slice", "socket", "target", "timer"} {
+		Register(unitType, iniEncoding{iniDialectSystemd})
+	}
+}
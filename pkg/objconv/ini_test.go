@@ -0,0 +1,144 @@
+package objconv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestINIMarshal(t *testing.T) {
+	var table = []struct {
+		dialect iniDialect
+		input   string
+		output  string
+	}{
+		{iniDialectINI, "", `{}`},
+		{iniDialectINI, "; comment\nname = demo\n[db]\nhost = localhost\nport: 5432\n# comment\nskip-networking\n", `{"name":"demo","db":{"host":"localhost","port":"5432","skip-networking":null}}`},
+		{iniDialectINI, "[a]\nx = 1\n[b]\n[a] ; comment\nx = 2\ny = a = b\n", `{"a":{"x":["1","2"],"y":"a = b"},"b":{}}`},
+		{iniDialectINI, "\xef\xbb\xbf[a]\r\nx=1\r\n", `{"a":{"x":"1"}}`},
+		{
+			iniDialectGitconfig,
+			"[core]\n\tbare\n\teditor = vim # comment\n[remote \"origin\"]\n\turl = git@example.com:faq.git\n\tfetch = a\n\tfetch = b\n[remote]\n\tpushDefault = origin\n",
+			`{"core":{"bare":true,"editor":"vim"},"remote":{"origin":{"url":"git@example.com:faq.git","fetch":["a","b"]},"pushDefault":"origin"}}`,
+		},
+		{iniDialectGitconfig, "[alias]\n\tx = \"a ; b\"  c\\t\\\"d\\\" \\\n  e ;comment\n", `{"alias":{"x":"a ; b  c\t\"d\"   e"}}`},
+		{iniDialectGitconfig, "[branch.main]\n\tremote = origin\n[url \"a\\\"b\"]\n\tinsteadOf = c\n", `{"branch":{"main":{"remote":"origin"}},"url":{"a\"b":{"insteadOf":"c"}}}`},
+		{
+			iniDialectSystemd,
+			"[Unit]\nAfter=a.target\nAfter=b.service\n\n[Service]\nExecStart=/bin/web \\\n  --port 80 \\\n  # comment\n  -v\nEnvironment=A=1\nExecStartPre=\n",
+			`{"Unit":{"After":["a.target","b.service"]},"Service":{"ExecStart":"/bin/web --port 80 -v","Environment":"A=1","ExecStartPre":""}}`,
+		},
+	}
+
+	for _, tt := range table {
+		outputBytes, err := iniEncoding{tt.dialect}.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+		if err != nil {
+			t.Fatalf("unexpected error decoding %q as %s: %s", tt.input, tt.dialect, err)
+		}
+		if output := string(outputBytes); output != tt.output {
+			t.Errorf("unexpected output decoding %q as %s: %s instead of %s", tt.input, tt.dialect, output, tt.output)
+		}
+	}
+}
+
+func TestINIMarshalError(t *testing.T) {
+	var table = []struct {
+		dialect iniDialect
+		input   string
+		err     string
+	}{
+		{iniDialectINI, "[a\n", "ini: line 1: expected ] after the section name"},
+		{iniDialectINI, "a = 1\n[a]\n", "ini: line 2: a is both a key and a section"},
+		{iniDialectINI, "[a]\n= 1\n", "ini: line 2: expected a key before ="},
+		{iniDialectGitconfig, "a = 1\n", "gitconfig: line 1: expected a section before its keys"},
+		{iniDialectGitconfig, "[a]\n\tb = \"c\n", "gitconfig: line 2: unterminated quoted value"},
+		{iniDialectGitconfig, "[a]\n\tb = \\q\n", "gitconfig: line 2: invalid escape \\q"},
+		{iniDialectGitconfig, "[a \"b]\n", "gitconfig: line 1: unterminated subsection name"},
+		{iniDialectSystemd, "[Unit]\nfoo\n", "systemd: line 2: expected Key=Value, not \"foo\""},
+	}
+
+	for _, tt := range table {
+		_, err := iniEncoding{tt.dialect}.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error decoding %q as %s: %v instead of %s", tt.input, tt.dialect, err, tt.err)
+		}
+	}
+}
+
+func TestINIUnmarshal(t *testing.T) {
+	var table = []struct {
+		dialect iniDialect
+		input   string
+		output  string
+	}{
+		{iniDialectINI, `{"db":{"host":"x","port":5432,"ssl":true,"skip":null},"name":"demo","tags":{"t":["a","b"]}}`, "name = demo\n\n[db]\nhost = x\nport = 5432\nssl = true\nskip\n\n[tags]\nt = a\nt = b\n"},
+		{
+			iniDialectGitconfig,
+			`{"remote":{"origin":{"url":"u","fetch":["a","b"]},"pushDefault":"origin"},"core":{"editor":"vim -c \"x\"","comment":" # y\t"}}`,
+			"[remote]\n\tpushDefault = origin\n[remote \"origin\"]\n\turl = u\n\tfetch = a\n\tfetch = b\n[core]\n\teditor = vim -c \\\"x\\\"\n\tcomment = \" # y\\t\"\n",
+		},
+		{iniDialectGitconfig, `{"branch":{"main":{"remote":"origin"}}}`, "[branch \"main\"]\n\tremote = origin\n"},
+		{iniDialectSystemd, `{"Unit":{"After":["a","b"]},"Service":{"ExecStartPre":null}}`, "[Unit]\nAfter=a\nAfter=b\n\n[Service]\nExecStartPre=\n"},
+	}
+
+	for _, tt := range table {
+		var buf bytes.Buffer
+		encoder := iniEncoding{tt.dialect}.NewEncoder(&buf)
+		if err := encoder.UnmarshalJSONBytes([]byte(tt.input), false, true); err != nil {
+			t.Fatalf("unexpected error encoding %s as %s: %s", tt.input, tt.dialect, err)
+		}
+		if output := buf.String(); output != tt.output {
+			t.Errorf("unexpected output encoding %s as %s:\n%s\ninstead of:\n%s", tt.input, tt.dialect, output, tt.output)
+		}
+	}
+}
+
+func TestINIUnmarshalError(t *testing.T) {
+	var table = []struct {
+		dialect iniDialect
+		input   string
+	}{
+		{iniDialectINI, `[1]`},
+		{iniDialectINI, `{"a":{"b":{"c":1}}}`},
+		{iniDialectINI, `{"a":{"b":"x\ny"}}`},
+		{iniDialectINI, `{"a":{"b=c":1}}`},
+		{iniDialectGitconfig, `{"a":1}`},
+		{iniDialectGitconfig, `{"a b":{"c":1}}`},
+		{iniDialectGitconfig, `{"a":{"b":{"c":{"d":1}}}}`},
+		{iniDialectGitconfig, `{"a":{"1b":1}}`},
+		{iniDialectSystemd, `{"a":1}`},
+		{iniDialectSystemd, `{"a":{"b":[{"c":1}]}}`},
+	}
+
+	for _, tt := range table {
+		err := iniEncoding{tt.dialect}.NewEncoder(&bytes.Buffer{}).UnmarshalJSONBytes([]byte(tt.input), false, true)
+		if err == nil {
+			t.Errorf("expected an error encoding %s as %s", tt.input, tt.dialect)
+		}
+	}
+}
+
+func TestINIRoundTrip(t *testing.T) {
+	var table = []struct {
+		dialect iniDialect
+		input   string
+	}{
+		{iniDialectINI, "name = demo\n\n[db]\nhost = localhost\nflag\n\n[servers]\nhost = a\nhost = b\n"},
+		{iniDialectGitconfig, "[user]\n\tname = Jane Doe\n[remote \"origin\"]\n\turl = https://example.com/faq\n\tfetch = a\n\tfetch = b\n[alias]\n\tx = \"!git log ; true\"\n"},
+		{iniDialectSystemd, "[Unit]\nDescription=Web\nAfter=a.target\nAfter=b.target\n\n[Install]\nWantedBy=multi-user.target\n"},
+	}
+
+	for _, tt := range table {
+		value, err := iniEncoding{tt.dialect}.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+		if err != nil {
+			t.Fatalf("unexpected error decoding %q as %s: %s", tt.input, tt.dialect, err)
+		}
+		var buf bytes.Buffer
+		if err := (iniEncoding{tt.dialect}).NewEncoder(&buf).UnmarshalJSONBytes(value, false, true); err != nil {
+			t.Fatalf("unexpected error encoding %s as %s: %s", value, tt.dialect, err)
+		}
+		if output := buf.String(); output != tt.input {
+			t.Errorf("unexpected round trip of:\n%s\nas:\n%s", tt.input, output)
+		}
+	}
+}
@@ -10,9 +10,10 @@ import (
 )
 
 var (
-	_ Encoding = jsonEncoding{}
-	_ Decoder  = &jsonDecoder{}
-	_ Encoder  = &jsonEncoder{}
+	_ Encoding       = jsonEncoding{}
+	_ StreamEncoding = jsonEncoding{}
+	_ Decoder        = &jsonDecoder{}
+	_ Encoder        = &jsonEncoder{}
 )
 
 type jsonEncoding struct{}
@@ -22,6 +23,12 @@ func (jsonEncoding) NewDecoder(r io.Reader) Decoder {
 	return &jsonDecoder{decoder}
 }
 
+func (jsonEncoding) NewStreamDecoder(r io.Reader) Decoder {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	return &jsonStreamDecoder{decoder: decoder}
+}
+
 func (jsonEncoding) NewEncoder(w io.Writer) Encoder {
 	return &jsonEncoder{w}
 }
@@ -31,17 +38,19 @@ type jsonDecoder struct {
 }
 
 func (d *jsonDecoder) MarshalJSONBytes() ([]byte, error) {
-	var tmp interface{}
-	err := d.decoder.Decode(&tmp)
+	// Decoding into a json.RawMessage keeps the order of keys and the exact
+	// text of numbers.
+	var raw json.RawMessage
+	err := d.decoder.Decode(&raw)
 	if err != nil {
 		return nil, err
 	}
 
-	b, err := json.Marshal(tmp)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
 		return nil, err
 	}
-	return b, nil
+	return buf.Bytes(), nil
 }
 
 type jsonEncoder struct {
@@ -63,21 +72,10 @@ func (jsonEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
 }
 
 func (jsonEncoder) prettyPrint(jsonBytes []byte) ([]byte, error) {
-	var i interface{}
-	err := json.Unmarshal(jsonBytes, &i)
-	if err != nil {
-		return nil, err
-	}
-
 	var buf bytes.Buffer
-	enc := json.NewEncoder(&buf)
-	enc.SetEscapeHTML(false)
-	enc.SetIndent("", "  ")
-	err = enc.Encode(i)
-	if err != nil {
+	if err := json.Indent(&buf, jsonBytes, "", "  "); err != nil {
 		return nil, err
 	}
-
 	return buf.Bytes(), nil
 }
 
@@ -0,0 +1,418 @@
+package objconv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+var (
+	_ Encoding = msgpackEncoding{}
+	_ Decoder  = &msgpackDecoder{}
+	_ Encoder  = &msgpackEncoder{}
+)
+
+// msgpackExtKey is the key of the object that represents a MessagePack
+// extension value:
+//
+//	{"$msgpack_ext": {"type": 1, "data": "AQID"}}
+//
+// where type is the extension's type and data is its bytes in base64. The
+// encoder writes objects of this shape, with no other keys, as extensions.
+const msgpackExtKey = "$msgpack_ext"
+
+// msgpackEncoding is MessagePack. A stream of concatenated values is decoded
+// as one value each.
+//
+// Integers are decoded as JSON integers and floats as JSON numbers with a
+// fraction or exponent, such as 1.0, so that the encoder writes them back as
+// the same type. Floats that aren't finite are decoded as the strings "NaN",
+// "+Inf" and "-Inf". Binary is decoded as a string of its bytes in base64.
+// Map keys that aren't strings are decoded as their JSON text, such as "1".
+type msgpackEncoding struct{}
+
+func (msgpackEncoding) NewDecoder(r io.Reader) Decoder {
+	return &msgpackDecoder{bufio.NewReader(r)}
+}
+
+func (msgpackEncoding) NewEncoder(w io.Writer) Encoder {
+	return &msgpackEncoder{w}
+}
+
+type msgpackDecoder struct {
+	r *bufio.Reader
+}
+
+func (d *msgpackDecoder) MarshalJSONBytes() ([]byte, error) {
+	if _, err := d.r.Peek(1); err != nil {
+		return nil, err
+	}
+	value, err := d.decodeValue()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return marshalJSONValue(value)
+}
+
+func (d *msgpackDecoder) decodeValue() (interface{}, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case b <= 0x7f:
+		return json.Number(strconv.Itoa(int(b))), nil
+	case b >= 0xe0:
+		return json.Number(strconv.Itoa(int(int8(b)))), nil
+	case b&0xf0 == 0x80:
+		return d.decodeMap(int(b & 0x0f))
+	case b&0xf0 == 0x90:
+		return d.decodeArray(int(b & 0x0f))
+	case b&0xe0 == 0xa0:
+		data, err := readBytes(d.r, int(b&0x1f))
+		return string(data), err
+	}
+
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.readLength(b - 0xc4)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readBytes(d.r, n)
+		return base64.StdEncoding.EncodeToString(data), err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.readLength(b - 0xc7)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeExt(n)
+	case 0xca:
+		n, err := d.readUint(4)
+		return floatNumber(float64(math.Float32frombits(uint32(n))), 32), err
+	case 0xcb:
+		n, err := d.readUint(8)
+		return floatNumber(math.Float64frombits(n), 64), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := d.readUint(1 << (b - 0xcc))
+		return json.Number(strconv.FormatUint(n, 10)), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.readUint(size)
+		// Sign extend the integer from its size to 64 bits.
+		shift := uint(64 - 8*size)
+		return json.Number(strconv.FormatInt(int64(n<<shift)>>shift, 10)), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.decodeExt(1 << (b - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.readLength(b - 0xd9)
+		if err != nil {
+			return nil, err
+		}
+		data, err := readBytes(d.r, n)
+		return string(data), err
+	case 0xdc, 0xdd:
+		n, err := d.readLength(b - 0xdc + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n)
+	case 0xde, 0xdf:
+		n, err := d.readLength(b - 0xde + 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type 0x%x", b)
+}
+
+func (d *msgpackDecoder) decodeArray(n int) (interface{}, error) {
+	array := make([]interface{}, 0, elementCapacity(n))
+	for i := 0; i < n; i++ {
+		value, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+	}
+	return array, nil
+}
+
+func (d *msgpackDecoder) decodeMap(n int) (interface{}, error) {
+	obj := make(orderedObject, 0, elementCapacity(n))
+	for i := 0; i < n; i++ {
+		key, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		value, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+
+		name, ok := key.(string)
+		if !ok {
+			keyBytes, err := marshalJSONValue(key)
+			if err != nil {
+				return nil, err
+			}
+			name = string(keyBytes)
+		}
+		obj = obj.set(name, value)
+	}
+	return obj, nil
+}
+
+func (d *msgpackDecoder) decodeExt(n int) (interface{}, error) {
+	extType, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readBytes(d.r, n)
+	if err != nil {
+		return nil, err
+	}
+	return orderedObject{{msgpackExtKey, orderedObject{
+		{"type", json.Number(strconv.Itoa(int(int8(extType))))},
+		{"data", base64.StdEncoding.EncodeToString(data)},
+	}}}, nil
+}
+
+// readLength reads a length of 1, 2 or 4 bytes, for a size of 0, 1 or 2.
+func (d *msgpackDecoder) readLength(size byte) (int, error) {
+	n, err := d.readUint(1 << size)
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("msgpack: length %d is too large", n)
+	}
+	return int(n), err
+}
+
+// readUint reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(d.r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+type msgpackEncoder struct {
+	w io.Writer
+}
+
+// UnmarshalJSONBytes writes the MessagePack encoding of a value, without a
+// separator, so that the values written form a stream.
+func (e *msgpackEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
+	value, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := encodeMsgpack(&buf, value); err != nil {
+		return fmt.Errorf("failed to encode as: %s", err)
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+var errMsgpackTooLarge = errors.New("msgpack: value is too large")
+
+func encodeMsgpack(buf *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if value {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		encodeMsgpackNumber(buf, value)
+	case string:
+		if err := writeMsgpackHeader(buf, len(value), 0xa0, 32, [3]byte{0xd9, 0xda, 0xdb}); err != nil {
+			return err
+		}
+		buf.WriteString(value)
+	case []interface{}:
+		if err := writeMsgpackHeader(buf, len(value), 0x90, 16, [3]byte{0, 0xdc, 0xdd}); err != nil {
+			return err
+		}
+		for _, elem := range value {
+			if err := encodeMsgpack(buf, elem); err != nil {
+				return err
+			}
+		}
+	case orderedObject:
+		if extType, data, ok, err := msgpackExt(value); ok || err != nil {
+			if err != nil {
+				return err
+			}
+			return writeMsgpackExt(buf, extType, data)
+		}
+		if err := writeMsgpackHeader(buf, len(value), 0x80, 16, [3]byte{0, 0xde, 0xdf}); err != nil {
+			return err
+		}
+		for _, field := range value {
+			if err := encodeMsgpack(buf, field.key); err != nil {
+				return err
+			}
+			if err := encodeMsgpack(buf, field.value); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+	return nil
+}
+
+// encodeMsgpackNumber writes integers in the smallest format that holds them
+// and other numbers as 64-bit floats.
+func encodeMsgpackNumber(buf *bytes.Buffer, n json.Number) {
+	if isJSONInteger(n) {
+		if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+			switch {
+			case i >= 0:
+				writeMsgpackUint(buf, uint64(i))
+			case i >= -32:
+				buf.WriteByte(byte(int8(i)))
+			case i >= math.MinInt8:
+				buf.Write([]byte{0xd0, byte(i)})
+			case i >= math.MinInt16:
+				buf.WriteByte(0xd1)
+				writeMsgpackBigEndian(buf, uint64(i), 2)
+			case i >= math.MinInt32:
+				buf.WriteByte(0xd2)
+				writeMsgpackBigEndian(buf, uint64(i), 4)
+			default:
+				buf.WriteByte(0xd3)
+				writeMsgpackBigEndian(buf, uint64(i), 8)
+			}
+			return
+		}
+		if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+			writeMsgpackUint(buf, u)
+			return
+		}
+	}
+	f, _ := strconv.ParseFloat(string(n), 64)
+	buf.WriteByte(0xcb)
+	writeMsgpackBigEndian(buf, math.Float64bits(f), 8)
+}
+
+func writeMsgpackUint(buf *bytes.Buffer, u uint64) {
+	switch {
+	case u <= 0x7f:
+		buf.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(u)})
+	case u <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		writeMsgpackBigEndian(buf, u, 2)
+	case u <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		writeMsgpackBigEndian(buf, u, 4)
+	default:
+		buf.WriteByte(0xcf)
+		writeMsgpackBigEndian(buf, u, 8)
+	}
+}
+
+// writeMsgpackHeader writes the type and length of a string, array or map.
+// Lengths below fixedLimit are written in the fixed format, whose type is
+// fixed | n, and longer ones in the first of formats, the 8-bit, 16-bit and
+// 32-bit formats, that holds them. Arrays and maps have no 8-bit format,
+// which is then 0.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fixed byte, fixedLimit int, formats [3]byte) error {
+	switch {
+	case n < fixedLimit:
+		buf.WriteByte(fixed | byte(n))
+	case formats[0] != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{formats[0], byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(formats[1])
+		writeMsgpackBigEndian(buf, uint64(n), 2)
+	case uint64(n) <= math.MaxUint32:
+		buf.WriteByte(formats[2])
+		writeMsgpackBigEndian(buf, uint64(n), 4)
+	default:
+		return errMsgpackTooLarge
+	}
+	return nil
+}
+
+func writeMsgpackBigEndian(buf *bytes.Buffer, n uint64, size int) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	buf.Write(b[8-size:])
+}
+
+// msgpackExt returns the type and data of an object representing an
+// extension value. ok is false if the object doesn't represent one.
+func msgpackExt(obj orderedObject) (extType int8, data []byte, ok bool, err error) {
+	if len(obj) != 1 || obj[0].key != msgpackExtKey {
+		return 0, nil, false, nil
+	}
+	ext, isObject := obj[0].value.(orderedObject)
+	typeValue, _ := ext.get("type")
+	dataValue, _ := ext.get("data")
+	typeNumber, isNumber := typeValue.(json.Number)
+	dataString, isString := dataValue.(string)
+	if !isObject || len(ext) != 2 || !isNumber || !isString {
+		return 0, nil, false, fmt.Errorf("msgpack: %s must be an object of a type and data", msgpackExtKey)
+	}
+	i, err := strconv.ParseInt(string(typeNumber), 10, 8)
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("msgpack: invalid %s type %s", msgpackExtKey, typeNumber)
+	}
+	data, err = base64.StdEncoding.DecodeString(dataString)
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("msgpack: invalid %s data: %s", msgpackExtKey, err)
+	}
+	return int8(i), data, true, nil
+}
+
+func writeMsgpackExt(buf *bytes.Buffer, extType int8, data []byte) error {
+	// Data of 1, 2, 4, 8 or 16 bytes is written in the fixext formats.
+	fixext := map[int]byte{1: 0xd4, 2: 0xd5, 4: 0xd6, 8: 0xd7, 16: 0xd8}
+	switch n := len(data); {
+	case fixext[n] != 0:
+		buf.WriteByte(fixext[n])
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xc7, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc8)
+		writeMsgpackBigEndian(buf, uint64(n), 2)
+	case uint64(n) <= math.MaxUint32:
+		buf.WriteByte(0xc9)
+		writeMsgpackBigEndian(buf, uint64(n), 4)
+	default:
+		return errMsgpackTooLarge
+	}
+	buf.WriteByte(byte(extType))
+	buf.Write(data)
+	return nil
+}
+
+func init() {
+	Register("msgpack", msgpackEncoding{})
+}
@@ -0,0 +1,101 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMsgpackMarshal(t *testing.T) {
+	var table = []struct {
+		input  string
+		output []string
+	}{
+		{"83a16101a16294c3c0ffcb3ff8000000000000a163a26869", []string{`{"a":1,"b":[true,null,-1,1.5],"c":"hi"}`}},
+		{"0102a0", []string{`1`, `2`, `""`}},
+		{"", nil},
+		{"ca3fc00000cb3ff0000000000000cb7ff8000000000001", []string{`1.5`, `1.0`, `"NaN"`}},
+		{"cfffffffffffffffffd080d38000000000000000cd0100", []string{`18446744073709551615`, `-128`, `-9223372036854775808`, `256`}},
+		{"c403010203", []string{`"AQID"`}},
+		{"d40501c703ff010203", []string{`{"$msgpack_ext":{"type":5,"data":"AQ=="}}`, `{"$msgpack_ext":{"type":-1,"data":"AQID"}}`}},
+		{"8201a178c2a179", []string{`{"1":"x","false":"y"}`}},
+	}
+
+	for _, tt := range table {
+		input, _ := hex.DecodeString(tt.input)
+		decoder := msgpackEncoding{}.NewDecoder(bytes.NewReader(input))
+
+		var output []string
+		for {
+			outputBytes, err := decoder.MarshalJSONBytes()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error decoding %s: %s", tt.input, err)
+			}
+			output = append(output, string(outputBytes))
+		}
+		if !reflect.DeepEqual(output, tt.output) {
+			t.Errorf("unexpected output decoding %s: %q instead of %q", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestMsgpackMarshalError(t *testing.T) {
+	var table = []struct {
+		input string
+		err   string
+	}{
+		{"9201", "unexpected EOF"},
+		{"a36869", "unexpected EOF"},
+		{"c1", "msgpack: invalid type 0xc1"},
+	}
+
+	for _, tt := range table {
+		input, _ := hex.DecodeString(tt.input)
+		_, err := msgpackEncoding{}.NewDecoder(bytes.NewReader(input)).MarshalJSONBytes()
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("unexpected error decoding %s: %v instead of %s", tt.input, err, tt.err)
+		}
+	}
+}
+
+func TestMsgpackUnmarshal(t *testing.T) {
+	var table = []struct {
+		input  []string
+		output string
+	}{
+		{[]string{`{"a":1,"b":[true,null,-1,1.5],"c":"hi"}`}, "83a16101a16294c3c0ffcb3ff8000000000000a163a26869"},
+		{[]string{`1`, `2`}, "0102"},
+		{[]string{`[127,128,-32,-33,65536,-129,1.0,1e2]`}, "987fcc80e0d0dfce00010000d1ff7fcb3ff0000000000000cb4059000000000000"},
+		{[]string{`18446744073709551615`, `18446744073709551616`}, "cfffffffffffffffffcb43f0000000000000"},
+		{[]string{`"` + strings.Repeat("x", 32) + `"`}, "d920" + strings.Repeat("78", 32)},
+		{[]string{`{"$msgpack_ext":{"type":-1,"data":"AAAAAA=="}}`, `{"$msgpack_ext":{"type":2,"data":"AQID"}}`}, "d6ff00000000c70302010203"},
+	}
+
+	for _, tt := range table {
+		var buf bytes.Buffer
+		encoder := msgpackEncoding{}.NewEncoder(&buf)
+		for _, input := range tt.input {
+			if err := encoder.UnmarshalJSONBytes([]byte(input), false, false); err != nil {
+				t.Fatalf("unexpected error encoding %s: %s", input, err)
+			}
+		}
+		if output := hex.EncodeToString(buf.Bytes()); output != tt.output {
+			t.Errorf("unexpected output encoding %s: %s instead of %s", tt.input, output, tt.output)
+		}
+	}
+}
+
+func TestMsgpackUnmarshalInvalidExt(t *testing.T) {
+	for _, input := range []string{`{"$msgpack_ext":1}`, `{"$msgpack_ext":{"type":300,"data":""}}`, `{"$msgpack_ext":{"type":1,"data":"!"}}`} {
+		err := msgpackEncoding{}.NewEncoder(&bytes.Buffer{}).UnmarshalJSONBytes([]byte(input), false, false)
+		if err == nil {
+			t.Errorf("expected an error encoding %s", input)
+		}
+	}
+}
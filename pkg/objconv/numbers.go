@@ -0,0 +1,54 @@
+package objconv
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// isJSONNumber returns true if s is a number as written in JSON.
+func isJSONNumber(s string) bool {
+	if s == "" || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
+// isJSONInteger returns true if a JSON number has neither a fraction nor an
+// exponent.
+func isJSONInteger(n json.Number) bool {
+	for _, c := range n {
+		if c == '.' || c == 'e' || c == 'E' {
+			return false
+		}
+	}
+	return true
+}
+
+// nativeNumbers converts the json.Numbers of a value decoded with UseNumber
+// to the Go type that holds them exactly, if there is one: int64 or uint64
+// for integers, float64 otherwise. It's used by encoders for formats with
+// native number types.
+func nativeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = nativeNumbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = nativeNumbers(value)
+		}
+	case json.Number:
+		if isJSONInteger(v) {
+			if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				return i
+			}
+			if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+				return u
+			}
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return v
+}
@@ -0,0 +1,130 @@
+package objconv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestNumbersRoundTrip(t *testing.T) {
+	var table = []struct {
+		name  string
+		input string
+		json  string
+	}{
+		{
+			"json",
+			`{"id":1234567890123456789012,"price":1.50,"small":-1e-7,"list":[9007199254740993]}`,
+			`{"id":1234567890123456789012,"price":1.50,"small":-1e-7,"list":[9007199254740993]}`,
+		},
+		{
+			"yaml",
+			"id: 1234567890123456789012\nprice: 1.50\nsmall: -1e-7\nlist:\n- 9007199254740993\n",
+			`{"id":1234567890123456789012,"price":1.50,"small":-1e-7,"list":[9007199254740993]}`,
+		},
+		{
+			// TOML integers are 64-bit and its floats are binary64.
+			"toml",
+			"id = 9223372036854775807\nlow = -9223372036854775808\nlist = [9007199254740993]\nprice = 1.50\n",
+			`{"id":9223372036854775807,"low":-9223372036854775808,"list":[9007199254740993],"price":1.50}`,
+		},
+		{
+			"xml",
+			`<doc><id>1234567890123456789012</id><price>1.50</price><small>-1e-7</small></doc>`,
+			`{"doc":{"id":1234567890123456789012,"price":1.50,"small":-1e-7}}`,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			jsonBytes, err := encoding.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+			if err != nil {
+				t.Fatalf("unexpected error decoding: %s", err)
+			}
+			if string(jsonBytes) != tt.json {
+				t.Fatalf("unexpected JSON:\nexpected: %s\ngot:      %s", tt.json, jsonBytes)
+			}
+
+			var buf bytes.Buffer
+			if err := encoding.NewEncoder(&buf).UnmarshalJSONBytes(jsonBytes, false, false); err != nil {
+				t.Fatalf("unexpected error encoding: %s", err)
+			}
+			jsonBytes, err = encoding.NewDecoder(&buf).MarshalJSONBytes()
+			if err != nil {
+				t.Fatalf("unexpected error decoding the encoded document: %s", err)
+			}
+			if string(jsonBytes) != tt.json {
+				t.Errorf("unexpected JSON after a round trip:\nexpected: %s\ngot:      %s", tt.json, jsonBytes)
+			}
+		})
+	}
+}
+
+func TestNumbersEncode(t *testing.T) {
+	var table = []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"yaml", `{"id":1234567890123456789012,"price":1.50,"count":1}`, "id: 1234567890123456789012\nprice: 1.50\ncount: 1\n"},
+		{"toml", `{"id":9223372036854775807,"price":1.50,"p":[1,2.50]}`, "id = 9223372036854775807\nprice = 1.50\np = [1.0, 2.50]\n"},
+		{"xml", `{"doc":{"id":1234567890123456789012,"price":1.50}}`, "<doc><id>1234567890123456789012</id><price>1.50</price></doc>\n"},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			var buf bytes.Buffer
+			if err := encoding.NewEncoder(&buf).UnmarshalJSONBytes([]byte(tt.input), false, false); err != nil {
+				t.Fatalf("unexpected error encoding: %s", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("unexpected output:\nexpected: %q\ngot:      %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestBinaryNumbers(t *testing.T) {
+	for _, name := range []string{"bson", "plist"} {
+		t.Run(name, func(t *testing.T) {
+			input := `{"max":9223372036854775807,"min":-9223372036854775808,"f":0.1}`
+			encoding, _ := ByName(name)
+			var buf bytes.Buffer
+			if err := encoding.NewEncoder(&buf).UnmarshalJSONBytes([]byte(input), false, false); err != nil {
+				t.Fatalf("unexpected error encoding: %s", err)
+			}
+			// Encoders end their output with a newline.
+			data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+			jsonBytes, err := encoding.NewDecoder(bytes.NewReader(data)).MarshalJSONBytes()
+			if err != nil {
+				t.Fatalf("unexpected error decoding: %s", err)
+			}
+			if expected := `{"f":0.1,"max":9223372036854775807,"min":-9223372036854775808}`; string(jsonBytes) != expected {
+				t.Errorf("unexpected JSON:\nexpected: %s\ngot:      %s", expected, jsonBytes)
+			}
+		})
+	}
+}
+
+func TestBSONDecimal128(t *testing.T) {
+	price, err := bson.ParseDecimal128("1234567890123456789.50")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := bson.Marshal(bson.M{"price": price})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jsonBytes, err := (bsonEncoding{}).NewDecoder(bytes.NewReader(data)).MarshalJSONBytes()
+	if err != nil {
+		t.Fatalf("unexpected error decoding: %s", err)
+	}
+	if expected := `{"price":1234567890123456789.50}`; string(jsonBytes) != expected {
+		t.Errorf("unexpected JSON:\nexpected: %s\ngot:      %s", expected, jsonBytes)
+	}
+}
@@ -0,0 +1,165 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// orderedObject is a JSON object that remembers the order of its keys.
+//
+// Decoders build orderedObjects instead of maps so that the keys of a
+// document are passed to jq in the order they were written, and encoders
+// decode their input into them so that the keys of a result are written in
+// the order jq produced them.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+// set replaces the value of key, or appends key if it isn't in the object.
+func (o orderedObject) set(key string, value interface{}) orderedObject {
+	for i := range o {
+		if o[i].key == key {
+			o[i].value = value
+			return o
+		}
+	}
+	return append(o, orderedField{key, value})
+}
+
+// get returns the value of key.
+func (o orderedObject) get(key string) (interface{}, bool) {
+	for _, field := range o {
+		if field.key == key {
+			return field.value, true
+		}
+	}
+	return nil, false
+}
+
+// MarshalJSON implements json.Marshaler.
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := marshalJSONValue(field.key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := marshalJSONValue(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// orderMap converts a map to an orderedObject. The keys listed in order come
+// first, in that order, followed by the remaining keys sorted.
+func orderMap(m map[string]interface{}, order []string, convert func(key string, value interface{}) (interface{}, error)) (orderedObject, error) {
+	obj := make(orderedObject, 0, len(m))
+	seen := make(map[string]bool, len(m))
+	add := func(key string) error {
+		value, ok := m[key]
+		if !ok || seen[key] {
+			return nil
+		}
+		seen[key] = true
+		converted, err := convert(key, value)
+		if err != nil {
+			return err
+		}
+		obj = append(obj, orderedField{key, converted})
+		return nil
+	}
+
+	for _, key := range order {
+		if err := add(key); err != nil {
+			return nil, err
+		}
+	}
+	var rest []string
+	for key := range m {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	for _, key := range rest {
+		if err := add(key); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// decodeOrderedJSON decodes a single JSON value. Objects are decoded as
+// orderedObjects and numbers as json.Number.
+func decodeOrderedJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	value, err := decodeOrderedValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: unexpected data after the top-level value")
+	}
+	return value, nil
+}
+
+func decodeOrderedValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		obj := orderedObject{}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			obj = obj.set(keyToken.(string), value)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrderedValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	}
+	return token, nil
+}
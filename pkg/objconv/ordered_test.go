@@ -0,0 +1,105 @@
+package objconv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestKeyOrderRoundTrip(t *testing.T) {
+	var table = []struct {
+		name   string
+		input  string
+		json   string
+		pretty bool
+	}{
+		{
+			"json",
+			`{"z": 1, "a": {"y": [1.0, {"q": true, "b": null}], "c": "<&>"}, "m": 12345678901234567890}`,
+			`{"z":1,"a":{"y":[1.0,{"q":true,"b":null}],"c":"<&>"},"m":12345678901234567890}`,
+			false,
+		},
+		{
+			"json",
+			"{\"z\": 1, \"a\": {\"y\": [], \"c\": {}}}",
+			"{\"z\":1,\"a\":{\"y\":[],\"c\":{}}}",
+			true,
+		},
+		{
+			"yaml",
+			"z: 1\na:\n  q: [1, {x: 1, b: 2}]\n  c: null\n  e: {}\nm: text\n",
+			`{"z":1,"a":{"q":[1,{"x":1,"b":2}],"c":null,"e":{}},"m":"text"}`,
+			false,
+		},
+		{
+			"toml",
+			"z = 1\nm = [1.5, 2.0]\n\n[b]\n  y = \"x\"\n  a = {d = 1, c = 2}\n\n[[a]]\n  q = true\n  p = \"text\"\n",
+			`{"z":1,"m":[1.5,2.0],"b":{"y":"x","a":{"d":1,"c":2}},"a":[{"q":true,"p":"text"}]}`,
+			false,
+		},
+		{
+			"xml",
+			`<root z="1" a="2"><y>1</y><b><d/><c>me &amp; you</c></b><y>2</y><a x="1">text</a></root>`,
+			`{"root":{"-z":1,"-a":2,"y":[1,2],"b":{"d":"","c":"me & you"},"a":{"-x":1,"#text":"text"}}}`,
+			false,
+		},
+		{
+			"xml",
+			"<root>\n  <y>1</y>\n  <b>\n    <d/>\n    <c>text</c>\n  </b>\n  <y>2</y>\n</root>",
+			`{"root":{"y":[1,2],"b":{"d":"","c":"text"}}}`,
+			true,
+		},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			jsonBytes, err := encoding.NewDecoder(strings.NewReader(tt.input)).MarshalJSONBytes()
+			if err != nil {
+				t.Fatalf("unexpected error decoding: %s", err)
+			}
+			if string(jsonBytes) != tt.json {
+				t.Fatalf("unexpected JSON:\nexpected: %s\ngot:      %s", tt.json, jsonBytes)
+			}
+
+			var buf bytes.Buffer
+			if err := encoding.NewEncoder(&buf).UnmarshalJSONBytes(jsonBytes, false, tt.pretty); err != nil {
+				t.Fatalf("unexpected error encoding: %s", err)
+			}
+
+			// Rewriting the document should keep the order of its keys.
+			jsonBytes, err = encoding.NewDecoder(&buf).MarshalJSONBytes()
+			if err != nil {
+				t.Fatalf("unexpected error decoding the encoded document: %s", err)
+			}
+			if string(jsonBytes) != tt.json {
+				t.Errorf("unexpected JSON after a round trip:\nexpected: %s\ngot:      %s", tt.json, jsonBytes)
+			}
+		})
+	}
+}
+
+func TestTOMLEncodeOrder(t *testing.T) {
+	input := `{"z":1,"t":{"b":2,"a":{"y":1}},"s":"x","r":[{"q":1.5},{"p":[1,2.5]}]}`
+	expected := "z = 1\n" +
+		"s = \"x\"\n" +
+		"\n" +
+		"[t]\n" +
+		"  b = 2\n" +
+		"  [t.a]\n" +
+		"    y = 1\n" +
+		"\n" +
+		"[[r]]\n" +
+		"  q = 1.5\n" +
+		"\n" +
+		"[[r]]\n" +
+		"  p = [1.0, 2.5]\n"
+
+	var buf bytes.Buffer
+	if err := (tomlEncoding{}).NewEncoder(&buf).UnmarshalJSONBytes([]byte(input), false, false); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("unexpected TOML:\nexpected: %q\ngot:      %q", expected, buf.String())
+	}
+}
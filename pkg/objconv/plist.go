@@ -75,13 +75,16 @@ func (e *plistEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool)
 }
 
 func (plistEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonBytes))
+	decoder.UseNumber()
+
 	var tmp interface{}
-	err := json.NewDecoder(bytes.NewReader(jsonBytes)).Decode(&tmp)
+	err := decoder.Decode(&tmp)
 	if err != nil {
 		return nil, err
 	}
 	var buf bytes.Buffer
-	err2 := plist.NewEncoder(&buf).Encode(tmp)
+	err2 := plist.NewEncoder(&buf).Encode(nativeNumbers(tmp))
 	if err2 != nil {
 		return nil, err2
 	}
@@ -0,0 +1,101 @@
+package objconv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	_ Encoding = rawEncoding{}
+	_ Decoder  = &rawDecoder{}
+	_ Encoder  = &rawEncoder{}
+)
+
+// rawEncoding is plain text. When lines is set, each line is a string,
+// otherwise the whole input is a single string.
+type rawEncoding struct {
+	lines bool
+}
+
+func (e rawEncoding) NewDecoder(r io.Reader) Decoder {
+	return &rawDecoder{r: bufio.NewReader(r), lines: e.lines}
+}
+
+func (e rawEncoding) NewEncoder(w io.Writer) Encoder {
+	return &rawEncoder{w: w, lines: e.lines}
+}
+
+type rawDecoder struct {
+	r     *bufio.Reader
+	lines bool
+	read  bool
+}
+
+func (d *rawDecoder) MarshalJSONBytes() ([]byte, error) {
+	if !d.lines {
+		// The whole input is a string, even if it's empty.
+		if d.read {
+			return nil, io.EOF
+		}
+		text, err := ioutil.ReadAll(d.r)
+		if err != nil {
+			return nil, err
+		}
+		d.read = true
+		return marshalJSONValue(string(text))
+	}
+
+	line, err := d.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return marshalJSONValue(strings.TrimSuffix(line, "\n"))
+}
+
+// marshalJSONValue encodes v as compact JSON without escaping HTML characters.
+func marshalJSONValue(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// rawEncoder writes strings as they are and other values as compact JSON.
+// When lines is set, each value is followed by a newline.
+type rawEncoder struct {
+	w     io.Writer
+	lines bool
+}
+
+func (e *rawEncoder) UnmarshalJSONBytes(input []byte, color, pretty bool) error {
+	// With raw output, strings are already unquoted and so aren't JSON.
+	out := input
+	var s string
+	var buf bytes.Buffer
+	if err := json.Unmarshal(input, &s); err == nil {
+		out = []byte(s)
+	} else if err := json.Compact(&buf, input); err == nil {
+		out = buf.Bytes()
+	}
+
+	if e.lines {
+		out = append(out, '\n')
+	}
+	_, err := e.w.Write(out)
+	return err
+}
+
+func init() {
+	Register("lines", rawEncoding{lines: true})
+	Register("raw", rawEncoding{})
+}
@@ -0,0 +1,72 @@
+package objconv
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRawMarshal(t *testing.T) {
+	var table = []struct {
+		name   string
+		input  string
+		output []string
+	}{
+		{"lines", "a\n<b>\n\nc", []string{`"a"`, `"<b>"`, `""`, `"c"`}},
+		{"lines", "a\r\n", []string{`"a\r"`}},
+		{"lines", "", nil},
+		{"raw", "a\nb\n", []string{`"a\nb\n"`}},
+		{"raw", "", []string{`""`}},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			decoder := encoding.NewDecoder(strings.NewReader(tt.input))
+
+			var output []string
+			for {
+				outputBytes, err := decoder.MarshalJSONBytes()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				output = append(output, string(outputBytes))
+			}
+			if !reflect.DeepEqual(output, tt.output) {
+				t.Errorf("unexpected output: %q instead of %q", output, tt.output)
+			}
+		})
+	}
+}
+
+func TestRawUnmarshal(t *testing.T) {
+	var table = []struct {
+		name   string
+		input  []string
+		output string
+	}{
+		{"lines", []string{`"a"`, `{"b": [1, 2]}`, `c`}, "a\n{\"b\":[1,2]}\nc\n"},
+		{"raw", []string{`"a\n"`, `"b"`}, "a\nb"},
+	}
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			encoding, _ := ByName(tt.name)
+			var buf bytes.Buffer
+			encoder := encoding.NewEncoder(&buf)
+			for _, input := range tt.input {
+				if err := encoder.UnmarshalJSONBytes([]byte(input), false, false); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+			}
+			if output := buf.String(); output != tt.output {
+				t.Errorf("unexpected output: %q instead of %q", output, tt.output)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// StreamEncoding is implemented by Encodings that can decode their input as
+// a stream of events without holding whole values in memory.
+type StreamEncoding interface {
+	Encoding
+
+	// NewStreamDecoder returns a Decoder that returns the events of jq's
+	// --stream mode for the input rather than its values.
+	NewStreamDecoder(io.Reader) Decoder
+}
+
+// NewStreamDecoder returns a Decoder that returns an event per invocation,
+// the same as those of jq's --stream mode:
+//
+//   - [path, leaf] for every scalar, empty array and empty object
+//   - [path] when a non-empty array or object is closed, where path is the
+//     path of its last element
+//
+// If the encoding is not a StreamEncoding, each value is decoded whole and
+// then split into events in the order of its keys, so memory use is bounded
+// by the size of the largest value rather than that of the input. YAML is
+// streamed that way, a document at a time.
+func NewStreamDecoder(encoding Encoding, r io.Reader) Decoder {
+	if encoding, ok := encoding.(StreamEncoding); ok {
+		return encoding.NewStreamDecoder(r)
+	}
+	return &valueStreamDecoder{decoder: encoding.NewDecoder(r)}
+}
+
+// streamFrame is an array or object that is being streamed.
+type streamFrame struct {
+	object bool
+	// key is the key or index of the current element.
+	key interface{}
+	// length is the number of elements that have been completed.
+	length int
+}
+
+// streamer tracks the path of the current value in order to build events.
+type streamer struct {
+	frames []streamFrame
+}
+
+// path returns the path of the current value.
+func (s *streamer) path() []interface{} {
+	path := make([]interface{}, 0, len(s.frames))
+	for _, frame := range s.frames {
+		path = append(path, frame.key)
+	}
+	return path
+}
+
+// begin is called before the value of an array element is started.
+func (s *streamer) begin() {
+	if len(s.frames) != 0 {
+		if frame := &s.frames[len(s.frames)-1]; !frame.object {
+			frame.key = frame.length
+		}
+	}
+}
+
+// end is called once a value has been completed.
+func (s *streamer) end() {
+	if len(s.frames) != 0 {
+		s.frames[len(s.frames)-1].length++
+	}
+}
+
+// open starts an array or object.
+func (s *streamer) open(object bool) {
+	s.begin()
+	s.frames = append(s.frames, streamFrame{object: object})
+}
+
+// setKey sets the key of the next value of the current object.
+func (s *streamer) setKey(key string) {
+	s.frames[len(s.frames)-1].key = key
+}
+
+// scalar returns the event for a scalar value.
+func (s *streamer) scalar(value interface{}) []interface{} {
+	s.begin()
+	event := []interface{}{s.path(), value}
+	s.end()
+	return event
+}
+
+// close closes the current array or object and returns its event.
+func (s *streamer) close() []interface{} {
+	frame := s.frames[len(s.frames)-1]
+	var event []interface{}
+	if frame.length != 0 {
+		event = []interface{}{s.path()}
+		s.frames = s.frames[:len(s.frames)-1]
+	} else {
+		s.frames = s.frames[:len(s.frames)-1]
+		var empty interface{} = []interface{}{}
+		if frame.object {
+			empty = map[string]interface{}{}
+		}
+		event = []interface{}{s.path(), empty}
+	}
+	s.end()
+	return event
+}
+
+// inObject reports whether the current value is in an object.
+func (s *streamer) inObject() bool {
+	return len(s.frames) != 0 && s.frames[len(s.frames)-1].object
+}
+
+// valueStreamDecoder splits each value decoded by a Decoder into events, in
+// the order of the keys of its objects.
+type valueStreamDecoder struct {
+	decoder Decoder
+	events  [][]byte
+}
+
+func (d *valueStreamDecoder) MarshalJSONBytes() ([]byte, error) {
+	for len(d.events) == 0 {
+		data, err := d.decoder.MarshalJSONBytes()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+
+		value, err := decodeOrderedJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		var s streamer
+		if d.events, err = appendStreamEvents(d.events, &s, value); err != nil {
+			return nil, err
+		}
+	}
+
+	event := d.events[0]
+	d.events = d.events[1:]
+	return event, nil
+}
+
+// appendStreamEvents appends the events of value, a value decoded by
+// decodeOrderedJSON, at the current path of s to events.
+func appendStreamEvents(events [][]byte, s *streamer, value interface{}) ([][]byte, error) {
+	var event []interface{}
+	var err error
+	switch value := value.(type) {
+	case orderedObject:
+		s.open(true)
+		for _, field := range value {
+			s.setKey(field.key)
+			if events, err = appendStreamEvents(events, s, field.value); err != nil {
+				return nil, err
+			}
+		}
+		event = s.close()
+	case []interface{}:
+		s.open(false)
+		for _, element := range value {
+			if events, err = appendStreamEvents(events, s, element); err != nil {
+				return nil, err
+			}
+		}
+		event = s.close()
+	default:
+		event = s.scalar(value)
+	}
+	return appendStreamEvent(events, event)
+}
+
+// appendStreamEvent appends event, marshaled, to events.
+func appendStreamEvent(events [][]byte, event []interface{}) ([][]byte, error) {
+	b, err := marshalJSONValue(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal stream event: %s", err)
+	}
+	return append(events, b), nil
+}
+
+// jsonStreamDecoder reads JSON a token at a time, so values of any size can be
+// streamed.
+type jsonStreamDecoder struct {
+	decoder   *json.Decoder
+	streamer  streamer
+	expectKey bool
+}
+
+func (d *jsonStreamDecoder) MarshalJSONBytes() ([]byte, error) {
+	for {
+		token, err := d.decoder.Token()
+		if err == io.EOF && len(d.streamer.frames) != 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if key, ok := token.(string); ok && d.expectKey && d.streamer.inObject() {
+			d.streamer.setKey(key)
+			d.expectKey = false
+			continue
+		}
+
+		var event []interface{}
+		switch token {
+		case json.Delim('{'):
+			d.streamer.open(true)
+			d.expectKey = true
+			continue
+		case json.Delim('['):
+			d.streamer.open(false)
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			event = d.streamer.close()
+		default:
+			event = d.streamer.scalar(token)
+		}
+		// The next token is a key if the parent of the completed value is
+		// an object.
+		d.expectKey = true
+
+		b, err := json.Marshal(event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal stream event: %s", err)
+		}
+		return b, nil
+	}
+}
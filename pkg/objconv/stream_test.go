@@ -0,0 +1,161 @@
+package objconv
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// expectedStreamEvents are the events jq --stream produces for the JSON values
+// {"a":1,"b":[true,{"c":[]}],"d":{}} "x" [] [[null]]
+var expectedStreamEvents = []string{
+	`[["a"],1]`,
+	`[["b",0],true]`,
+	`[["b",1,"c"],[]]`,
+	`[["b",1,"c"]]`,
+	`[["b",1]]`,
+	`[["d"],{}]`,
+	`[["d"]]`,
+	`[[],"x"]`,
+	`[[],[]]`,
+	`[[0,0],null]`,
+	`[[0,0]]`,
+	`[[0]]`,
+}
+
+func TestStreamDecoder(t *testing.T) {
+	testCases := []struct {
+		format string
+		input  string
+	}{
+		{"json", `{"a":1,"b":[true,{"c":[]}],"d":{}} "x" [] [[null]]`},
+		{"yaml", "a: 1\nb: [true, {c: []}]\nd: {}\n---\nx\n---\n[]\n---\n[[null]]\n"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.format, func(t *testing.T) {
+			encoding, _ := ByName(testCase.format)
+			events := readStreamEvents(t, NewStreamDecoder(encoding, strings.NewReader(testCase.input)))
+			if strings.Join(events, "\n") != strings.Join(expectedStreamEvents, "\n") {
+				t.Errorf("incorrect events expected=%q, got=%q", expectedStreamEvents, events)
+			}
+		})
+	}
+}
+
+func TestXMLStreamDecoder(t *testing.T) {
+	encoding, _ := ByName("xml")
+	decoder := NewStreamDecoder(encoding, strings.NewReader(`<items><item id="1">a</item><item id="2">b</item></items>`))
+
+	expected := []string{
+		`[["items","item",0,"-id"],1]`,
+		`[["items","item",0,"#text"],"a"]`,
+		`[["items","item",0,"#text"]]`,
+		`[["items","item",1,"-id"],2]`,
+		`[["items","item",1,"#text"],"b"]`,
+		`[["items","item",1,"#text"]]`,
+		`[["items","item",1]]`,
+		`[["items","item"]]`,
+		`[["items"]]`,
+	}
+	events := readStreamEvents(t, decoder)
+	if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("incorrect events expected=%q, got=%q", expected, events)
+	}
+}
+
+func TestXMLStreamDecoderValues(t *testing.T) {
+	// The events of each document must be those of its value.
+	documents := []string{
+		`<root/>`,
+		`<root>text</root>`,
+		`<root a="1"/>`,
+		`<root a="1"><y>1</y></root>`,
+		`<root z="1" a="2"><y>1</y><b><d/><c>me &amp; you</c></b><y>2</y><a x="1">text</a></root>`,
+		`<root><y>1</y>text<b/><y>2</y><y>3</y>more</root>`,
+		`<root>text<y>1</y><y>2</y></root>`,
+		`<root a="1">text<y>1</y></root>`,
+		`<?xml version="1.0"?><!-- comment --><root><y><z>1</z></y></root>`,
+	}
+
+	encoding, _ := ByName("xml")
+	for _, document := range documents {
+		expected := readStreamEvents(t, &valueStreamDecoder{decoder: encoding.NewDecoder(strings.NewReader(document))})
+		events := readStreamEvents(t, NewStreamDecoder(encoding, strings.NewReader(document)))
+		if strings.Join(events, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%s: incorrect events expected=%q, got=%q", document, expected, events)
+		}
+	}
+}
+
+// endlessItems is a reader of an XML root element with endless children.
+type endlessItems struct {
+	started bool
+}
+
+func (r *endlessItems) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		return copy(p, "<items>"), nil
+	}
+	return copy(p, "<item>1</item>"), nil
+}
+
+func TestXMLStreamDecoderEndless(t *testing.T) {
+	encoding, _ := ByName("xml")
+	decoder := NewStreamDecoder(encoding, &endlessItems{})
+	for i := 0; i < 100; i++ {
+		event, err := decoder.MarshalJSONBytes()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if expected := `[["items","item",` + strconv.Itoa(i) + `],1]`; string(event) != expected {
+			t.Fatalf("incorrect event expected=%s, got=%s", expected, event)
+		}
+	}
+}
+
+func TestStreamDecoderKeyOrder(t *testing.T) {
+	encoding, _ := ByName("yaml")
+	events := readStreamEvents(t, NewStreamDecoder(encoding, strings.NewReader("z: 1\na: 2\n")))
+	if expected := []string{`[["z"],1]`, `[["a"],2]`, `[["a"]]`}; strings.Join(events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("incorrect events expected=%q, got=%q", expected, events)
+	}
+}
+
+func TestJSONStreamDecoderLargeNumbers(t *testing.T) {
+	encoding, _ := ByName("json")
+	events := readStreamEvents(t, NewStreamDecoder(encoding, strings.NewReader(`[12345678901234567890]`)))
+	if expected := `[[0],12345678901234567890]`; len(events) == 0 || events[0] != expected {
+		t.Errorf("expected the first event to be %s, got %q", expected, events)
+	}
+}
+
+func TestJSONStreamDecoderTruncated(t *testing.T) {
+	encoding, _ := ByName("json")
+	decoder := NewStreamDecoder(encoding, strings.NewReader(`{"a":[1,`))
+	for {
+		_, err := decoder.MarshalJSONBytes()
+		if err == io.EOF {
+			t.Fatal("expected an error for truncated input, got io.EOF")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readStreamEvents(t *testing.T, decoder Decoder) []string {
+	var events []string
+	for {
+		event, err := decoder.MarshalJSONBytes()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		events = append(events, string(event))
+	}
+}
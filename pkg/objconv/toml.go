@@ -3,9 +3,13 @@ package objconv
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/alecthomas/chroma/quick"
@@ -41,12 +45,205 @@ func (d *tomlDecoder) MarshalJSONBytes() ([]byte, error) {
 		return nil, err
 	}
 	d.read = true
-	var obj interface{}
-	err = toml.Unmarshal(tomlBytes, &obj)
+	var obj map[string]interface{}
+	md, err := toml.Decode(string(tomlBytes), &obj)
 	if err != nil {
 		return nil, err
 	}
-	return json.Marshal(obj)
+
+	// The metadata lists every key in the order it appears in the document,
+	// which is used to order the keys of each table.
+	order := make(map[string][]string)
+	for _, key := range md.Keys() {
+		for i := range key {
+			path := tomlPath(key[:i])
+			if !containsString(order[path], key[i]) {
+				order[path] = append(order[path], key[i])
+			}
+		}
+	}
+	ordered, err := orderTOMLValue(obj, "", order)
+	if err != nil {
+		return nil, err
+	}
+	restoreTOMLFloats(tomlBytes, ordered)
+	return json.Marshal(ordered)
+}
+
+// restoreTOMLFloats replaces the floats of the document decoded from src with
+// json.Numbers of the text they're written as in src, so that 2.50 isn't
+// written as 2.5. The floats of a value are matched with the float literals
+// of its text in order, and are left as they are unless they all match.
+func restoreTOMLFloats(src []byte, doc interface{}) {
+	_, pairs, ok := parseTOMLDocument(src)
+	if !ok {
+		return
+	}
+	for _, pair := range pairs {
+		slot := tomlValueSlot(doc, pair.path)
+		if slot == nil {
+			continue
+		}
+		literals := tomlFloatLiterals(src[pair.valueStart:pair.valueEnd])
+		if replaced, ok := replaceTOMLFloats(*slot, &literals); ok && len(literals) == 0 {
+			*slot = replaced
+		}
+	}
+}
+
+// tomlValueSlot returns a pointer to the value at path in a value converted
+// by orderTOMLValue, or nil if there's none.
+func tomlValueSlot(v interface{}, path tomlValuePath) *interface{} {
+	var slot *interface{}
+	for _, step := range path {
+		if slot != nil {
+			v = *slot
+		}
+		slot = nil
+		switch step := step.(type) {
+		case string:
+			obj, _ := v.(orderedObject)
+			for i := range obj {
+				if obj[i].key == step {
+					slot = &obj[i].value
+				}
+			}
+		case int:
+			if array, ok := v.([]interface{}); ok && step < len(array) {
+				slot = &array[step]
+			}
+		}
+		if slot == nil {
+			return nil
+		}
+	}
+	return slot
+}
+
+// replaceTOMLFloats returns v with its floats replaced by the literals of
+// the same values, which are consumed in order, reporting whether there were
+// enough of them and they matched.
+func replaceTOMLFloats(v interface{}, literals *[]string) (interface{}, bool) {
+	switch v := v.(type) {
+	case float64:
+		if len(*literals) == 0 {
+			return nil, false
+		}
+		literal := (*literals)[0]
+		*literals = (*literals)[1:]
+		if f, err := strconv.ParseFloat(literal, 64); err != nil || f != v {
+			return nil, false
+		}
+		return json.Number(literal), true
+	case orderedObject:
+		obj := make(orderedObject, len(v))
+		for i, field := range v {
+			value, ok := replaceTOMLFloats(field.value, literals)
+			if !ok {
+				return nil, false
+			}
+			obj[i] = orderedField{field.key, value}
+		}
+		return obj, true
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, elem := range v {
+			value, ok := replaceTOMLFloats(elem, literals)
+			if !ok {
+				return nil, false
+			}
+			array[i] = value
+		}
+		return array, true
+	}
+	return v, true
+}
+
+// tomlFloatLiterals returns the float literals of the text of a value, in
+// order, written as JSON numbers.
+func tomlFloatLiterals(text []byte) []string {
+	var literals []string
+	p := &tomlParser{src: text}
+	for p.pos < len(text) {
+		switch c := text[p.pos]; {
+		case c == '"' || c == '\'':
+			if !p.string() {
+				return literals
+			}
+		case c == '#':
+			p.skipComment()
+		case bytes.IndexByte([]byte(" \t\r\n,=[]{}"), c) >= 0:
+			p.pos++
+		default:
+			start := p.pos
+			for p.pos < len(text) && bytes.IndexByte([]byte(" \t\r\n#,=[]{}"), text[p.pos]) < 0 {
+				p.pos++
+			}
+			token := string(text[start:p.pos])
+			// The keys of inline tables come before an equals sign.
+			p.skipSpace()
+			if p.hasPrefix("=") || !tomlFloatPattern.MatchString(token) {
+				continue
+			}
+			token = strings.TrimPrefix(strings.Replace(token, "_", "", -1), "+")
+			literals = append(literals, token)
+		}
+	}
+	return literals
+}
+
+var tomlFloatPattern = regexp.MustCompile(`^[+-]?[0-9_]+(\.[0-9_]+([eE][+-]?[0-9_]+)?|[eE][+-]?[0-9_]+)$`)
+
+// tomlPath joins the keys of a table into a single string, the same way
+// orderTOMLValue does as it descends into tables. The elements of an array of
+// tables share a path.
+func tomlPath(key []string) string {
+	var path string
+	for _, k := range key {
+		path += "\x00" + k
+	}
+	return path
+}
+
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
+
+// orderTOMLValue converts the tables in a value decoded by the toml package
+// to orderedObjects, ordering their keys by order.
+func orderTOMLValue(v interface{}, path string, order map[string][]string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return orderMap(v, order[path], func(key string, value interface{}) (interface{}, error) {
+			return orderTOMLValue(value, path+"\x00"+key, order)
+		})
+	case []map[string]interface{}:
+		array := make([]interface{}, len(v))
+		for i, table := range v {
+			elem, err := orderTOMLValue(table, path, order)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = elem
+		}
+		return array, nil
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, value := range v {
+			elem, err := orderTOMLValue(value, path, order)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = elem
+		}
+		return array, nil
+	}
+	return v, nil
 }
 
 type tomlEncoder struct {
@@ -63,17 +260,265 @@ func (e tomlEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) er
 }
 
 func (tomlEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
-	var obj interface{}
-	err := json.Unmarshal(jsonBytes, &obj)
+	value, err := decodeOrderedJSON(jsonBytes)
 	if err != nil {
 		return nil, err
 	}
+	obj, ok := value.(orderedObject)
+	if !ok {
+		return nil, errTOMLNoKey
+	}
 
-	var buf bytes.Buffer
-	if err := toml.NewEncoder(&buf).Encode(obj); err != nil {
+	w := tomlWriter{}
+	if err := w.table(nil, obj); err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	return w.buf.Bytes(), nil
+}
+
+// The TOML written by tomlWriter is laid out the same way as the output of
+// github.com/BurntSushi/toml's Encoder, except that keys are written in the
+// order of the input instead of sorted.
+var (
+	errTOMLMixedElementTypes = errors.New("toml: cannot encode array with mixed element types")
+	errTOMLNilElement        = errors.New("toml: cannot encode array with nil element")
+	errTOMLArrayNoTable      = errors.New("toml: TOML array element cannot contain a table")
+	errTOMLNoKey             = errors.New("toml: top-level values must be Go maps or structs")
+)
+
+var tomlQuoter = strings.NewReplacer(
+	"\t", "\\t",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\"", "\\\"",
+	"\\", "\\\\",
+)
+
+// tomlType is the TOML type of a value decoded by decodeOrderedJSON.
+type tomlType int
+
+const (
+	tomlNil tomlType = iota
+	tomlBool
+	tomlInteger
+	tomlFloat
+	tomlString
+	tomlArray
+	tomlTable
+	tomlArrayOfTables
+)
+
+func tomlTypeOf(v interface{}) (tomlType, error) {
+	switch v := v.(type) {
+	case nil:
+		return tomlNil, nil
+	case bool:
+		return tomlBool, nil
+	case json.Number:
+		if isJSONInteger(v) {
+			return tomlInteger, nil
+		}
+		return tomlFloat, nil
+	case string:
+		return tomlString, nil
+	case orderedObject:
+		return tomlTable, nil
+	case []interface{}:
+		elemType, err := tomlArrayType(v)
+		if err != nil {
+			return tomlNil, err
+		}
+		if elemType == tomlTable {
+			return tomlArrayOfTables, nil
+		}
+		return tomlArray, nil
+	}
+	return tomlNil, fmt.Errorf("toml: unsupported type %T", v)
+}
+
+// tomlArrayType returns the type of the elements of an array. Arrays mixing
+// integers and floats are arrays of floats.
+func tomlArrayType(array []interface{}) (tomlType, error) {
+	if len(array) == 0 {
+		return tomlNil, nil
+	}
+
+	var arrayType tomlType
+	for i, elem := range array {
+		elemType, err := tomlTypeOf(elem)
+		if err != nil {
+			return tomlNil, err
+		}
+		switch {
+		case elemType == tomlNil:
+			return tomlNil, errTOMLNilElement
+		case i == 0:
+			arrayType = elemType
+		case elemType == arrayType:
+		case isTOMLNumber(elemType) && isTOMLNumber(arrayType):
+			arrayType = tomlFloat
+		default:
+			return tomlNil, errTOMLMixedElementTypes
+		}
+	}
+
+	// Nested arrays can only contain primitives.
+	if arrayType == tomlArray || arrayType == tomlArrayOfTables {
+		nestedType, err := tomlArrayType(array[0].([]interface{}))
+		if err != nil {
+			return tomlNil, err
+		}
+		if nestedType == tomlTable {
+			return tomlNil, errTOMLArrayNoTable
+		}
+	}
+	return arrayType, nil
+}
+
+func isTOMLNumber(t tomlType) bool {
+	return t == tomlInteger || t == tomlFloat
+}
+
+type tomlWriter struct {
+	buf bytes.Buffer
+}
+
+// table writes the keys of a table. Keys holding values are written first,
+// followed by sub-tables and arrays of tables.
+func (w *tomlWriter) table(key []string, obj orderedObject) error {
+	var direct, sub orderedObject
+	for _, field := range obj {
+		fieldType, err := tomlTypeOf(field.value)
+		if err != nil {
+			return err
+		}
+		switch fieldType {
+		case tomlNil:
+			// Nothing is written for nulls.
+		case tomlTable, tomlArrayOfTables:
+			sub = append(sub, field)
+		default:
+			direct = append(direct, field)
+		}
+	}
+
+	for _, field := range append(direct, sub...) {
+		if err := w.value(append(key[:len(key):len(key)], field.key), field.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *tomlWriter) value(key []string, v interface{}) error {
+	if err := checkTOMLKey(key); err != nil {
+		return err
+	}
+	valueType, err := tomlTypeOf(v)
+	if err != nil {
+		return err
+	}
+
+	switch valueType {
+	case tomlTable:
+		if len(key) == 1 {
+			// Output an extra newline between top-level tables.
+			w.newline()
+		}
+		fmt.Fprintf(&w.buf, "%s[%s]", tomlIndent(key), tomlQuoteKey(key))
+		w.newline()
+		return w.table(key, v.(orderedObject))
+	case tomlArrayOfTables:
+		for _, elem := range v.([]interface{}) {
+			w.newline()
+			fmt.Fprintf(&w.buf, "%s[[%s]]", tomlIndent(key), tomlQuoteKey(key))
+			w.newline()
+			if err := w.table(key, elem.(orderedObject)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	fmt.Fprintf(&w.buf, "%s%s = ", tomlIndent(key), tomlQuoteKey(key[len(key)-1:]))
+	if err := w.element(v, valueType == tomlFloat); err != nil {
+		return err
+	}
+	w.newline()
+	return nil
+}
+
+// element writes a value that can be an array element. If asFloat is set,
+// integers are written as floats.
+func (w *tomlWriter) element(v interface{}, asFloat bool) error {
+	switch v := v.(type) {
+	case bool:
+		w.buf.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		// Numbers are written as they are, which is valid TOML for every
+		// JSON number. By the TOML spec, all floats must have a decimal or an
+		// exponent.
+		w.buf.WriteString(string(v))
+		if asFloat && isJSONInteger(v) {
+			w.buf.WriteString(".0")
+		}
+	case string:
+		w.buf.WriteString(`"` + tomlQuoter.Replace(v) + `"`)
+	case []interface{}:
+		elemType, err := tomlArrayType(v)
+		if err != nil {
+			return err
+		}
+		w.buf.WriteString("[")
+		for i, elem := range v {
+			if i > 0 {
+				w.buf.WriteString(", ")
+			}
+			if err := w.element(elem, elemType == tomlFloat); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteString("]")
+	default:
+		return errTOMLArrayNoTable
+	}
+	return nil
+}
+
+// newline writes a newline unless nothing has been written yet.
+func (w *tomlWriter) newline() {
+	if w.buf.Len() > 0 {
+		w.buf.WriteByte('\n')
+	}
+}
+
+func checkTOMLKey(key []string) error {
+	for _, k := range key {
+		if k == "" {
+			return fmt.Errorf("toml: key '%s' is not a valid table name, key names cannot be empty", tomlQuoteKey(key))
+		}
+	}
+	return nil
+}
+
+func tomlIndent(key []string) string {
+	return strings.Repeat("  ", len(key)-1)
+}
+
+// tomlQuoteKey joins the parts of a key with dots, quoting the parts that
+// aren't bare keys.
+func tomlQuoteKey(key []string) string {
+	parts := make([]string, len(key))
+	for i, k := range key {
+		parts[i] = k
+		for _, c := range k {
+			if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+				parts[i] = `"` + strings.Replace(k, `"`, `\"`, -1) + `"`
+				break
+			}
+		}
+	}
+	return strings.Join(parts, ".")
 }
 
 func (tomlEncoder) prettyPrint(tomlBytes []byte) ([]byte, error) { return tomlBytes, nil }
@@ -0,0 +1,739 @@
+package objconv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var _ Editor = tomlEncoding{}
+
+// NewEditEncoder implements Editor.
+//
+// Edits are made to the text of the document: values that changed are
+// rewritten where they are, keys and tables are removed along with the
+// comments above them, new keys are added after the last key of their table
+// and new tables are added at the end of the document.
+func (tomlEncoding) NewEditEncoder(w io.Writer, original []byte, reencode bool) EditEncoder {
+	return &tomlEditEncoder{w: w, original: original, reencode: reencode, last: -1}
+}
+
+type tomlEditEncoder struct {
+	w        io.Writer
+	original []byte
+	reencode bool
+	// last is the index of the last document that was edited, which is -1
+	// until the only document of original is.
+	last int
+}
+
+func (e *tomlEditEncoder) EditJSONBytes(document int, jsonBytes []byte, color, pretty bool) error {
+	var out []byte
+	if document < 1 {
+		err := checkEditable(document, e.last)
+		if err == nil {
+			out = editTOMLDocument(e.original, jsonBytes)
+			if out == nil {
+				err = &EditError{Document: 0, Err: errEditMismatch}
+			} else {
+				e.last = 0
+			}
+		}
+		if err != nil && !e.reencode {
+			return err
+		}
+	}
+	if out == nil {
+		encoded, err := (tomlEncoder{}).unmarshalJSONBytes(jsonBytes)
+		if err != nil {
+			return fmt.Errorf("failed to encode as: %s", err)
+		}
+		out = append(encoded, '\n')
+	}
+
+	if color {
+		colored, err := (tomlEncoder{}).color(out)
+		if err != nil {
+			return fmt.Errorf("failed to encode as colored: %s", err)
+		}
+		out = colored
+	}
+	_, err := e.w.Write(out)
+	return err
+}
+
+// editTOMLDocument returns the TOML document src edited to have the value of
+// jsonBytes, or nil if it can't be.
+func editTOMLDocument(src, jsonBytes []byte) []byte {
+	value, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return nil
+	}
+	desired, ok := dropNulls(value).(orderedObject)
+	if !ok {
+		return nil
+	}
+	current, ok := decodeTOMLDocument(src)
+	if !ok {
+		return nil
+	}
+	sections, pairs, ok := parseTOMLDocument(src)
+	if !ok {
+		return nil
+	}
+
+	e := &tomlEditor{src: src, sections: sections, pairs: pairs, written: make(map[string]bool)}
+	for _, pair := range pairs {
+		e.written[tomlPathKey(pair.path)] = true
+	}
+	if !e.editPairs(desired, current) || !e.add(nil, desired, current) {
+		return nil
+	}
+	// Removing the last table leaves the blank lines above it.
+	trimEnd := e.appended.Len() > 0
+	for _, edit := range e.edits {
+		trimEnd = trimEnd || (edit.end == len(src) && edit.text == "")
+	}
+	out := applyEdits(src, e.edits)
+	if trimEnd {
+		out = append(bytes.TrimRight(out, "\n"), '\n')
+	}
+	out = append(out, e.appended.Bytes()...)
+
+	// The edited document must decode to the value it was edited to have.
+	if value, ok := decodeTOMLDocument(out); !ok || !valuesEqual(value, desired) {
+		return nil
+	}
+	return out
+}
+
+// decodeTOMLDocument decodes a TOML document with the TOML Decoder.
+func decodeTOMLDocument(src []byte) (orderedObject, bool) {
+	data, err := (tomlEncoding{}).NewDecoder(bytes.NewReader(src)).MarshalJSONBytes()
+	if err != nil {
+		return nil, false
+	}
+	value, err := decodeOrderedJSON(data)
+	if err != nil {
+		return nil, false
+	}
+	obj, ok := value.(orderedObject)
+	return obj, ok
+}
+
+// dropNulls removes the keys of objects whose values are null, which the TOML
+// Encoder doesn't write.
+func dropNulls(v interface{}) interface{} {
+	switch v := v.(type) {
+	case orderedObject:
+		obj := orderedObject{}
+		for _, field := range v {
+			if field.value != nil {
+				obj = append(obj, orderedField{field.key, dropNulls(field.value)})
+			}
+		}
+		return obj
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, elem := range v {
+			array[i] = dropNulls(elem)
+		}
+		return array
+	}
+	return v
+}
+
+// A tomlValuePath is the path of a value of a TOML document: the keys of the
+// tables it's in, along with the index of the elements of the arrays of
+// tables it's in.
+type tomlValuePath []interface{}
+
+func (p tomlValuePath) with(step interface{}) tomlValuePath {
+	return append(p[:len(p):len(p)], step)
+}
+
+// tomlPathKey returns a string that identifies path.
+func tomlPathKey(path tomlValuePath) string {
+	var key strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			key.WriteString("\x00k" + step)
+		case int:
+			key.WriteString("\x00i" + strconv.Itoa(step))
+		}
+	}
+	return key.String()
+}
+
+// lookupTOMLPath returns the value at path, if it's there and isn't null.
+func lookupTOMLPath(v interface{}, path tomlValuePath) (interface{}, bool) {
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			obj, ok := v.(orderedObject)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj.get(step); !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := v.([]interface{})
+			if !ok || step >= len(array) {
+				return nil, false
+			}
+			v = array[step]
+		}
+		if v == nil {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// tomlSection is a table of a TOML document that has a header, or the root
+// table.
+type tomlSection struct {
+	path tomlValuePath
+
+	// start is the offset of its header, and end is the offset of the next
+	// header or the comments above it.
+	start, end int
+
+	// last is the offset after its last key/value pair, or after its
+	// header, and indent is the indentation of that line.
+	last   int
+	indent string
+}
+
+// tomlPair is a key/value pair of a TOML document.
+type tomlPair struct {
+	path    tomlValuePath
+	section *tomlSection
+
+	// start and end are the offsets of the lines of the pair, and value
+	// those of its value.
+	start, end           int
+	valueStart, valueEnd int
+}
+
+// parseTOMLDocument finds the tables and key/value pairs of a TOML document.
+func parseTOMLDocument(src []byte) ([]*tomlSection, []*tomlPair, bool) {
+	p := &tomlParser{src: src}
+	section := &tomlSection{}
+	sections := []*tomlSection{section}
+	var pairs []*tomlPair
+	// arrays holds the number of elements of each array of tables.
+	arrays := make(map[string]int)
+
+	for p.pos < len(src) {
+		lineStart := p.pos
+		p.skipSpace()
+		indent := string(src[lineStart:p.pos])
+		switch {
+		case p.endLine():
+			// A blank or comment line.
+		case src[p.pos] == '[':
+			array := p.hasPrefix("[[")
+			p.pos++
+			if array {
+				p.pos++
+			}
+			keys, ok := p.key()
+			if !ok || !p.consume("]") || (array && !p.consume("]")) || !p.endLine() {
+				return nil, nil, false
+			}
+
+			// Headers refer to the last element of the arrays of tables
+			// they're in.
+			var path tomlValuePath
+			for i, key := range keys {
+				path = path.with(key)
+				n, ok := arrays[tomlPathKey(path)]
+				switch {
+				case array && i == len(keys)-1:
+					arrays[tomlPathKey(path)] = n + 1
+					path = path.with(n)
+				case ok:
+					path = path.with(n - 1)
+				}
+			}
+			section = &tomlSection{path: path, start: lineStart, last: p.pos, indent: indent}
+			sections = append(sections, section)
+		default:
+			keys, ok := p.key()
+			if !ok || !p.consume("=") {
+				return nil, nil, false
+			}
+			p.skipSpace()
+			valueStart := p.pos
+			if !p.value() {
+				return nil, nil, false
+			}
+			valueEnd := p.pos
+			if !p.endLine() {
+				return nil, nil, false
+			}
+			path := section.path
+			for _, key := range keys {
+				path = path.with(key)
+			}
+			pairs = append(pairs, &tomlPair{path, section, lineStart, p.pos, valueStart, valueEnd})
+			section.last = p.pos
+			section.indent = indent
+		}
+	}
+
+	for i, section := range sections {
+		section.end = len(src)
+		if i+1 < len(sections) {
+			section.end = tomlHeadStart(src, sections[i+1].start)
+		}
+	}
+	return sections, pairs, true
+}
+
+// tomlHeadStart returns offset, the start of a line, or the start of the
+// comment lines directly above it.
+func tomlHeadStart(src []byte, offset int) int {
+	for offset > 0 {
+		previous := bytes.LastIndexByte(src[:offset-1], '\n') + 1
+		if !bytes.HasPrefix(bytes.TrimLeft(src[previous:offset], " \t"), []byte("#")) {
+			break
+		}
+		offset = previous
+	}
+	return offset
+}
+
+// tomlParser scans the lines of a TOML document, skipping over the values it
+// doesn't need to decode.
+type tomlParser struct {
+	src []byte
+	pos int
+}
+
+func (p *tomlParser) hasPrefix(s string) bool {
+	return bytes.HasPrefix(p.src[p.pos:], []byte(s))
+}
+
+// consume skips whitespace and s, reporting whether s was there.
+func (p *tomlParser) consume(s string) bool {
+	p.skipSpace()
+	if !p.hasPrefix(s) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipComment skips the comment starting at the current offset, if there is
+// one.
+func (p *tomlParser) skipComment() {
+	if p.pos < len(p.src) && p.src[p.pos] == '#' {
+		for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+}
+
+// endLine skips whitespace, a comment and a newline, reporting whether
+// nothing else was left on the line.
+func (p *tomlParser) endLine() bool {
+	p.skipSpace()
+	p.skipComment()
+	switch {
+	case p.pos == len(p.src):
+		return true
+	case p.hasPrefix("\n"):
+		p.pos++
+		return true
+	case p.hasPrefix("\r\n"):
+		p.pos += 2
+		return true
+	}
+	return false
+}
+
+// key scans a dotted key.
+func (p *tomlParser) key() ([]string, bool) {
+	var keys []string
+	for {
+		p.skipSpace()
+		start := p.pos
+		switch {
+		case p.hasPrefix(`"`):
+			if !p.string() {
+				return nil, false
+			}
+			key, err := strconv.Unquote(string(p.src[start:p.pos]))
+			if err != nil {
+				return nil, false
+			}
+			keys = append(keys, key)
+		case p.hasPrefix("'"):
+			if !p.string() {
+				return nil, false
+			}
+			keys = append(keys, string(p.src[start+1:p.pos-1]))
+		default:
+			for p.pos < len(p.src) && isTOMLBareKeyByte(p.src[p.pos]) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, false
+			}
+			keys = append(keys, string(p.src[start:p.pos]))
+		}
+		p.skipSpace()
+		if !p.hasPrefix(".") {
+			return keys, true
+		}
+		p.pos++
+	}
+}
+
+func isTOMLBareKeyByte(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// value scans a value.
+func (p *tomlParser) value() bool {
+	if p.pos == len(p.src) {
+		return false
+	}
+	switch p.src[p.pos] {
+	case '"', '\'':
+		return p.string()
+	case '[', '{':
+		return p.bracketed()
+	}
+
+	start := p.pos
+	for p.pos < len(p.src) && bytes.IndexByte([]byte(" \t\r\n#,]}"), p.src[p.pos]) < 0 {
+		p.pos++
+	}
+	// The date and time of a datetime may be separated by a space.
+	if p.pos-start == 10 && p.src[start+4] == '-' && p.hasPrefix(" ") && p.pos+1 < len(p.src) && '0' <= p.src[p.pos+1] && p.src[p.pos+1] <= '9' {
+		p.pos++
+		for p.pos < len(p.src) && bytes.IndexByte([]byte(" \t\r\n#,]}"), p.src[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	return p.pos > start
+}
+
+// string scans a basic or literal string, on one line or many.
+func (p *tomlParser) string() bool {
+	quote := p.src[p.pos]
+	delim := string(quote)
+	if p.hasPrefix(strings.Repeat(delim, 3)) {
+		delim = strings.Repeat(delim, 3)
+	}
+	p.pos += len(delim)
+	for p.pos < len(p.src) {
+		switch {
+		case quote == '"' && p.src[p.pos] == '\\':
+			p.pos += 2
+		case len(delim) == 1 && p.src[p.pos] == '\n':
+			return false
+		case p.hasPrefix(delim):
+			p.pos += len(delim)
+			// Up to two quotes may come right before the closing ones.
+			for i := 0; len(delim) == 3 && i < 2 && p.pos < len(p.src) && p.src[p.pos] == quote; i++ {
+				p.pos++
+			}
+			return true
+		default:
+			p.pos++
+		}
+	}
+	return false
+}
+
+// bracketed scans an array or an inline table.
+func (p *tomlParser) bracketed() bool {
+	depth := 0
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '"', '\'':
+			if !p.string() {
+				return false
+			}
+			continue
+		case '#':
+			p.skipComment()
+			continue
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		}
+		p.pos++
+		if depth == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// tomlEditor edits the text of a TOML document using the offsets of its
+// tables and key/value pairs.
+type tomlEditor struct {
+	src      []byte
+	sections []*tomlSection
+	pairs    []*tomlPair
+	edits    []textEdit
+
+	// written holds the paths of the key/value pairs of the document.
+	written map[string]bool
+
+	// appended holds the tables added to the end of the document.
+	appended bytes.Buffer
+}
+
+// editPairs removes the tables and key/value pairs that aren't in desired and
+// rewrites the values of the pairs that changed.
+func (e *tomlEditor) editPairs(desired, current orderedObject) bool {
+	removed := make(map[*tomlSection]bool)
+	for _, section := range e.sections[1:] {
+		v, ok := lookupTOMLPath(desired, section.path)
+		if !ok {
+			removed[section] = true
+			e.edits = append(e.edits, textEdit{tomlHeadStart(e.src, section.start), section.end, ""})
+			continue
+		}
+		// A table can't become a value where its header is.
+		if _, ok := v.(orderedObject); !ok {
+			return false
+		}
+	}
+
+	for _, pair := range e.pairs {
+		if removed[pair.section] {
+			continue
+		}
+		v, ok := lookupTOMLPath(desired, pair.path)
+		if !ok {
+			e.edits = append(e.edits, textEdit{tomlHeadStart(e.src, pair.start), pair.end, ""})
+			continue
+		}
+		if old, _ := lookupTOMLPath(current, pair.path); valuesEqual(old, v) {
+			continue
+		}
+		text, ok := tomlInlineValue(v, e.src[pair.valueStart:pair.valueEnd])
+		if !ok {
+			return false
+		}
+		e.edits = append(e.edits, textEdit{pair.valueStart, pair.valueEnd, text})
+	}
+	return true
+}
+
+// add adds the keys of the table desired at path that aren't in current, the
+// value of the table in the document.
+func (e *tomlEditor) add(path tomlValuePath, desired, current orderedObject) bool {
+	var fields orderedObject
+	for _, field := range desired {
+		fieldPath := path.with(field.key)
+		old, ok := current.get(field.key)
+		if !ok {
+			fields = append(fields, field)
+			continue
+		}
+		if e.written[tomlPathKey(fieldPath)] {
+			continue
+		}
+
+		switch v := field.value.(type) {
+		case orderedObject:
+			obj, ok := old.(orderedObject)
+			if !ok || !e.add(fieldPath, v, obj) {
+				return false
+			}
+		case []interface{}:
+			array, ok := old.([]interface{})
+			if !ok {
+				return false
+			}
+			for i, elem := range v {
+				obj, ok := elem.(orderedObject)
+				if !ok {
+					return false
+				}
+				if i >= len(array) {
+					if !e.appendTable(fieldPath, []interface{}{obj}) {
+						return false
+					}
+					continue
+				}
+				oldObj, ok := array[i].(orderedObject)
+				if !ok || !e.add(fieldPath.with(i), obj, oldObj) {
+					return false
+				}
+			}
+		default:
+			// A table became a value.
+			return false
+		}
+	}
+	if len(fields) == 0 {
+		return true
+	}
+
+	var section *tomlSection
+	for _, s := range e.sections {
+		if tomlPathKey(s.path) == tomlPathKey(path) {
+			section = s
+		}
+	}
+
+	var text strings.Builder
+	var tables, values orderedObject
+	for _, field := range fields {
+		valueType, err := tomlTypeOf(field.value)
+		if err != nil {
+			return false
+		}
+		switch {
+		case valueType == tomlTable || valueType == tomlArrayOfTables:
+			tables = append(tables, field)
+		case section != nil:
+			value, ok := tomlInlineValue(field.value, nil)
+			if !ok {
+				return false
+			}
+			text.WriteString(section.indent + tomlQuoteKey([]string{field.key}) + " = " + value + "\n")
+		default:
+			values = append(values, field)
+		}
+	}
+	if text.Len() > 0 {
+		offset := section.last
+		prefix := ""
+		if offset > 0 && e.src[offset-1] != '\n' {
+			prefix = "\n"
+		}
+		e.edits = append(e.edits, textEdit{offset, offset, prefix + text.String()})
+	}
+	// Tables without a header get one at the end of the document.
+	if len(values) > 0 && !e.appendTable(path, values) {
+		return false
+	}
+	for _, field := range tables {
+		if !e.appendTable(path.with(field.key), field.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendTable adds a table or an array of tables to the end of the document.
+// Its header refers to the last element of the arrays of tables on its path.
+func (e *tomlEditor) appendTable(path tomlValuePath, v interface{}) bool {
+	var key []string
+	for _, step := range path {
+		if k, ok := step.(string); ok {
+			key = append(key, k)
+		}
+	}
+	if len(key) == 0 {
+		return false
+	}
+
+	w := tomlWriter{}
+	if err := w.value(key, v); err != nil {
+		return false
+	}
+	text := strings.Trim(w.buf.String(), "\n")
+	if !e.indented() {
+		lines := strings.Split(text, "\n")
+		for i := range lines {
+			lines[i] = strings.TrimLeft(lines[i], " ")
+		}
+		text = strings.Join(lines, "\n")
+	}
+	e.appended.WriteString("\n" + text + "\n")
+	return true
+}
+
+// indented reports whether the keys of the tables of the document are
+// indented, as the TOML Encoder does.
+func (e *tomlEditor) indented() bool {
+	for _, section := range e.sections {
+		if section.indent != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// tomlInlineValue returns the TOML for a value written on the right of a key.
+// Strings are written as literal strings if old is one and they can be.
+func tomlInlineValue(v interface{}, old []byte) (string, bool) {
+	if s, ok := v.(string); ok && bytes.HasPrefix(old, []byte("'")) && !bytes.HasPrefix(old, []byte("'''")) &&
+		strings.IndexFunc(s, func(r rune) bool { return r == '\'' || r < ' ' || r == 0x7f }) < 0 {
+		return "'" + s + "'", true
+	}
+
+	w := tomlWriter{}
+	if err := writeTOMLInline(&w, v); err != nil {
+		return "", false
+	}
+	return w.buf.String(), true
+}
+
+// writeTOMLInline writes a value, writing tables as inline tables.
+func writeTOMLInline(w *tomlWriter, v interface{}) error {
+	switch v := v.(type) {
+	case orderedObject:
+		w.buf.WriteString("{")
+		for i, field := range v {
+			if i > 0 {
+				w.buf.WriteString(",")
+			}
+			w.buf.WriteString(" " + tomlQuoteKey([]string{field.key}) + " = ")
+			if err := writeTOMLInline(w, field.value); err != nil {
+				return err
+			}
+		}
+		if len(v) > 0 {
+			w.buf.WriteString(" ")
+		}
+		w.buf.WriteString("}")
+		return nil
+	case []interface{}:
+		elemType, err := tomlArrayType(v)
+		if err != nil {
+			return err
+		}
+		if elemType != tomlTable {
+			return w.element(v, false)
+		}
+		w.buf.WriteString("[")
+		for i, elem := range v {
+			if i > 0 {
+				w.buf.WriteString(", ")
+			}
+			if err := writeTOMLInline(w, elem); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteString("]")
+		return nil
+	}
+	valueType, err := tomlTypeOf(v)
+	if err != nil {
+		return err
+	}
+	return w.element(v, valueType == tomlFloat)
+}
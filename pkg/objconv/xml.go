@@ -3,24 +3,45 @@ package objconv
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/alecthomas/chroma/quick"
-	"github.com/clbanning/mxj/v2"
 	"golang.org/x/net/html/charset"
 )
 
-func init() {
-	mxj.XmlCharsetReader = charset.NewReaderLabel
-}
-
 var (
-	_ Encoding = xmlEncoding{}
-	_ Decoder  = &xmlDecoder{}
-	_ Encoder  = &xmlEncoder{}
+	_ Encoding       = xmlEncoding{}
+	_ StreamEncoding = xmlEncoding{}
+	_ Decoder        = &xmlDecoder{}
+	_ Decoder        = &xmlStreamDecoder{}
+	_ Encoder        = &xmlEncoder{}
+)
+
+// XML is mapped to JSON the way github.com/clbanning/mxj does it: an element
+// is an object whose keys are its attributes, prefixed with xmlAttrPrefix,
+// and its child elements. Repeated child elements become an array, the text
+// of an element with attributes or children is stored under xmlTextKey, as an
+// array of its runs if child elements split it, and an element with neither
+// is its text.
+const (
+	xmlAttrPrefix = "-"
+	xmlTextKey    = "#text"
+
+	// xmlDefaultRoot is the root element used when a value doesn't have a
+	// single key to use as its root.
+	xmlDefaultRoot = "doc"
+)
+
+var xmlEscaper = strings.NewReplacer(
+	"&", "&amp;",
+	"<", "&lt;",
+	">", "&gt;",
+	`"`, "&quot;",
+	"'", "&apos;",
 )
 
 type xmlEncoding struct{}
@@ -29,6 +50,18 @@ func (xmlEncoding) NewDecoder(r io.Reader) Decoder {
 	return &xmlDecoder{r, false}
 }
 
+// NewStreamDecoder implements StreamEncoding. The child elements of the root
+// element are decoded whole, and those named like its first child are
+// streamed as soon as they're read, so a document that is a list of elements,
+// such as <items><item/>...</items>, is streamed an element at a time. Its
+// other children are held until the root element is closed, since a child
+// repeated later becomes an array in their place.
+func (xmlEncoding) NewStreamDecoder(r io.Reader) Decoder {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	return &xmlStreamDecoder{decoder: decoder}
+}
+
 func (e xmlEncoding) NewEncoder(w io.Writer) Encoder {
 	return &xmlEncoder{w}
 }
@@ -39,40 +72,320 @@ type xmlDecoder struct {
 }
 
 func (d *xmlDecoder) MarshalJSONBytes() ([]byte, error) {
-	mxj.XMLEscapeChars(false)
 	if d.read {
 		return nil, io.EOF
 	}
-	xmlBytes, err := ioutil.ReadAll(d.r)
-	if err != nil {
-		return nil, err
-	}
 	d.read = true
 
-	xmap, err := mxj.NewMapXml(xmlBytes, true)
+	obj, err := decodeOrderedXML(d.r, true)
 	if err != nil {
 		return nil, err
 	}
-	return xmap.Json()
+	return marshalJSONValue(obj)
 }
 
-type xmlEncoder struct {
-	w io.Writer
+// decodeOrderedXML decodes the root element of an XML document as an
+// orderedObject with a single key, the name of the root element. If cast is
+// set, text that looks like a number or a boolean is decoded as one.
+func decodeOrderedXML(r io.Reader, cast bool) (orderedObject, error) {
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			value, err := decodeXMLElement(decoder, start, cast)
+			if err != nil {
+				return nil, err
+			}
+			return orderedObject{{start.Name.Local, value}}, nil
+		}
+	}
 }
 
-func (e xmlEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
-	var tmp interface{}
-	err := json.Unmarshal(jsonBytes, &tmp)
+// decodeXMLElement decodes the contents of the element started by start.
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement, cast bool) (interface{}, error) {
+	element := newXMLElement(start, cast)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			value, err := decodeXMLElement(decoder, token, cast)
+			if err != nil {
+				return nil, err
+			}
+			element.addChild(token.Name.Local, value)
+		case xml.CharData:
+			element.addText(token)
+		case xml.EndElement:
+			return element.value(), nil
+		}
+	}
+}
+
+// xmlElement builds the value of an element from its contents.
+type xmlElement struct {
+	obj  orderedObject
+	cast bool
+	// attrs is the number of attributes, which come first in obj.
+	attrs int
+	// text is the text since the last child element.
+	text []byte
+}
+
+// newXMLElement returns an xmlElement with the attributes of start.
+func newXMLElement(start xml.StartElement, cast bool) *xmlElement {
+	e := &xmlElement{obj: orderedObject{}, cast: cast}
+	for _, attr := range start.Attr {
+		e.obj = e.obj.set(xmlAttrPrefix+attr.Name.Local, xmlValue(attr.Value, cast))
+	}
+	e.attrs = len(e.obj)
+	return e
+}
+
+// addChild adds a child element.
+func (e *xmlElement) addChild(name string, value interface{}) {
+	e.endText()
+	e.obj = appendXMLChild(e.obj, name, value)
+}
+
+// addText adds text data. Comments and processing instructions don't split
+// text, so text is only added once a child element or the end of the element
+// is reached.
+func (e *xmlElement) addText(data []byte) {
+	e.text = append(e.text, data...)
+}
+
+// endText adds the text since the last child element, ignoring whitespace
+// around it. Like repeated child elements, text split by child elements
+// becomes an array of its runs.
+func (e *xmlElement) endText() {
+	s := strings.Trim(string(e.text), "\t\r\b\n ")
+	e.text = e.text[:0]
+	if s != "" {
+		e.obj = appendXMLChild(e.obj, xmlTextKey, xmlValue(s, e.cast))
+	}
+}
+
+// hasText reports whether text has been added.
+func (e *xmlElement) hasText() bool {
+	e.endText()
+	_, ok := e.obj.get(xmlTextKey)
+	return ok
+}
+
+// value returns the value of the element.
+func (e *xmlElement) value() interface{} {
+	e.endText()
+	switch {
+	case len(e.obj) == 0:
+		return ""
+	case len(e.obj) == 1 && e.obj[0].key == xmlTextKey:
+		if _, ok := e.obj[0].value.([]interface{}); !ok {
+			return e.obj[0].value
+		}
+	}
+	return e.obj
+}
+
+// xmlStreamDecoder returns the events of the root element of an XML document.
+type xmlStreamDecoder struct {
+	decoder  *xml.Decoder
+	streamer streamer
+	events   [][]byte
+	done     bool
+
+	// root is the root element once it has started. If streaming is set,
+	// its first child isn't added to it but streamed, and the field at
+	// firstIndex holds its place. Its value is held in firstValue until a
+	// second child with its name makes it an array, and firstCount is the
+	// number of children with its name.
+	root       *xmlElement
+	rootName   string
+	streaming  bool
+	firstIndex int
+	firstValue interface{}
+	firstCount int
+}
+
+func (d *xmlStreamDecoder) MarshalJSONBytes() ([]byte, error) {
+	for len(d.events) == 0 {
+		if d.done {
+			return nil, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	event := d.events[0]
+	d.events = d.events[1:]
+	return event, nil
+}
+
+// next reads the next token of the root element, adding the events it
+// completes.
+func (d *xmlStreamDecoder) next() error {
+	token, err := d.decoder.Token()
+	if err == io.EOF && d.root != nil {
+		return io.ErrUnexpectedEOF
+	}
 	if err != nil {
 		return err
 	}
-	if reflect.ValueOf(tmp).Kind() != reflect.Map {
-		newObj := map[string]interface{}{"root": tmp}
-		jsonBytes, err = json.Marshal(newObj)
+
+	switch token := token.(type) {
+	case xml.StartElement:
+		if d.root == nil {
+			d.root = newXMLElement(token, true)
+			d.rootName = token.Name.Local
+			return nil
+		}
+		value, err := decodeXMLElement(d.decoder, token, true)
+		if err != nil {
+			return err
+		}
+		return d.addChild(token.Name.Local, value)
+	case xml.CharData:
+		if d.root != nil {
+			d.root.addText(token)
+		}
+	case xml.EndElement:
+		return d.end()
+	}
+	return nil
+}
+
+// addChild adds a child element of the root element.
+func (d *xmlStreamDecoder) addChild(name string, value interface{}) error {
+	var err error
+	d.root.endText()
+	switch {
+	case d.streaming && name == d.root.obj[d.firstIndex].key:
+		d.firstCount++
+		if d.firstCount == 2 {
+			d.streamer.setKey(name)
+			d.streamer.open(false)
+			if d.events, err = appendStreamEvents(d.events, &d.streamer, d.firstValue); err != nil {
+				return err
+			}
+			d.firstValue = nil
+		}
+		d.events, err = appendStreamEvents(d.events, &d.streamer, value)
+	case !d.streaming && len(d.root.obj) == d.root.attrs && !d.root.hasText():
+		// Only the attributes of the root come before its first child.
+		// Text before it could still be added to, so nothing is streamed
+		// if there is any.
+		d.streamer.open(true)
+		d.streamer.setKey(d.rootName)
+		d.streamer.open(true)
+		for _, field := range d.root.obj {
+			d.streamer.setKey(field.key)
+			if d.events, err = appendStreamEvents(d.events, &d.streamer, field.value); err != nil {
+				return err
+			}
+		}
+		d.streaming = true
+		d.firstIndex = len(d.root.obj)
+		d.firstValue, d.firstCount = value, 1
+		d.root.obj = append(d.root.obj, orderedField{key: name})
+	default:
+		d.root.addChild(name, value)
+	}
+	return err
+}
+
+// end closes the root element, adding the events of what was held.
+func (d *xmlStreamDecoder) end() error {
+	d.done = true
+	var err error
+	if !d.streaming {
+		document := orderedObject{{d.rootName, d.root.value()}}
+		d.events, err = appendStreamEvents(d.events, &d.streamer, document)
+		return err
+	}
+
+	// The fields before the first child have already been streamed.
+	d.root.endText()
+	for i, field := range d.root.obj[d.firstIndex:] {
+		switch {
+		case i == 0 && d.firstCount == 1:
+			d.streamer.setKey(field.key)
+			d.events, err = appendStreamEvents(d.events, &d.streamer, d.firstValue)
+		case i == 0:
+			d.events, err = appendStreamEvent(d.events, d.streamer.close())
+		default:
+			d.streamer.setKey(field.key)
+			d.events, err = appendStreamEvents(d.events, &d.streamer, field.value)
+		}
 		if err != nil {
 			return err
 		}
 	}
+	if d.events, err = appendStreamEvent(d.events, d.streamer.close()); err != nil {
+		return err
+	}
+	d.events, err = appendStreamEvent(d.events, d.streamer.close())
+	return err
+}
+
+// appendXMLChild adds a child element to obj, turning the value of key into
+// an array if there already is a child with the same name.
+func appendXMLChild(obj orderedObject, key string, value interface{}) orderedObject {
+	for i := range obj {
+		if obj[i].key == key {
+			if array, ok := obj[i].value.([]interface{}); ok {
+				obj[i].value = append(array, value)
+			} else {
+				obj[i].value = []interface{}{obj[i].value, value}
+			}
+			return obj
+		}
+	}
+	return append(obj, orderedField{key, value})
+}
+
+// xmlValue casts s to a number or a boolean if cast is set and s looks like
+// one. Numbers that are valid in JSON keep their exact text.
+func xmlValue(s string, cast bool) interface{} {
+	if !cast {
+		return s
+	}
+	if isJSONNumber(s) {
+		return json.Number(s)
+	}
+	switch strings.ToLower(s) {
+	case "nan", "inf", "-inf", "+inf", "infinity", "-infinity", "+infinity":
+		return s
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if len(s) < 6 {
+		switch s[:1] {
+		case "t", "T", "f", "F":
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b
+			}
+		}
+	}
+	return s
+}
+
+type xmlEncoder struct {
+	w io.Writer
+}
+
+func (e xmlEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) error {
 	out, err := internalEncode(e, jsonBytes, color, pretty)
 	if err != nil {
 		return err
@@ -82,21 +395,176 @@ func (e xmlEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) err
 }
 
 func (xmlEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
-	mxj.XMLEscapeChars(true)
-	xmap, err := mxj.NewMapJson(jsonBytes)
+	value, err := decodeOrderedJSON(jsonBytes)
 	if err != nil {
 		return nil, err
 	}
-	return xmap.Xml()
+	obj, ok := value.(orderedObject)
+	if !ok {
+		obj = orderedObject{{"root", value}}
+	}
+
+	var buf bytes.Buffer
+	if err := writeXMLDocument(&buf, obj, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 func (xmlEncoder) prettyPrint(xmlBytes []byte) ([]byte, error) {
-	xmap, err := mxj.NewMapXml(xmlBytes, true)
+	obj, err := decodeOrderedXML(bytes.NewReader(xmlBytes), false)
 	if err != nil {
 		return nil, err
 	}
 
-	return xmap.XmlIndent("", "  ")
+	var buf bytes.Buffer
+	if err := writeXMLDocument(&buf, obj, true); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeXMLDocument writes obj as an XML document. If obj has a single key,
+// it's used as the root element, otherwise obj is the root element.
+func writeXMLDocument(buf *bytes.Buffer, obj orderedObject, indent bool) error {
+	w := xmlWriter{buf, indent}
+	if len(obj) == 1 {
+		// An array would become several root elements.
+		if _, ok := obj[0].value.([]interface{}); !ok {
+			return w.element(obj[0].key, obj[0].value, 0)
+		}
+	}
+	return w.element(xmlDefaultRoot, obj, 0)
+}
+
+// xmlWriter writes the elements of a document in the order of the keys of
+// its objects.
+type xmlWriter struct {
+	buf    *bytes.Buffer
+	indent bool
+}
+
+func (w xmlWriter) element(name string, value interface{}, depth int) error {
+	switch value := value.(type) {
+	case []interface{}:
+		if len(value) == 0 {
+			w.startLine(depth)
+			w.buf.WriteString("<" + name + "/>")
+			return nil
+		}
+		for i, elem := range value {
+			if i > 0 && w.indent {
+				w.buf.WriteByte('\n')
+			}
+			if err := w.element(name, elem, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	case orderedObject:
+		return w.object(name, value, depth)
+	}
+
+	w.startLine(depth)
+	text, err := xmlText(name, value)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		w.buf.WriteString("<" + name + "/>")
+	} else {
+		w.buf.WriteString("<" + name + ">" + text + "</" + name + ">")
+	}
+	return nil
+}
+
+func (w xmlWriter) object(name string, obj orderedObject, depth int) error {
+	w.startLine(depth)
+	w.buf.WriteString("<" + name)
+
+	// The text is written in the place of its key among the children. Only
+	// the place of its first run is known if it has several, so the others
+	// are written after the last child.
+	var content orderedObject
+	var runs []interface{}
+	for _, field := range obj {
+		switch {
+		case field.key == xmlTextKey:
+			texts, ok := field.value.([]interface{})
+			if !ok {
+				texts = []interface{}{field.value}
+			}
+			if len(texts) != 0 {
+				content = append(content, orderedField{xmlTextKey, texts[0]})
+				runs = texts[1:]
+			}
+		case len(field.key) > len(xmlAttrPrefix) && strings.HasPrefix(field.key, xmlAttrPrefix):
+			switch field.value.(type) {
+			case string, json.Number, bool:
+			default:
+				return fmt.Errorf("invalid attribute value for: %s", field.key)
+			}
+			s, _ := xmlText(field.key, field.value)
+			w.buf.WriteString(" " + field.key[len(xmlAttrPrefix):] + `="` + s + `"`)
+		default:
+			content = append(content, field)
+		}
+	}
+	for _, run := range runs {
+		content = append(content, orderedField{xmlTextKey, run})
+	}
+
+	if len(content) == 0 {
+		w.buf.WriteString("/>")
+		return nil
+	}
+	w.buf.WriteString(">")
+	children := false
+	for _, field := range content {
+		if field.key == xmlTextKey {
+			s, err := xmlText(xmlTextKey, field.value)
+			if err != nil {
+				return err
+			}
+			w.buf.WriteString(s)
+			continue
+		}
+		if w.indent {
+			w.buf.WriteByte('\n')
+		}
+		if err := w.element(field.key, field.value, depth+1); err != nil {
+			return err
+		}
+		children = true
+	}
+	if children && w.indent {
+		w.buf.WriteByte('\n')
+		w.startLine(depth)
+	}
+	w.buf.WriteString("</" + name + ">")
+	return nil
+}
+
+// startLine indents the start of an element at depth.
+func (w xmlWriter) startLine(depth int) {
+	if w.indent {
+		w.buf.WriteString(strings.Repeat("  ", depth))
+	}
+}
+
+// xmlText returns the escaped text of a scalar value.
+func xmlText(name string, value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return xmlEscaper.Replace(value), nil
+	case json.Number:
+		return string(value), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	}
+	return "", fmt.Errorf("invalid value for: %s", name)
 }
 
 func (xmlEncoder) color(xmlBytes []byte) ([]byte, error) {
@@ -105,3 +105,33 @@ func TestNonUTF8Encoding(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestXMLMixedContent(t *testing.T) {
+	table := []struct {
+		xml    string
+		json   string
+		output string
+	}{
+		{`<m>a<i>b</i>c</m>`, `{"m":{"#text":["a","c"],"i":"b"}}`, `<m>a<i>b</i>c</m>`},
+		{`<m><i>b</i>c</m>`, `{"m":{"i":"b","#text":"c"}}`, `<m><i>b</i>c</m>`},
+		{`<m x="1">a<!-- comment -->b<i/>c<i/>d</m>`, `{"m":{"-x":1,"#text":["ab","c","d"],"i":["",""]}}`, `<m x="1">ab<i/><i/>cd</m>`},
+	}
+	for _, row := range table {
+		t.Run(row.xml, func(t *testing.T) {
+			jsonBytes, err := xmlEncoding{}.NewDecoder(strings.NewReader(row.xml)).MarshalJSONBytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(jsonBytes) != row.json {
+				t.Fatalf("incorrect JSON value:\nexpected: %s\ngot:      %s", row.json, jsonBytes)
+			}
+			var buf bytes.Buffer
+			if err := (xmlEncoding{}).NewEncoder(&buf).UnmarshalJSONBytes(jsonBytes, false, false); err != nil {
+				t.Fatal(err)
+			}
+			if xmlString := strings.TrimSpace(buf.String()); xmlString != row.output {
+				t.Fatalf("incorrect XML value:\nexpected: %s\ngot:      %s", row.output, xmlString)
+			}
+		})
+	}
+}
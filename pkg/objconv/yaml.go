@@ -5,9 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 
 	"github.com/alecthomas/chroma/quick"
-	"github.com/ghodss/yaml"
 	goyaml "gopkg.in/yaml.v2"
 )
 
@@ -35,13 +38,13 @@ type yamlDecoder struct {
 }
 
 func (d *yamlDecoder) MarshalJSONBytes() ([]byte, error) {
-	var tmp interface{}
-	err := d.decoder.Decode(&tmp)
+	var doc orderedYAMLValue
+	err := d.decoder.Decode(&doc)
 	if err != nil {
 		return nil, err
 	}
 
-	jsonObj, err := convertToJSONableObject(tmp, nil)
+	jsonObj, err := convertToJSONableObject(doc.value, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -53,6 +56,78 @@ func (d *yamlDecoder) MarshalJSONBytes() ([]byte, error) {
 	return b, nil
 }
 
+// orderedYAMLValue decodes a YAML node, keeping the order of the keys of its
+// mappings by decoding them as yaml.MapSlices and the text of its numbers by
+// decoding them as json.Numbers.
+type orderedYAMLValue struct {
+	value interface{}
+}
+
+func (v *orderedYAMLValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// Nulls are never passed to UnmarshalYAML, so each attempt only fails
+	// when the node is of another kind.
+	var sequence []orderedYAMLValue
+	if err := unmarshal(&sequence); err == nil {
+		values := make([]interface{}, len(sequence))
+		for i, elem := range sequence {
+			values[i] = elem.value
+		}
+		v.value = values
+		return nil
+	}
+
+	var mapping map[orderedYAMLKey]orderedYAMLValue
+	if err := unmarshal(&mapping); err == nil {
+		keys := make([]orderedYAMLKey, 0, len(mapping))
+		for key := range mapping {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].seq < keys[j].seq })
+
+		items := make(goyaml.MapSlice, len(keys))
+		for i, key := range keys {
+			items[i] = goyaml.MapItem{Key: key.value, Value: mapping[key].value}
+		}
+		v.value = items
+		return nil
+	}
+
+	if err := unmarshal(&v.value); err != nil {
+		return err
+	}
+	switch v.value.(type) {
+	case int, int64, uint64, float64:
+		// Keep the exact text of numbers that are also valid in JSON.
+		var text string
+		if err := unmarshal(&text); err == nil && isJSONNumber(text) {
+			v.value = json.Number(text)
+		}
+	}
+	return nil
+}
+
+// yamlKeySeq is incremented each time an orderedYAMLKey is decoded.
+var yamlKeySeq uint64
+
+// orderedYAMLKey is the key of a mapping. Keys are decoded in the order they
+// appear in their mapping, so sorting them by seq restores that order.
+type orderedYAMLKey struct {
+	value interface{}
+	seq   uint64
+}
+
+func (k *orderedYAMLKey) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&k.value); err != nil {
+		return err
+	}
+	switch k.value.(type) {
+	case goyaml.MapSlice, []interface{}:
+		return fmt.Errorf("invalid map key: %#v", k.value)
+	}
+	k.seq = atomic.AddUint64(&yamlKeySeq, 1)
+	return nil
+}
+
 type yamlEncoder struct {
 	w        io.Writer
 	writeSep bool
@@ -73,7 +148,91 @@ func (e *yamlEncoder) UnmarshalJSONBytes(jsonBytes []byte, color, pretty bool) e
 }
 
 func (yamlEncoder) unmarshalJSONBytes(jsonBytes []byte) ([]byte, error) {
-	return yaml.JSONToYAML(jsonBytes)
+	obj, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+	numbers := newYAMLNumbers(jsonBytes)
+	yamlBytes, err := goyaml.Marshal(yamlValue(obj, numbers))
+	if err != nil {
+		return nil, err
+	}
+	return numbers.restore(yamlBytes), nil
+}
+
+// yamlValue converts a value decoded by decodeOrderedJSON to the types
+// yaml.Marshal writes, keeping the order of the keys of objects.
+func yamlValue(v interface{}, numbers *yamlNumbers) interface{} {
+	switch v := v.(type) {
+	case orderedObject:
+		mapping := make(goyaml.MapSlice, len(v))
+		for i, field := range v {
+			mapping[i] = goyaml.MapItem{Key: field.key, Value: yamlValue(field.value, numbers)}
+		}
+		return mapping
+	case []interface{}:
+		sequence := make([]interface{}, len(v))
+		for i, elem := range v {
+			sequence[i] = yamlValue(elem, numbers)
+		}
+		return sequence
+	case json.Number:
+		return numbers.number(v)
+	}
+	return v
+}
+
+// yamlNumbers keeps the exact text of the numbers of a document that
+// yaml.Marshal can't write, such as integers that don't fit in 64 bits or
+// decimals with trailing zeros. They're marshaled as placeholder strings,
+// which restore replaces with their text.
+type yamlNumbers struct {
+	prefix string
+	texts  []string
+}
+
+func newYAMLNumbers(jsonBytes []byte) *yamlNumbers {
+	// The placeholders must not appear anywhere else in the document.
+	prefix := "faq-number-"
+	for bytes.Contains(jsonBytes, []byte(prefix)) {
+		prefix += "-"
+	}
+	return &yamlNumbers{prefix: prefix}
+}
+
+// number returns the value that yaml.Marshal writes as n.
+func (numbers *yamlNumbers) number(n json.Number) interface{} {
+	text := string(n)
+	if isJSONInteger(n) {
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil && strconv.FormatInt(i, 10) == text {
+			return i
+		}
+		if u, err := strconv.ParseUint(text, 10, 64); err == nil && strconv.FormatUint(u, 10) == text {
+			return u
+		}
+	} else if f, err := n.Float64(); err == nil && strconv.FormatFloat(f, 'g', -1, 64) == text {
+		return f
+	}
+
+	numbers.texts = append(numbers.texts, text)
+	return numbers.placeholder(len(numbers.texts) - 1)
+}
+
+func (numbers *yamlNumbers) placeholder(i int) string {
+	return fmt.Sprintf("%s%d-", numbers.prefix, i)
+}
+
+// restore replaces the placeholders in a marshaled document with the text of
+// their numbers.
+func (numbers *yamlNumbers) restore(yamlBytes []byte) []byte {
+	if len(numbers.texts) == 0 {
+		return yamlBytes
+	}
+	replacements := make([]string, 0, 2*len(numbers.texts))
+	for i, text := range numbers.texts {
+		replacements = append(replacements, numbers.placeholder(i), text)
+	}
+	return []byte(strings.NewReplacer(replacements...).Replace(string(yamlBytes)))
 }
 
 func (yamlEncoder) prettyPrint(yamlBytes []byte) ([]byte, error) { return yamlBytes, nil }
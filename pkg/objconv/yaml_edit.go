@@ -0,0 +1,953 @@
+package objconv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+var _ Editor = yamlEncoding{}
+
+// NewEditEncoder implements Editor.
+//
+// Edits are made to the text of each document: values that changed are
+// rewritten where they are, keys and sequence elements are removed along with
+// the comments above them, and new ones are added after the existing ones.
+//
+// Documents with keys that are decoded as the same key, such as on and yes,
+// can't be edited, and neither can values shared through anchors and aliases.
+func (yamlEncoding) NewEditEncoder(w io.Writer, original []byte, reencode bool) EditEncoder {
+	return &yamlEditEncoder{w: w, documents: splitYAMLDocuments(original), reencode: reencode, last: -1}
+}
+
+type yamlEditEncoder struct {
+	w         io.Writer
+	documents [][]byte
+	reencode  bool
+	// last is the index of the last document that was edited, and written
+	// counts the values that were written.
+	last    int
+	written int
+}
+
+func (e *yamlEditEncoder) EditJSONBytes(document int, jsonBytes []byte, color, pretty bool) error {
+	var out []byte
+	if document < len(e.documents) {
+		err := checkEditable(document, e.last)
+		if err == nil {
+			// Lines are numbered from the start of the file.
+			line := 1
+			for _, document := range e.documents[:document] {
+				line += bytes.Count(document, []byte("\n"))
+			}
+			out, err = editYAMLDocument(e.documents[document], line, jsonBytes)
+			if err != nil {
+				err = &EditError{Document: document, Err: err}
+			} else {
+				e.last = document
+			}
+		}
+		if err != nil && !e.reencode {
+			return err
+		}
+	}
+	if out == nil {
+		encoded, err := (yamlEncoder{}).unmarshalJSONBytes(jsonBytes)
+		if err != nil {
+			return fmt.Errorf("failed to encode as: %s", err)
+		}
+		if e.written > 0 {
+			encoded = append([]byte(yamlSeparator+"\n"), encoded...)
+		}
+		out = encoded
+	}
+	e.written++
+
+	if color {
+		colored, err := (yamlEncoder{}).color(out)
+		if err != nil {
+			return fmt.Errorf("failed to encode as colored: %s", err)
+		}
+		out = colored
+	}
+	_, err := e.w.Write(out)
+	return err
+}
+
+// splitYAMLDocuments splits a YAML stream before each of its document
+// markers. Comments and directives before the first marker are part of the
+// first document.
+func splitYAMLDocuments(src []byte) [][]byte {
+	var documents [][]byte
+	start := 0
+	header := true
+	for offset := 0; offset < len(src); {
+		end := bytes.IndexByte(src[offset:], '\n') + 1
+		if end == 0 {
+			end = len(src) - offset
+		}
+		line := src[offset : offset+end]
+		if isYAMLDocumentMarker(line) && !(header && len(documents) == 0) {
+			documents = append(documents, src[start:offset])
+			start = offset
+		}
+		if isYAMLDocumentMarker(line) {
+			header = false
+		}
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] != '#' && trimmed[0] != '%' {
+			header = false
+		}
+		offset += end
+	}
+	return append(documents, src[start:])
+}
+
+func isYAMLDocumentMarker(line []byte) bool {
+	line = bytes.TrimRight(line, "\r\n")
+	return bytes.HasPrefix(line, []byte(yamlSeparator)) &&
+		(len(line) == len(yamlSeparator) || line[len(yamlSeparator)] == ' ' || line[len(yamlSeparator)] == '\t')
+}
+
+// editYAMLDocument returns the YAML document src, which starts at line of its
+// file, edited to have the value of jsonBytes, or the reason it can't be.
+func editYAMLDocument(src []byte, line int, jsonBytes []byte) ([]byte, error) {
+	desired, err := decodeOrderedJSON(jsonBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc yaml3.Node
+	if err := yaml3.Unmarshal(src, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yaml3.DocumentNode || len(doc.Content) != 1 {
+		return nil, errors.New("it has no value to edit")
+	}
+	root := doc.Content[0]
+
+	e := newYAMLEditor(src, root)
+	e.firstLine = line
+	ok := e.edit(root, desired)
+	if e.err != nil {
+		return nil, e.err
+	}
+	if !ok {
+		// Rewrite the whole value, keeping the comments around it.
+		text, ok := e.render(yamlNode(desired), 0)
+		if !ok {
+			return nil, errEditMismatch
+		}
+		e.edits = []textEdit{{e.start(root), e.end(root), text}}
+	}
+	out := applyEdits(src, e.edits)
+
+	// The edited document must decode to the value it was edited to have.
+	data, err := (yamlEncoding{}).NewDecoder(bytes.NewReader(out)).MarshalJSONBytes()
+	if err != nil {
+		return nil, errEditMismatch
+	}
+	if value, err := decodeOrderedJSON(data); err != nil || !valuesEqual(value, desired) {
+		return nil, errEditMismatch
+	}
+	return out, nil
+}
+
+// yamlEditor edits the text of a YAML document using the positions of the
+// nodes it was parsed into.
+type yamlEditor struct {
+	src   []byte
+	lines []int
+	edits []textEdit
+
+	// indent is the indentation of the nested mappings of the document.
+	indent int
+
+	ends   map[*yaml3.Node]int
+	values map[*yaml3.Node]interface{}
+
+	// firstLine is the line of its file the document starts at.
+	firstLine int
+	// err is the reason the document can't be edited at all, if it can't.
+	err error
+}
+
+func newYAMLEditor(src []byte, root *yaml3.Node) *yamlEditor {
+	e := &yamlEditor{
+		src:    src,
+		lines:  []int{0},
+		indent: 2,
+		ends:   make(map[*yaml3.Node]int),
+		values: make(map[*yaml3.Node]interface{}),
+	}
+	for i, c := range src {
+		if c == '\n' {
+			e.lines = append(e.lines, i+1)
+		}
+	}
+	e.detectIndent(root)
+	e.setEnds(root, len(src))
+	return e
+}
+
+// detectIndent sets the indentation of nested mappings to the first one used
+// by the document.
+func (e *yamlEditor) detectIndent(n *yaml3.Node) bool {
+	if n.Kind == yaml3.MappingNode && n.Style&yaml3.FlowStyle == 0 {
+		for i := 1; i < len(n.Content); i += 2 {
+			value := n.Content[i]
+			if value.Kind == yaml3.MappingNode && value.Style&yaml3.FlowStyle == 0 && value.Line > n.Content[i-1].Line {
+				if indent := value.Column - n.Content[i-1].Column; indent >= 2 {
+					e.indent = indent
+					return true
+				}
+			}
+		}
+	}
+	for _, child := range n.Content {
+		if e.detectIndent(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// start returns the offset of the first byte of n, including its anchor and
+// tag.
+func (e *yamlEditor) start(n *yaml3.Node) int {
+	if n.Line < 1 || n.Line > len(e.lines) {
+		return len(e.src)
+	}
+	offset := e.lines[n.Line-1]
+	for column := 1; column < n.Column && offset < len(e.src); column++ {
+		_, size := utf8.DecodeRune(e.src[offset:])
+		offset += size
+	}
+	return offset
+}
+
+// end returns the offset after the last byte of n.
+func (e *yamlEditor) end(n *yaml3.Node) int {
+	if end, ok := e.ends[n]; ok {
+		return end
+	}
+	return e.start(n)
+}
+
+// setEnds records the end of n and of its descendants. Nothing that belongs
+// to n comes at or after limit.
+func (e *yamlEditor) setEnds(n *yaml3.Node, limit int) {
+	switch {
+	case (n.Kind == yaml3.MappingNode || n.Kind == yaml3.SequenceNode) && n.Style&yaml3.FlowStyle != 0:
+		if end, ok := e.flowEnd(e.start(n)); ok {
+			e.ends[n] = end
+		}
+	case n.Kind == yaml3.MappingNode || n.Kind == yaml3.SequenceNode:
+		step := 1
+		if n.Kind == yaml3.MappingNode {
+			step = 2
+		}
+		for i := 0; i < len(n.Content); i += step {
+			next := limit
+			if i+step < len(n.Content) {
+				next = e.start(n.Content[i+step])
+				if n.Kind == yaml3.SequenceNode {
+					if dash, ok := e.dash(n.Content[i+step]); ok {
+						next = dash
+					}
+				}
+			}
+			for _, child := range n.Content[i : i+step] {
+				e.setEnds(child, next)
+			}
+		}
+		if len(n.Content) > 0 {
+			e.ends[n] = e.end(n.Content[len(n.Content)-1])
+		}
+	case n.Kind == yaml3.AliasNode:
+		e.ends[n] = e.start(n) + len("*"+n.Value)
+	case n.Kind == yaml3.ScalarNode:
+		if end, ok := e.scalarEnd(n); ok {
+			e.ends[n] = end
+		} else {
+			e.ends[n] = e.trimBack(limit)
+		}
+	}
+}
+
+// scalarEnd returns the end of a scalar that's on a single line.
+func (e *yamlEditor) scalarEnd(n *yaml3.Node) (int, bool) {
+	start := e.start(n)
+	switch {
+	case n.Style&yaml3.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(e.src) && e.src[start] == '"'; i++ {
+			switch e.src[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, true
+			case '\n':
+				return 0, false
+			}
+		}
+	case n.Style&yaml3.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(e.src) && e.src[start] == '\''; i++ {
+			switch e.src[i] {
+			case '\'':
+				if i+1 < len(e.src) && e.src[i+1] == '\'' {
+					i++
+					continue
+				}
+				return i + 1, true
+			case '\n':
+				return 0, false
+			}
+		}
+	case n.Style&(yaml3.LiteralStyle|yaml3.FoldedStyle|yaml3.TaggedStyle) == 0 && n.Value != "":
+		// A plain scalar is on a single line if its value is its text.
+		if end := start + len(n.Value); end <= len(e.src) && string(e.src[start:end]) == n.Value {
+			return end, true
+		}
+	}
+	return 0, false
+}
+
+// flowEnd returns the end of the flow collection starting at start.
+func (e *yamlEditor) flowEnd(start int) (int, bool) {
+	depth := 0
+	for i := start; i < len(e.src); i++ {
+		switch e.src[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				return i + 1, true
+			}
+		case '"':
+			for i++; i < len(e.src) && e.src[i] != '"'; i++ {
+				if e.src[i] == '\\' {
+					i++
+				}
+			}
+		case '\'':
+			for i++; i < len(e.src) && (e.src[i] != '\'' || (i+1 < len(e.src) && e.src[i+1] == '\'')); i++ {
+				if e.src[i] == '\'' {
+					i++
+				}
+			}
+		case '#':
+			if i > 0 && (e.src[i-1] == ' ' || e.src[i-1] == '\t' || e.src[i-1] == '\n') {
+				for i < len(e.src) && e.src[i] != '\n' {
+					i++
+				}
+			}
+		}
+	}
+	return 0, false
+}
+
+// trimBack returns the offset before the blank and comment lines that come
+// before limit.
+func (e *yamlEditor) trimBack(limit int) int {
+	end := limit
+	for {
+		for end > 0 && strings.IndexByte(" \t\r\n", e.src[end-1]) >= 0 {
+			end--
+		}
+		lineStart := bytes.LastIndexByte(e.src[:end], '\n') + 1
+		if !bytes.HasPrefix(bytes.TrimLeft(e.src[lineStart:end], " \t"), []byte("#")) {
+			return end
+		}
+		end = lineStart
+	}
+}
+
+// lineStart returns the offset of the start of the line containing offset.
+func (e *yamlEditor) lineStart(offset int) int {
+	return bytes.LastIndexByte(e.src[:offset], '\n') + 1
+}
+
+// lineEnd returns the offset of the newline ending the line containing
+// offset.
+func (e *yamlEditor) lineEnd(offset int) int {
+	if i := bytes.IndexByte(e.src[offset:], '\n'); i >= 0 {
+		return offset + i
+	}
+	return len(e.src)
+}
+
+// startsLine reports whether only indentation comes before offset on its line.
+func (e *yamlEditor) startsLine(offset int) bool {
+	return len(bytes.Trim(e.src[e.lineStart(offset):offset], " ")) == 0
+}
+
+// headStart returns the start of the line containing offset, or of the
+// comment lines directly above it.
+func (e *yamlEditor) headStart(offset int) int {
+	start := e.lineStart(offset)
+	for start > 0 {
+		previous := e.lineStart(start - 1)
+		if !bytes.HasPrefix(bytes.TrimLeft(e.src[previous:start], " \t"), []byte("#")) {
+			break
+		}
+		start = previous
+	}
+	return start
+}
+
+// removal returns the edit that removes the lines from start to end, along
+// with the comments above them.
+func (e *yamlEditor) removal(start, end int) textEdit {
+	end = e.lineEnd(end)
+	if end < len(e.src) {
+		end++
+	}
+	return textEdit{e.headStart(start), end, ""}
+}
+
+// dash returns the offset of the "-" of a block sequence element.
+func (e *yamlEditor) dash(item *yaml3.Node) (int, bool) {
+	i := e.start(item) - 1
+	for i >= 0 && e.src[i] == ' ' {
+		i--
+	}
+	if i < 0 || e.src[i] != '-' {
+		return 0, false
+	}
+	return i, true
+}
+
+// column returns the column of offset on its line.
+func (e *yamlEditor) column(offset int) int {
+	return utf8.RuneCount(e.src[e.lineStart(offset):offset])
+}
+
+// value returns the value of n, as decoded by the YAML Decoder.
+func (e *yamlEditor) value(n *yaml3.Node) (interface{}, bool) {
+	if value, ok := e.values[n]; ok {
+		return value, true
+	}
+
+	var value interface{}
+	switch n.Kind {
+	case yaml3.AliasNode:
+		if n.Alias == nil {
+			return nil, false
+		}
+		return e.value(n.Alias)
+	case yaml3.ScalarNode:
+		var err error
+		value, err = decodeYAMLText(yamlScalarText(n))
+		if err != nil {
+			return nil, false
+		}
+	case yaml3.SequenceNode:
+		values := make([]interface{}, 0, len(n.Content))
+		for _, item := range n.Content {
+			v, ok := e.value(item)
+			if !ok {
+				return nil, false
+			}
+			values = append(values, v)
+		}
+		value = values
+	case yaml3.MappingNode:
+		obj := orderedObject{}
+		var merged []orderedObject
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if isYAMLMergeKey(n.Content[i]) {
+				for _, source := range yamlMergeSources(n.Content[i+1]) {
+					v, ok := e.value(source)
+					mapping, isMapping := v.(orderedObject)
+					if !ok || !isMapping {
+						return nil, false
+					}
+					merged = append(merged, mapping)
+				}
+				continue
+			}
+			key, ok := yamlKey(n.Content[i])
+			if !ok {
+				return nil, false
+			}
+			v, ok := e.value(n.Content[i+1])
+			if !ok {
+				return nil, false
+			}
+			obj = obj.set(key, v)
+		}
+		// Explicit keys override merged ones, and earlier merges override
+		// later ones.
+		for _, mapping := range merged {
+			for _, field := range mapping {
+				if _, ok := obj.get(field.key); !ok {
+					obj = append(obj, field)
+				}
+			}
+		}
+		value = obj
+	default:
+		return nil, false
+	}
+
+	e.values[n] = value
+	return value, true
+}
+
+// yamlScalarText returns YAML that has the value of the scalar n when decoded
+// on its own.
+func yamlScalarText(n *yaml3.Node) string {
+	text := n.Value
+	if n.Style&(yaml3.DoubleQuotedStyle|yaml3.SingleQuotedStyle|yaml3.LiteralStyle|yaml3.FoldedStyle) != 0 {
+		quoted, _ := marshalJSONValue(n.Value)
+		text = string(quoted)
+	}
+	if n.Style&yaml3.TaggedStyle != 0 {
+		text = n.Tag + " " + text
+	}
+	return text
+}
+
+// decodeYAMLText decodes a YAML value with the YAML Decoder.
+func decodeYAMLText(text string) (interface{}, error) {
+	data, err := (yamlEncoding{}).NewDecoder(strings.NewReader(text)).MarshalJSONBytes()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeOrderedJSON(data)
+}
+
+// yamlKey returns the key that the YAML Decoder decodes the key n as.
+func yamlKey(n *yaml3.Node) (string, bool) {
+	if n.Kind != yaml3.ScalarNode {
+		return "", false
+	}
+	value, err := decodeYAMLText(yamlScalarText(n) + ": null")
+	obj, ok := value.(orderedObject)
+	if err != nil || !ok || len(obj) != 1 {
+		return "", false
+	}
+	return obj[0].key, true
+}
+
+func isYAMLMergeKey(n *yaml3.Node) bool {
+	return n.Kind == yaml3.ScalarNode && n.Tag == "!!merge"
+}
+
+// yamlMergeSources returns the mappings merged by the value of a merge key.
+func yamlMergeSources(n *yaml3.Node) []*yaml3.Node {
+	if n.Kind != yaml3.SequenceNode {
+		return []*yaml3.Node{n}
+	}
+	return n.Content
+}
+
+// line returns the line of the file n is at.
+func (e *yamlEditor) line(n *yaml3.Node) int {
+	return e.firstLine + n.Line - 1
+}
+
+// fail records the reason the document can't be edited, unless one has
+// already been recorded.
+func (e *yamlEditor) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+// edit edits n to have the value desired, reporting whether it could. If it
+// couldn't, no edits were made.
+func (e *yamlEditor) edit(n *yaml3.Node, desired interface{}) bool {
+	current, ok := e.value(n)
+	if !ok {
+		return false
+	}
+	if valuesEqual(current, desired) {
+		return true
+	}
+	// Changing an anchored node would also change its aliases, and
+	// replacing an alias would unshare its value.
+	if n.Anchor != "" || n.Kind == yaml3.AliasNode {
+		e.fail(fmt.Errorf("line %d: the value is shared through an anchor and its aliases", e.line(n)))
+		return false
+	}
+
+	edits := len(e.edits)
+	ok = false
+	switch {
+	case n.Style&yaml3.FlowStyle != 0:
+		ok = e.replaceInline(n, desired)
+	case n.Kind == yaml3.MappingNode:
+		if obj, isObj := desired.(orderedObject); isObj {
+			ok = e.editMapping(n, obj)
+		}
+	case n.Kind == yaml3.SequenceNode:
+		if array, isArray := desired.([]interface{}); isArray {
+			ok = e.editSequence(n, array)
+		}
+	case n.Kind == yaml3.ScalarNode:
+		ok = e.replaceInline(n, desired)
+	}
+	if !ok {
+		e.edits = e.edits[:edits]
+	}
+	return ok
+}
+
+// replaceInline replaces a scalar or a flow collection with a value that can
+// be written on the same line.
+func (e *yamlEditor) replaceInline(n *yaml3.Node, desired interface{}) bool {
+	end, ok := e.ends[n]
+	if !ok {
+		return false
+	}
+	if n.Kind == yaml3.ScalarNode {
+		if _, ok := e.scalarEnd(n); !ok {
+			return false
+		}
+	}
+
+	node := yamlNode(desired)
+	switch {
+	case node.Kind == yaml3.ScalarNode && n.Kind == yaml3.ScalarNode && node.Tag == "!!str":
+		// Keep the quotes of the string being replaced.
+		if quotes := n.Style & (yaml3.DoubleQuotedStyle | yaml3.SingleQuotedStyle); quotes != 0 {
+			node.Style = quotes
+		}
+	case node.Kind != yaml3.ScalarNode && (n.Style&yaml3.FlowStyle != 0 || len(node.Content) == 0):
+		node.Style = yaml3.FlowStyle
+	case node.Kind != yaml3.ScalarNode:
+		return false
+	}
+	text, ok := e.render(node, 0)
+	if !ok || strings.Contains(text, "\n") {
+		return false
+	}
+	e.edits = append(e.edits, textEdit{e.start(n), end, text})
+	return true
+}
+
+// editMapping edits the pairs of a block mapping.
+func (e *yamlEditor) editMapping(n *yaml3.Node, desired orderedObject) bool {
+	if len(desired) == 0 {
+		return false
+	}
+
+	// The keys that only come from merged mappings can't be removed, and
+	// don't have to be added if their values are unchanged.
+	value, _ := e.value(n)
+	current := value.(orderedObject)
+	// Keys written differently that are decoded as the same key, such as on
+	// and yes, can't be told apart, so none of them can be edited.
+	explicit := make(map[string]*yaml3.Node)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := n.Content[i]
+		if isYAMLMergeKey(key) {
+			continue
+		}
+		name, ok := yamlKey(key)
+		if !ok {
+			return false
+		}
+		if other, ok := explicit[name]; ok {
+			e.fail(fmt.Errorf("line %d: the keys %s and %s are both decoded as %q", e.line(key), yamlScalarText(other), yamlScalarText(key), name))
+			return false
+		}
+		explicit[name] = key
+	}
+
+	// New keys are added after the last key that's kept.
+	var lastValue *yaml3.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if isYAMLMergeKey(key) {
+			continue
+		}
+		name, _ := yamlKey(key)
+
+		v, ok := desired.get(name)
+		switch {
+		case ok:
+			if !e.edit(value, v) && !e.replacePair(key, value, v) {
+				return false
+			}
+			lastValue = value
+		case e.mergedKey(n, name):
+			return false
+		default:
+			if !e.startsLine(e.start(key)) {
+				return false
+			}
+			e.edits = append(e.edits, e.removal(e.start(key), e.end(value)))
+		}
+	}
+	for _, field := range current {
+		if _, ok := desired.get(field.key); !ok && explicit[field.key] == nil {
+			return false
+		}
+	}
+
+	var added orderedObject
+	for _, field := range desired {
+		if explicit[field.key] != nil {
+			continue
+		}
+		if v, ok := current.get(field.key); ok && valuesEqual(v, field.value) {
+			continue
+		}
+		// A key written like one that's decoded as something else, such as
+		// y next to y: 1, which is decoded as "true", would be a duplicate
+		// for YAML 1.2 parsers.
+		for name, key := range explicit {
+			if key.Value == field.key {
+				e.fail(fmt.Errorf("line %d: the key %q would be a duplicate of %s, which is decoded as %q", e.line(key), field.key, yamlScalarText(key), name))
+				return false
+			}
+		}
+		added = append(added, field)
+	}
+	if len(added) == 0 {
+		return true
+	}
+	if lastValue == nil {
+		return false
+	}
+
+	column := n.Content[0].Column - 1
+	var text strings.Builder
+	for _, field := range added {
+		pair := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map", Content: []*yaml3.Node{yamlNode(field.key), yamlNode(field.value)}}
+		rendered, ok := e.render(pair, column)
+		if !ok {
+			return false
+		}
+		text.WriteString("\n" + strings.Repeat(" ", column) + rendered)
+	}
+	offset := e.lineEnd(e.end(lastValue))
+	e.edits = append(e.edits, textEdit{offset, offset, text.String()})
+	return true
+}
+
+// mergedKey reports whether a merge key of the mapping n provides key.
+func (e *yamlEditor) mergedKey(n *yaml3.Node, key string) bool {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if !isYAMLMergeKey(n.Content[i]) {
+			continue
+		}
+		for _, source := range yamlMergeSources(n.Content[i+1]) {
+			v, _ := e.value(source)
+			if obj, ok := v.(orderedObject); ok {
+				if _, ok := obj.get(key); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// replacePair rewrites a pair of a block mapping with a new value, keeping
+// its key as it's written.
+func (e *yamlEditor) replacePair(key, value *yaml3.Node, desired interface{}) bool {
+	keyEnd, ok := e.scalarEnd(key)
+	if !ok {
+		return false
+	}
+	colon := keyEnd
+	for colon < len(e.src) && (e.src[colon] == ' ' || e.src[colon] == '\t') {
+		colon++
+	}
+	if colon == len(e.src) || e.src[colon] != ':' {
+		return false
+	}
+
+	// The pair is rendered with a one letter key, which is replaced with
+	// the key as it's written.
+	pair := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map", Content: []*yaml3.Node{yamlNode("k"), yamlNode(desired)}}
+	text, ok := e.render(pair, key.Column-1)
+	if !ok {
+		return false
+	}
+	text = string(e.src[e.start(key):colon]) + text[1:]
+
+	e.edits = append(e.edits, textEdit{e.start(key), e.end(value), text})
+	return true
+}
+
+// editSequence edits the elements of a block sequence. Elements that are
+// equal to desired ones are kept, and those in between are edited in turn.
+func (e *yamlEditor) editSequence(n *yaml3.Node, desired []interface{}) bool {
+	if len(desired) == 0 {
+		return false
+	}
+	items := n.Content
+	firstDash, ok := e.dash(items[0])
+	if !ok {
+		return false
+	}
+	column := e.column(firstDash)
+
+	// Match the elements that are unchanged, and edit, remove or add the
+	// ones between them.
+	matches := e.matchElements(items, desired)
+	i, j := 0, 0
+	for _, match := range append(matches, [2]int{len(items), len(desired)}) {
+		for ; i < match[0] && j < match[1]; i, j = i+1, j+1 {
+			if !e.edit(items[i], desired[j]) && !e.replaceElement(items[i], desired[j], column) {
+				return false
+			}
+		}
+		for ; i < match[0]; i++ {
+			dash, ok := e.dash(items[i])
+			if !ok || !e.startsLine(dash) {
+				return false
+			}
+			e.edits = append(e.edits, e.removal(dash, e.end(items[i])))
+		}
+		if j < match[1] {
+			var text strings.Builder
+			for ; j < match[1]; j++ {
+				rendered, ok := e.render(&yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq", Content: []*yaml3.Node{yamlNode(desired[j])}}, column)
+				if !ok {
+					return false
+				}
+				text.WriteString("\n" + strings.Repeat(" ", column) + rendered)
+			}
+			if i == 0 {
+				// Add the elements before the first one.
+				if !e.startsLine(firstDash) {
+					return false
+				}
+				offset := e.headStart(firstDash)
+				e.edits = append(e.edits, textEdit{offset, offset, text.String()[1:] + "\n"})
+			} else {
+				offset := e.lineEnd(e.end(items[i-1]))
+				e.edits = append(e.edits, textEdit{offset, offset, text.String()})
+			}
+		}
+		i, j = i+1, j+1
+	}
+	return true
+}
+
+// matchElements returns the indexes of the elements of items and desired that
+// are equal, as the longest sequence of matching elements in order.
+func (e *yamlEditor) matchElements(items []*yaml3.Node, desired []interface{}) [][2]int {
+	// Long sequences are only edited element by element.
+	if len(items)*len(desired) > 1<<20 {
+		return nil
+	}
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		values[i], _ = e.value(item)
+	}
+
+	// lengths[i][j] is the length of the longest common subsequence of
+	// values[i:] and desired[j:].
+	lengths := make([][]int, len(values)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(desired)+1)
+	}
+	for i := len(values) - 1; i >= 0; i-- {
+		for j := len(desired) - 1; j >= 0; j-- {
+			switch {
+			case valuesEqual(values[i], desired[j]):
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] >= lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var matches [][2]int
+	for i, j := 0, 0; i < len(values) && j < len(desired); {
+		switch {
+		case valuesEqual(values[i], desired[j]):
+			matches = append(matches, [2]int{i, j})
+			i, j = i+1, j+1
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+// replaceElement rewrites an element of a block sequence.
+func (e *yamlEditor) replaceElement(item *yaml3.Node, desired interface{}, column int) bool {
+	dash, ok := e.dash(item)
+	if !ok {
+		return false
+	}
+	text, ok := e.render(&yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq", Content: []*yaml3.Node{yamlNode(desired)}}, column)
+	if !ok {
+		return false
+	}
+	e.edits = append(e.edits, textEdit{dash, e.end(item), text})
+	return true
+}
+
+// render returns the YAML of n, with the indentation of the document, for
+// writing at column. The lines after the first are indented to the column.
+func (e *yamlEditor) render(n *yaml3.Node, column int) (string, bool) {
+	var buf bytes.Buffer
+	encoder := yaml3.NewEncoder(&buf)
+	encoder.SetIndent(e.indent)
+	if err := encoder.Encode(n); err != nil {
+		return "", false
+	}
+	if err := encoder.Close(); err != nil {
+		return "", false
+	}
+	text := strings.TrimSuffix(buf.String(), "\n")
+	return strings.ReplaceAll(text, "\n", "\n"+strings.Repeat(" ", column)), true
+}
+
+// yamlNode returns a node for a value decoded by decodeOrderedJSON.
+func yamlNode(v interface{}) *yaml3.Node {
+	switch v := v.(type) {
+	case orderedObject:
+		n := &yaml3.Node{Kind: yaml3.MappingNode, Tag: "!!map"}
+		for _, field := range v {
+			n.Content = append(n.Content, yamlNode(field.key), yamlNode(field.value))
+		}
+		return n
+	case []interface{}:
+		n := &yaml3.Node{Kind: yaml3.SequenceNode, Tag: "!!seq"}
+		for _, elem := range v {
+			n.Content = append(n.Content, yamlNode(elem))
+		}
+		return n
+	case string:
+		n := &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!str", Value: v}
+		// yaml.v3 writes strings such as yes and on without quotes, which
+		// the YAML Decoder reads as booleans.
+		if decoded, err := decodeYAMLText(v); !strings.Contains(v, "\n") && (err != nil || decoded != v) {
+			n.Style = yaml3.DoubleQuotedStyle
+		}
+		return n
+	case json.Number:
+		// Integers that don't fit in 64 bits are floats to yaml.v3.
+		tag := "!!float"
+		if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			tag = "!!int"
+		}
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Tag: tag, Value: string(v)}
+	case bool:
+		return &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}
+	}
+	return &yaml3.Node{Kind: yaml3.ScalarNode, Tag: "!!null", Value: "null"}
+}
@@ -1,7 +1,8 @@
 // Package objconv contains code for converting formats isomorphic with JSON.
 //
 // This file contains code from https://github.com/ghodss/yaml/tree/c7ce16629ff4cd059ed96ed06419dd3856fd3577
-// it contains convertToJSONableObject from yaml.go and all of fields.go
+// it contains convertToJSONableObject from yaml.go, extended to handle
+// yaml.MapSlice, and all of fields.go
 //
 // Copyright 2013 The Go Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
@@ -20,6 +21,8 @@ import (
 	"sync"
 	"unicode"
 	"unicode/utf8"
+
+	goyaml "gopkg.in/yaml.v2"
 )
 
 // indirect walks down v allocating pointers as needed,
@@ -532,49 +535,11 @@ func convertToJSONableObject(yamlObj interface{}, jsonTarget *reflect.Value) (in
 	// field back into this function.
 	switch typedYAMLObj := yamlObj.(type) {
 	case map[interface{}]interface{}:
-		// JSON does not support arbitrary keys in a map, so we must convert
-		// these keys to strings.
-		//
-		// From my reading of go-yaml v2 (specifically the resolve function),
-		// keys can only have the types string, int, int64, float64, binary
-		// (unsupported), or null (unsupported).
 		strMap := make(map[string]interface{})
 		for k, v := range typedYAMLObj {
-			// Resolve the key to a string first.
-			var keyString string
-			switch typedKey := k.(type) {
-			case string:
-				keyString = typedKey
-			case int:
-				keyString = strconv.Itoa(typedKey)
-			case int64:
-				// go-yaml will only return an int64 as a key if the system
-				// architecture is 32-bit and the key's value is between 32-bit
-				// and 64-bit. Otherwise the key type will simply be int.
-				keyString = strconv.FormatInt(typedKey, 10)
-			case float64:
-				// Stolen from go-yaml to use the same conversion to string as
-				// the go-yaml library uses to convert float to string when
-				// Marshaling.
-				s := strconv.FormatFloat(typedKey, 'g', -1, 32)
-				switch s {
-				case "+Inf":
-					s = ".inf"
-				case "-Inf":
-					s = "-.inf"
-				case "NaN":
-					s = ".nan"
-				}
-				keyString = s
-			case bool:
-				if typedKey {
-					keyString = "true"
-				} else {
-					keyString = "false"
-				}
-			default:
-				return nil, fmt.Errorf("Unsupported map key of type: %s, key: %+#v, value: %+#v",
-					reflect.TypeOf(k), k, v)
+			keyString, err := yamlKeyString(k, v)
+			if err != nil {
+				return nil, err
 			}
 
 			// jsonTarget should be a struct or a map. If it's a struct, find
@@ -627,6 +592,21 @@ func convertToJSONableObject(yamlObj interface{}, jsonTarget *reflect.Value) (in
 			}
 		}
 		return strMap, nil
+	case goyaml.MapSlice:
+		// A MapSlice keeps the order of the mapping's keys.
+		obj := make(orderedObject, 0, len(typedYAMLObj))
+		for _, item := range typedYAMLObj {
+			keyString, err := yamlKeyString(item.Key, item.Value)
+			if err != nil {
+				return nil, err
+			}
+			value, err := convertToJSONableObject(item.Value, nil)
+			if err != nil {
+				return nil, err
+			}
+			obj = obj.set(keyString, value)
+		}
+		return obj, nil
 	case []interface{}:
 		// We need to recurse into arrays in case there are any
 		// map[interface{}]interface{}'s inside and to convert any
@@ -685,3 +665,46 @@ func convertToJSONableObject(yamlObj interface{}, jsonTarget *reflect.Value) (in
 		return yamlObj, nil
 	}
 }
+
+// yamlKeyString resolves a YAML mapping key to a string.
+func yamlKeyString(k, v interface{}) (string, error) {
+	// JSON does not support arbitrary keys in a map, so we must convert
+	// these keys to strings.
+	//
+	// From my reading of go-yaml v2 (specifically the resolve function),
+	// keys can only have the types string, int, int64, float64, binary
+	// (unsupported), or null (unsupported).
+	switch typedKey := k.(type) {
+	case string:
+		return typedKey, nil
+	case int:
+		return strconv.Itoa(typedKey), nil
+	case int64:
+		// go-yaml will only return an int64 as a key if the system
+		// architecture is 32-bit and the key's value is between 32-bit
+		// and 64-bit. Otherwise the key type will simply be int.
+		return strconv.FormatInt(typedKey, 10), nil
+	case float64:
+		// Stolen from go-yaml to use the same conversion to string as
+		// the go-yaml library uses to convert float to string when
+		// Marshaling.
+		s := strconv.FormatFloat(typedKey, 'g', -1, 32)
+		switch s {
+		case "+Inf":
+			s = ".inf"
+		case "-Inf":
+			s = "-.inf"
+		case "NaN":
+			s = ".nan"
+		}
+		return s, nil
+	case bool:
+		if typedKey {
+			return "true", nil
+		}
+		return "false", nil
+	default:
+		return "", fmt.Errorf("Unsupported map key of type: %s, key: %+#v, value: %+#v",
+			reflect.TypeOf(k), k, v)
+	}
+}
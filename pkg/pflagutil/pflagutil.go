@@ -30,8 +30,12 @@ func NewKwargJSONFlag(p *map[string]interface{}) *KwargJSONFlag {
 	}
 }
 
-// String implements pflag.Value
+// String implements pflag.Value. It's empty when there are no pairs, so that
+// no default is shown in usage.
 func (f *KwargJSONFlag) String() string {
+	if len(*f.value) == 0 {
+		return ""
+	}
 	return fmt.Sprintf("%v", *f.value)
 }
 
@@ -76,8 +80,12 @@ func NewKwargStringFlag(p *map[string]string) *KwargStringFlag {
 	}
 }
 
-// String implements pflag.Value
+// String implements pflag.Value. It's empty when there are no pairs, so that
+// no default is shown in usage.
 func (f *KwargStringFlag) String() string {
+	if len(*f.value) == 0 {
+		return ""
+	}
 	return fmt.Sprintf("%v", *f.value)
 }
 
@@ -194,3 +202,64 @@ func (f *PositionalArgStringFlag) Set(input string) error {
 func (f *PositionalArgStringFlag) Type() string {
 	return "string"
 }
+
+// JoinPairArgs returns args with each of the flags in names joined with the
+// two arguments that follow it, name and value, as --flag name=value, so that
+// flags which take two arguments like jq's --arg name value can be parsed as
+// a KwargStringFlag or KwargJSONFlag. Like pflag, flags may come before, after
+// or between positional arguments, and the flags of flags tell the values of
+// other flags apart from them. Arguments after "--" are left as is.
+func JoinPairArgs(flags *pflag.FlagSet, args []string, names ...string) ([]string, error) {
+	isPairFlag := make(map[string]bool, len(names))
+	for _, name := range names {
+		isPairFlag["--"+name] = true
+	}
+
+	joined := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(joined, args[i:]...), nil
+		case arg == "-" || !strings.HasPrefix(arg, "-"):
+			joined = append(joined, arg)
+		case isPairFlag[arg]:
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("%s takes two parameters (e.g. %s name value)", arg, arg)
+			}
+			joined = append(joined, arg, args[i+1]+"="+args[i+2])
+			i += 2
+		case takesNextArg(flags, arg) && i+1 < len(args):
+			joined = append(joined, arg, args[i+1])
+			i++
+		default:
+			joined = append(joined, arg)
+		}
+	}
+	return joined, nil
+}
+
+// takesNextArg reports whether the flag argument arg is followed by its value
+// as the next argument.
+func takesNextArg(flags *pflag.FlagSet, arg string) bool {
+	if strings.HasPrefix(arg, "--") {
+		if strings.Contains(arg, "=") {
+			return false
+		}
+		flag := flags.Lookup(arg[2:])
+		return flag != nil && flag.NoOptDefVal == ""
+	}
+
+	// Shorthands may be combined, as in -cf yaml, where the last takes the
+	// next argument if the others don't take the rest of arg as their value.
+	for i := 1; i < len(arg); i++ {
+		flag := flags.ShorthandLookup(arg[i : i+1])
+		if flag == nil {
+			return false
+		}
+		if flag.NoOptDefVal == "" {
+			return i == len(arg)-1
+		}
+	}
+	return false
+}
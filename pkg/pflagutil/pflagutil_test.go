@@ -0,0 +1,68 @@
+package pflagutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestJoinPairArgs(t *testing.T) {
+	var table = []struct {
+		name     string
+		args     []string
+		expected []string
+		err      bool
+	}{
+		{"pair", []string{"--arg", "a", "b", "."}, []string{"--arg", "a=b", "."}, false},
+		{"pairs", []string{"--arg", "a", "b", "-c", "--argjson", "c", "1", "."}, []string{"--arg", "a=b", "-c", "--argjson", "c=1", "."}, false},
+		{"joined flag", []string{"--arg=a", "b", "."}, []string{"--arg=a", "b", "."}, false},
+		{"missing value", []string{"--arg", "a"}, nil, true},
+		{"missing name and value", []string{"-c", "--arg"}, nil, true},
+		{"after --", []string{"-c", "--", "--arg", "a", "b"}, []string{"-c", "--", "--arg", "a", "b"}, false},
+		{"after positional", []string{"$x", "--arg", "x", "1", "a.json"}, []string{"$x", "--arg", "x=1", "a.json"}, false},
+		{"after stdin", []string{".", "-", "--arg", "a", "b"}, []string{".", "-", "--arg", "a=b"}, false},
+		{"positional values", []string{".", "--arg", "a", "--arg", "--arg", "b", "c"}, []string{".", "--arg", "a=--arg", "--arg", "b=c"}, false},
+		{"flag value", []string{"--input-format", "--arg", "--arg", "a", "b"}, []string{"--input-format", "--arg", "--arg", "a=b"}, false},
+		{"shorthand value", []string{"-cf", "yaml", "--arg", "a", "b", "."}, []string{"-cf", "yaml", "--arg", "a=b", "."}, false},
+		{"inline shorthand value", []string{"-fyaml", "--arg", "a", "b", "."}, []string{"-fyaml", "--arg", "a=b", "."}, false},
+	}
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringP("input-format", "f", "auto", "")
+	flags.BoolP("compact-output", "c", false, "")
+	flags.Var(NewKwargStringFlag(&map[string]string{}), "arg", "")
+	flags.Var(NewKwargJSONFlag(&map[string]interface{}{}), "argjson", "")
+
+	for _, tt := range table {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := JoinPairArgs(flags, tt.args, "arg", "argjson")
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %q", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("unexpected args:\nexpected: %q\ngot:      %q", tt.expected, args)
+			}
+		})
+	}
+}
+
+func TestKwargFlagString(t *testing.T) {
+	stringFlag := NewKwargStringFlag(&map[string]string{})
+	jsonFlag := NewKwargJSONFlag(&map[string]interface{}{})
+	if stringFlag.String() != "" || jsonFlag.String() != "" {
+		t.Errorf("expected flags without pairs to be empty, got %q and %q", stringFlag.String(), jsonFlag.String())
+	}
+
+	_ = stringFlag.Set("a=b")
+	_ = jsonFlag.Set("a=1")
+	if stringFlag.String() != "map[a:b]" || jsonFlag.String() != "map[a:1]" {
+		t.Errorf("unexpected values %q and %q", stringFlag.String(), jsonFlag.String())
+	}
+}